@@ -0,0 +1,114 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"craigstjean.com/stsummarizer/internal/config"
+	"craigstjean.com/stsummarizer/internal/services"
+	"craigstjean.com/stsummarizer/internal/services/sillytavern"
+)
+
+// commonFlags are accepted by every command
+type commonFlags struct {
+	config *config.Flags
+	user   *string
+	json   *bool
+}
+
+func newFlagSet(name string) (*flag.FlagSet, *commonFlags) {
+	fs := flag.NewFlagSet("stsum "+name, flag.ContinueOnError)
+	common := &commonFlags{
+		config: config.AddFlags(fs),
+		user:   fs.String("user", "", "SillyTavern user (default "+config.STDefaultUser+")"),
+		json:   fs.Bool("json", false, "print JSON instead of text"),
+	}
+
+	return fs, common
+}
+
+// parseArgs parses flags wherever they appear, so "stsum chats Alice --json" works as well as "stsum chats --json Alice",
+// and returns the positional arguments
+func parseArgs(fs *flag.FlagSet, args []string) ([]string, error) {
+	var positional []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, err
+		}
+		args = fs.Args()
+		if len(args) == 0 {
+			return positional, nil
+		}
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+}
+
+// app holds what commands need once the configuration is loaded
+type app struct {
+	cfg       *config.Config
+	stService services.SillyTavernService
+	user      string
+	json      bool
+}
+
+func (f *commonFlags) load() (*app, error) {
+	cfg, err := f.config.Load()
+	if err != nil {
+		return nil, fmt.Errorf("invalid configuration:\n%v", err)
+	}
+
+	user := *f.user
+	if user == "" {
+		user = config.STDefaultUser
+	}
+
+	return &app{
+		cfg:       cfg,
+		stService: sillytavern.NewService(),
+		user:      user,
+		json:      *f.json,
+	}, nil
+}
+
+// summarizer creates the summarizer the server uses, sharing its summary cache
+func (a *app) summarizer() (*services.Summarizer, error) {
+	var cleaner *services.Cleaner
+	if a.cfg.Features.Cleaning {
+		var err error
+		if cleaner, err = services.NewCleaner(); err != nil {
+			return nil, err
+		}
+	}
+
+	return services.NewSummarizer(a.stService, services.NewOllamaService(), services.NewSummaryCache(), cleaner), nil
+}
+
+func printJSON(value any) error {
+	return jsonEncoder(os.Stdout).Encode(value)
+}
+
+func jsonEncoder(w io.Writer) *json.Encoder {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder
+}
+
+// printList prints one item per line, or a JSON array
+func (a *app) printList(items []string) error {
+	if a.json {
+		if items == nil {
+			items = []string{}
+		}
+		return printJSON(items)
+	}
+
+	if len(items) > 0 {
+		fmt.Println(strings.Join(items, "\n"))
+	}
+	return nil
+}
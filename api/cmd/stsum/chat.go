@@ -0,0 +1,232 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"craigstjean.com/stsummarizer/internal/models"
+	"craigstjean.com/stsummarizer/internal/services"
+)
+
+// chatFlags select a chat and the messages to read from it, for show and export
+type chatFlags struct {
+	group      *bool
+	visibility *string
+	regex      *string
+	lenient    *bool
+}
+
+func addChatFlags(fs *flag.FlagSet, visibility string) *chatFlags {
+	return &chatFlags{
+		group:      fs.Bool("group", false, "the chat is a group chat"),
+		visibility: fs.String("visibility", visibility, "hidden and system messages: prompt, narration or all"),
+		regex:      fs.String("regex", "", "apply SillyTavern regex scripts: display or prompt"),
+		lenient:    fs.Bool("lenient", false, "skip lines that cannot be parsed"),
+	}
+}
+
+// chatRef returns the chat named by the positional arguments, without its user
+func chatRef(group bool, positional []string) (models.ChatRef, error) {
+	if group {
+		if len(positional) != 1 {
+			return models.ChatRef{}, usagef("expected a group chat")
+		}
+		return models.ChatRef{Chat: positional[0], Group: true}, nil
+	}
+
+	if len(positional) != 2 {
+		return models.ChatRef{}, usagef("expected a character and a chat, or --group and a group chat")
+	}
+	return models.ChatRef{Character: positional[0], Chat: positional[1]}, nil
+}
+
+// read returns the messages selected by the query with the visibility policy and regex scripts applied
+func (f *chatFlags) read(a *app, ref models.ChatRef, query models.ChatQuery) ([]models.ChatMessage, error) {
+	visibility, err := services.ParseVisibility(*f.visibility, services.VisibilityAll)
+	if err != nil {
+		return nil, err
+	}
+	mode, err := services.ParseRegexMode(*f.regex)
+	if err != nil {
+		return nil, err
+	}
+
+	query.Lenient = *f.lenient
+	var page *models.ChatPage
+	var characters []string
+	if ref.Group {
+		page, err = a.stService.GetGroupChatPage(ref.User, ref.Chat, query)
+		characters = services.GroupMembers(a.stService, ref.User, ref.Chat)
+	} else {
+		page, err = a.stService.GetCharacterChatPage(ref.User, ref.Character, ref.Chat, query)
+		characters = []string{ref.Character}
+	}
+	if err != nil {
+		return nil, err
+	}
+	for _, skipped := range page.Skipped {
+		fmt.Fprintf(os.Stderr, "skipped line %d: %s\n", skipped.Line, skipped.Error)
+	}
+
+	messages := page.Messages
+	if mode != "" {
+		scripts, err := a.stService.GetRegexScripts(ref.User, characters)
+		if err != nil {
+			return nil, err
+		}
+		messages = services.ApplyRegexScripts(messages, scripts, mode, page.Start, page.Total)
+	}
+
+	return services.ApplyVisibility(messages, visibility), nil
+}
+
+func runShow(args []string) error {
+	fs, common := newFlagSet("show")
+	chat := addChatFlags(fs, services.VisibilityAll)
+	offset := fs.Int("offset", 0, "index of the first message")
+	limit := fs.Int("limit", 0, "number of messages, 0 for all")
+	tail := fs.Int("tail", 0, "show the last n messages")
+	positional, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+
+	ref, err := chatRef(*chat.group, positional)
+	if err != nil {
+		return err
+	}
+
+	a, err := common.load()
+	if err != nil {
+		return err
+	}
+	ref.User = a.user
+
+	messages, err := chat.read(a, ref, models.ChatQuery{Offset: *offset, Limit: *limit, Tail: *tail})
+	if err != nil {
+		return err
+	}
+	if a.json {
+		return printJSON(messages)
+	}
+
+	for _, message := range messages {
+		fmt.Printf("[%d] %s\n\n", message.Index, formatMessage(message))
+	}
+	return nil
+}
+
+func runExport(args []string) error {
+	fs, common := newFlagSet("export")
+	chat := addChatFlags(fs, services.VisibilityAll)
+	format := fs.String("format", "markdown", "output format: text, markdown or json")
+	output := fs.String("output", "", "file to write, standard output when empty")
+	summary := fs.Bool("summary", false, "start with the cached summary of the chat, when there is one")
+	positional, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if *format != "text" && *format != "markdown" && *format != "json" {
+		return usagef("invalid format: %s", *format)
+	}
+
+	ref, err := chatRef(*chat.group, positional)
+	if err != nil {
+		return err
+	}
+
+	a, err := common.load()
+	if err != nil {
+		return err
+	}
+	ref.User = a.user
+
+	messages, err := chat.read(a, ref, models.ChatQuery{})
+	if err != nil {
+		return err
+	}
+
+	var cached *models.CachedSummary
+	if *summary {
+		summarizer, err := a.summarizer()
+		if err != nil {
+			return err
+		}
+		if cached, err = summarizer.Cached(ref); err != nil {
+			return err
+		}
+	}
+
+	var w io.Writer = os.Stdout
+	if *output != "" {
+		file, err := os.Create(*output)
+		if err != nil {
+			return fmt.Errorf("failed to create %s: %w", *output, err)
+		}
+		defer file.Close()
+		w = file
+	}
+
+	return exportChat(w, *format, ref, messages, cached)
+}
+
+func exportChat(w io.Writer, format string, ref models.ChatRef, messages []models.ChatMessage, cached *models.CachedSummary) error {
+	finalSummary := ""
+	if cached != nil && len(cached.Summaries) > 0 {
+		finalSummary = cached.Summaries[len(cached.Summaries)-1]
+	}
+
+	switch format {
+	case "json":
+		export := struct {
+			models.ChatRef
+			Summary  string               `json:"summary,omitempty"`
+			Messages []models.ChatMessage `json:"messages"`
+		}{ref, finalSummary, messages}
+		if export.Messages == nil {
+			export.Messages = []models.ChatMessage{}
+		}
+		return jsonEncoder(w).Encode(export)
+
+	case "markdown":
+		var sb strings.Builder
+		fmt.Fprintf(&sb, "# %s\n\n", ref.Chat)
+		if ref.Character != "" {
+			fmt.Fprintf(&sb, "Character: %s\n\n", ref.Character)
+		}
+		if finalSummary != "" {
+			fmt.Fprintf(&sb, "## Summary\n\n%s\n\n## Chat\n\n", finalSummary)
+		}
+		for _, message := range messages {
+			fmt.Fprintf(&sb, "**%s**%s: %s\n\n", message.Name, userSuffix(message), services.ActiveSwipe(message))
+		}
+		_, err := io.WriteString(w, sb.String())
+		return err
+
+	default:
+		var sb strings.Builder
+		if finalSummary != "" {
+			fmt.Fprintf(&sb, "Summary:\n%s\n\n", finalSummary)
+		}
+		for _, message := range messages {
+			fmt.Fprintf(&sb, "%s\n\n", formatMessage(message))
+		}
+		_, err := io.WriteString(w, sb.String())
+		return err
+	}
+}
+
+// formatMessage renders a message as "Name: text" for the terminal
+func formatMessage(message models.ChatMessage) string {
+	return fmt.Sprintf("%s%s: %s", message.Name, userSuffix(message), services.ActiveSwipe(message))
+}
+
+func userSuffix(message models.ChatMessage) string {
+	if message.IsUser {
+		return " (User)"
+	}
+	return ""
+}
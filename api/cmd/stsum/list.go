@@ -0,0 +1,154 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+)
+
+func runUsers(args []string) error {
+	fs, common := newFlagSet("users")
+	positional, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) > 0 {
+		return usagef("unexpected argument %q", positional[0])
+	}
+
+	a, err := common.load()
+	if err != nil {
+		return err
+	}
+
+	users, err := a.stService.GetUsers()
+	if err != nil {
+		return err
+	}
+
+	return a.printList(users)
+}
+
+func runCharacters(args []string) error {
+	fs, common := newFlagSet("characters")
+	positional, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) > 0 {
+		return usagef("unexpected argument %q", positional[0])
+	}
+
+	a, err := common.load()
+	if err != nil {
+		return err
+	}
+
+	characters, err := a.stService.GetCharacters(a.user)
+	if err != nil {
+		return err
+	}
+	if a.json {
+		return printJSON(characters)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tCHATS\tTAGS")
+	for _, character := range characters {
+		fmt.Fprintf(w, "%s\t%d\t%s\n", character.Name, character.ChatCount, strings.Join(character.Tags, ", "))
+	}
+	return w.Flush()
+}
+
+func runChats(args []string) error {
+	fs, common := newFlagSet("chats")
+	groups := fs.Bool("groups", false, "list the group chats instead")
+	positional, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if *groups && len(positional) > 0 || !*groups && len(positional) != 1 {
+		return usagef("expected a character, or --groups")
+	}
+
+	a, err := common.load()
+	if err != nil {
+		return err
+	}
+
+	if !*groups {
+		chats, err := a.stService.GetCharacterChats(a.user, positional[0])
+		if err != nil {
+			return err
+		}
+		return a.printList(chats)
+	}
+
+	groupChats, err := a.stService.GetGroupChats(a.user)
+	if err != nil {
+		return err
+	}
+	if a.json {
+		return printJSON(groupChats)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "CHAT\tGROUP\tMEMBERS")
+	for _, group := range groupChats {
+		for _, chat := range group.Chats {
+			fmt.Fprintf(w, "%s\t%s\t%s\n", chat, group.Name, strings.Join(group.Members, ", "))
+		}
+	}
+	return w.Flush()
+}
+
+func runBackups(args []string) error {
+	fs, common := newFlagSet("backups")
+	positional, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) != 1 {
+		return usagef("expected a character")
+	}
+
+	a, err := common.load()
+	if err != nil {
+		return err
+	}
+
+	backups, err := a.stService.GetCharacterBackups(a.user, positional[0])
+	if err != nil {
+		return err
+	}
+
+	return a.printList(backups)
+}
+
+func runRestore(args []string) error {
+	fs, common := newFlagSet("restore")
+	positional, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) != 2 {
+		return usagef("expected a character and a backup")
+	}
+
+	a, err := common.load()
+	if err != nil {
+		return err
+	}
+
+	chat, err := a.stService.RestoreCharacterBackup(a.user, positional[0], positional[1])
+	if err != nil {
+		return err
+	}
+	if a.json {
+		return printJSON(map[string]string{"chat": chat})
+	}
+
+	fmt.Printf("Restored %s as %s\n", positional[1], chat)
+	return nil
+}
@@ -0,0 +1,91 @@
+// Command stsum summarizes and exports SillyTavern chats from the command line, and serves the API with "stsum serve".
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// command is a subcommand, run with the arguments that follow its name
+type command struct {
+	name    string
+	usage   string
+	summary string
+	run     func(args []string) error
+}
+
+var commands []command
+
+func init() {
+	commands = []command{
+		{"users", "users", "list SillyTavern users", runUsers},
+		{"characters", "characters [--user <user>]", "list characters and how many chats they have", runCharacters},
+		{"chats", "chats <character> | chats --groups", "list the chats of a character, or the group chats", runChats},
+		{"show", "show <character> <chat> | show --group <chat>", "print the messages of a chat", runShow},
+		{"summarize", "summarize <character> <chat> | summarize --group <chat>", "summarize a chat with the model", runSummarize},
+		{"backups", "backups <character>", "list the chat backups of a character", runBackups},
+		{"restore", "restore <character> <backup>", "restore a backup as a new chat of the character", runRestore},
+		{"export", "export <character> <chat> | export --group <chat>", "write a chat as text, Markdown or JSON", runExport},
+		{"serve", "serve", "serve the HTTP API", runServe},
+		{"hash-password", "hash-password [--cost <cost>] < password", "print the bcrypt hash of a password for auth.users", runHashPassword},
+	}
+}
+
+func main() {
+	if len(os.Args) < 2 || os.Args[1] == "-h" || os.Args[1] == "--help" || os.Args[1] == "help" {
+		usage()
+		return
+	}
+
+	for _, cmd := range commands {
+		if cmd.name != os.Args[1] {
+			continue
+		}
+
+		err := cmd.run(os.Args[2:])
+		if errors.Is(err, flag.ErrHelp) {
+			return
+		}
+		var usageErr usageError
+		if errors.As(err, &usageErr) {
+			fmt.Fprintf(os.Stderr, "stsum %s: %v\nusage: stsum %s\n", cmd.name, err, cmd.usage)
+			os.Exit(2)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "stsum %s: %v\n", cmd.name, err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	fmt.Fprintf(os.Stderr, "stsum: unknown command %q\n\n", os.Args[1])
+	usage()
+	os.Exit(2)
+}
+
+func usage() {
+	var sb strings.Builder
+	sb.WriteString("Usage: stsum <command> [flags] [arguments]\n\nCommands:\n")
+	for _, cmd := range commands {
+		fmt.Fprintf(&sb, "  %-14s %s\n", cmd.name, cmd.summary)
+	}
+	sb.WriteString("\nCommands reading SillyTavern data accept --json, --user and the configuration flags (--config, --data-path, --model, ...).\n")
+	sb.WriteString("Run \"stsum <command> -h\" for the flags of a command.\n")
+	fmt.Fprint(os.Stderr, sb.String())
+}
+
+// usageError is returned for missing or extra arguments, main prints the command's usage along with it
+type usageError struct {
+	message string
+}
+
+func (e usageError) Error() string {
+	return e.message
+}
+
+func usagef(format string, args ...any) error {
+	return usageError{message: fmt.Sprintf(format, args...)}
+}
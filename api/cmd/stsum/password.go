@@ -0,0 +1,42 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// runHashPassword prints the bcrypt hash of a password read from standard input, for auth.users in the config file
+func runHashPassword(args []string) error {
+	fs := flag.NewFlagSet("stsum hash-password", flag.ContinueOnError)
+	cost := fs.Int("cost", bcrypt.DefaultCost, "bcrypt cost")
+	positional, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) > 0 {
+		return usagef("unexpected argument %q, the password is read from standard input", positional[0])
+	}
+
+	fmt.Fprint(os.Stderr, "Password: ")
+	password, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && password == "" {
+		return fmt.Errorf("failed to read password: %w", err)
+	}
+	password = strings.TrimRight(password, "\r\n")
+	if password == "" {
+		return fmt.Errorf("password is empty")
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), *cost)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println(string(hash))
+	return nil
+}
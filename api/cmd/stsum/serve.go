@@ -0,0 +1,23 @@
+package main
+
+import (
+	"craigstjean.com/stsummarizer/internal/server"
+)
+
+func runServe(args []string) error {
+	fs, common := newFlagSet("serve")
+	positional, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) > 0 {
+		return usagef("unexpected argument %q", positional[0])
+	}
+
+	a, err := common.load()
+	if err != nil {
+		return err
+	}
+
+	return server.Run(a.cfg)
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"craigstjean.com/stsummarizer/internal/services"
+)
+
+func runSummarize(args []string) error {
+	fs, common := newFlagSet("summarize")
+	group := fs.Bool("group", false, "the chat is a group chat")
+	preset := fs.String("preset", "", "summary preset from the config file")
+	maxTokens := fs.Int("max-tokens", 0, "tokens per chunk (default summary.max_tokens)")
+	words := fs.Int("words", 0, "length of the final summary in words (default summary.summary_words)")
+	includeCards := fs.Bool("include-cards", false, "give the model the character cards and persona as background")
+	contextTokens := fs.Int("context-tokens", 0, "tokens of background (default summary.context_tokens)")
+	from := fs.Int("from", -1, "index of the first message to summarize")
+	to := fs.Int("to", -1, "index of the last message to summarize")
+	fs.String("since", "", "summarize messages sent from this date (2006-01-02 or RFC 3339)")
+	fs.String("until", "", "summarize messages sent until this date")
+	prior := fs.String("prior", "", "summary of the story so far, or @file to read it from a file")
+	visibility := fs.String("visibility", services.VisibilityPrompt, "hidden and system messages: prompt, narration or all")
+	regex := fs.String("regex", "", "apply SillyTavern regex scripts: display or prompt")
+	scenes := fs.Bool("scenes", false, "end chunks at scene breaks when possible")
+	sceneGap := fs.Duration("scene-gap", services.DefaultSceneGap, "pause between messages that starts a new scene, with --scenes")
+	lenient := fs.Bool("lenient", false, "skip lines that cannot be parsed")
+	cached := fs.Bool("cached", false, "print the cached summary when it is still current")
+	all := fs.Bool("all", false, "print the partial summaries before the final one")
+	positional, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+
+	ref, err := chatRef(*group, positional)
+	if err != nil {
+		return err
+	}
+
+	a, err := common.load()
+	if err != nil {
+		return err
+	}
+	ref.User = a.user
+
+	// Defaults come from the config file, then the preset, then the flags given
+	defaults := a.cfg.Summary
+	req := services.SummaryRequest{
+		ChatRef:       ref,
+		MaxTokens:     defaults.MaxTokens,
+		WordLimit:     defaults.SummaryWords,
+		ContextTokens: defaults.ContextTokens,
+		Visibility:    *visibility,
+		Scenes:        *scenes,
+		Lenient:       *lenient,
+		UseCache:      *cached,
+	}
+	if *preset != "" {
+		p, ok := a.cfg.Presets[*preset]
+		if !ok {
+			return fmt.Errorf("preset does not exist: %s", *preset)
+		}
+		req.Model = p.Model
+		req.IncludeCards = p.IncludeCards
+		req.Scenes = p.Scenes
+		if p.MaxTokens > 0 {
+			req.MaxTokens = p.MaxTokens
+		}
+		if p.SummaryWords > 0 {
+			req.WordLimit = p.SummaryWords
+		}
+		if p.ContextTokens > 0 {
+			req.ContextTokens = p.ContextTokens
+		}
+		if p.Visibility != "" {
+			req.Visibility = p.Visibility
+		}
+	}
+
+	var parseErr error
+	fs.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "model":
+			// --model sets the default model, it wins over the preset's
+			req.Model = ""
+		case "max-tokens":
+			req.MaxTokens = *maxTokens
+		case "words":
+			req.WordLimit = *words
+		case "include-cards":
+			req.IncludeCards = *includeCards
+		case "context-tokens":
+			req.ContextTokens = *contextTokens
+		case "visibility":
+			req.Visibility = *visibility
+		case "scenes":
+			req.Scenes = *scenes
+		case "from":
+			req.From = from
+		case "to":
+			req.To = to
+		case "since", "until":
+			t, err := services.ParseRangeDate(f.Value.String(), f.Name == "until")
+			if err != nil {
+				parseErr = err
+				return
+			}
+			if f.Name == "since" {
+				req.Since = &t
+			} else {
+				req.Until = &t
+			}
+		}
+	})
+	if parseErr != nil {
+		return parseErr
+	}
+	if req.From != nil && *req.From < 0 || req.To != nil && *req.To < 0 {
+		return usagef("--from and --to must not be negative")
+	}
+	if !req.IncludeCards {
+		req.ContextTokens = 0
+	}
+	if req.Scenes {
+		req.SceneGap = *sceneGap
+	}
+
+	if req.Visibility, err = services.ParseVisibility(req.Visibility, services.VisibilityPrompt); err != nil {
+		return err
+	}
+	if req.Regex, err = services.ParseRegexMode(*regex); err != nil {
+		return err
+	}
+	if req.PriorSummary, err = readPrior(*prior); err != nil {
+		return err
+	}
+
+	summarizer, err := a.summarizer()
+	if err != nil {
+		return err
+	}
+
+	start := time.Now()
+	summary, fromCache, err := summarizer.Summarize(context.Background(), req)
+	if err != nil {
+		return err
+	}
+	for _, skipped := range summary.Skipped {
+		fmt.Fprintf(os.Stderr, "skipped line %d: %s\n", skipped.Line, skipped.Error)
+	}
+
+	if a.json {
+		return printJSON(summary)
+	}
+
+	if *all && len(summary.Summaries) > 1 {
+		for i, partial := range summary.Summaries[:len(summary.Summaries)-1] {
+			fmt.Printf("## Part %d\n\n%s\n\n", i+1, partial)
+		}
+		fmt.Print("## Summary\n\n")
+	}
+	fmt.Println(summary.Summaries[len(summary.Summaries)-1])
+
+	source := "generated in " + time.Since(start).Round(time.Second).String()
+	if fromCache {
+		source = "from the cache"
+	}
+	fmt.Fprintf(os.Stderr, "%d messages, %d tokens, %s\n", summary.MessageCount, summary.TokenCount, source)
+	return nil
+}
+
+// readPrior returns the prior summary given with --prior, reading it from a file when it starts with @
+func readPrior(value string) (string, error) {
+	if !strings.HasPrefix(value, "@") {
+		return value, nil
+	}
+
+	content, err := os.ReadFile(value[1:])
+	if err != nil {
+		return "", fmt.Errorf("failed to read prior summary: %w", err)
+	}
+
+	return string(content), nil
+}
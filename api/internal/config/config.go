@@ -3,40 +3,68 @@ package config
 import (
 	"fmt"
 	"os"
+	"strings"
+	"sync"
 )
 
 const (
 	STChatsPath      = "chats"
+	STCharactersPath = "characters"
 	STGroupsPath     = "groups"
 	STGroupChatsPath = "group chats"
 	STBackupsPath    = "backups"
+	STWorldsPath     = "worlds"
+	STSettingsFile   = "settings.json"
 	STDefaultUser    = "default-user"
-	DefaultModel     = "artifish/llama3.2-uncensored:latest"
 )
 
-func GetOllamaPort() string {
-	port := os.Getenv("OLLAMA_PORT")
-	if port == "" {
-		port = "11434"
-	}
+var (
+	current     *Config
+	currentOnce sync.Once
+	currentMu   sync.RWMutex
+)
+
+// Get returns the configuration loaded by Load. When Load was never called, as in tools that only read chats, it is
+// built from the defaults and environment variables.
+func Get() *Config {
+	currentOnce.Do(func() {
+		currentMu.Lock()
+		defer currentMu.Unlock()
+		if current == nil {
+			cfg := Default()
+			if err := cfg.applyEnv(os.Getenv); err != nil {
+				fmt.Fprintln(os.Stderr, "config:", err)
+			}
+			current = cfg
+		}
+	})
 
-	return port
+	currentMu.RLock()
+	defer currentMu.RUnlock()
+	return current
 }
 
-func GetOllamaBaseURL() string {
-	url := os.Getenv("OLLAMA_HOST")
-	if url == "" {
-		url = "localhost"
-	}
+func set(cfg *Config) {
+	currentMu.Lock()
+	defer currentMu.Unlock()
+	current = cfg
+}
 
-	return fmt.Sprintf("http://%s:%s", url, GetOllamaPort())
+// GetDefaultModel returns the model used when a request does not name one
+func GetDefaultModel() string {
+	return Get().Ollama.Model
+}
+
+func GetOllamaBaseURL() string {
+	return strings.TrimSuffix(Get().Ollama.URL, "/")
 }
 
 func GetSTDataPath() string {
-	path := os.Getenv("ST_DATA_PATH")
-	if path == "" {
-		panic("ST_DATA_PATH is not set")
-	}
+	return Get().DataPath
+}
 
-	return path
+// GetStatePath returns the directory where this service keeps its own data (summary cache, rules).
+// It is separate from the SillyTavern data path, which may be mounted read-only.
+func GetStatePath() string {
+	return Get().StatePath
 }
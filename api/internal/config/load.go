@@ -0,0 +1,642 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+	"gopkg.in/yaml.v3"
+)
+
+// DefaultConfigFile is read from the working directory when no config file is given
+const DefaultConfigFile = "config.yaml"
+
+// redacted replaces secrets in the effective configuration
+const redacted = "********"
+
+// Config is the effective configuration: the defaults, overridden by the config file, then environment variables,
+// then command line flags
+type Config struct {
+	// Listen is the address the API listens on
+	Listen string `yaml:"listen" json:"listen"`
+	// DataPath is SillyTavern's data directory, holding one directory per user
+	DataPath string `yaml:"data_path" json:"data_path"`
+	// StatePath holds this service's own data: the summary cache, rules and cleaning options
+	StatePath string `yaml:"state_path" json:"state_path"`
+
+	Ollama   OllamaConfig  `yaml:"ollama" json:"ollama"`
+	Summary  SummaryConfig `yaml:"summary" json:"summary"`
+	Timeouts TimeoutConfig `yaml:"timeouts" json:"timeouts"`
+	Features FeatureConfig `yaml:"features" json:"features"`
+	Auth     AuthConfig    `yaml:"auth" json:"auth"`
+	HTTP     HTTPConfig    `yaml:"http" json:"http"`
+	Log      LogConfig     `yaml:"log" json:"log"`
+	Tracing  TracingConfig `yaml:"tracing" json:"tracing"`
+	// Presets are named sets of summary options, such as the command line's --preset
+	Presets map[string]SummaryPreset `yaml:"presets" json:"presets,omitempty"`
+	File    string                   `yaml:"-" json:"file,omitempty"`
+}
+
+type OllamaConfig struct {
+	URL   string `yaml:"url" json:"url"`
+	Model string `yaml:"model" json:"model"`
+	// APIKey is sent as a bearer token, for Ollama servers behind an authenticating proxy
+	APIKey string `yaml:"api_key" json:"api_key,omitempty"`
+}
+
+// SummaryConfig holds the defaults of the summary options requests leave out
+type SummaryConfig struct {
+	MaxTokens     int `yaml:"max_tokens" json:"max_tokens"`
+	SummaryWords  int `yaml:"summary_words" json:"summary_words"`
+	ContextTokens int `yaml:"context_tokens" json:"context_tokens"`
+	// Concurrency is how many summaries batch jobs, and a story, run at once against Ollama
+	Concurrency int `yaml:"concurrency" json:"concurrency"`
+}
+
+// SummaryPreset overrides the summary defaults, zero values keep them
+type SummaryPreset struct {
+	Model         string `yaml:"model" json:"model,omitempty"`
+	MaxTokens     int    `yaml:"max_tokens" json:"max_tokens,omitempty"`
+	SummaryWords  int    `yaml:"summary_words" json:"summary_words,omitempty"`
+	ContextTokens int    `yaml:"context_tokens" json:"context_tokens,omitempty"`
+	IncludeCards  bool   `yaml:"include_cards" json:"include_cards,omitempty"`
+	Visibility    string `yaml:"visibility" json:"visibility,omitempty"`
+	Scenes        bool   `yaml:"scenes" json:"scenes,omitempty"`
+}
+
+type TimeoutConfig struct {
+	// Read and Write limit HTTP requests, 0 disables them. Summaries can take minutes, so Write is off by default.
+	Read  Duration `yaml:"read" json:"read"`
+	Write Duration `yaml:"write" json:"write"`
+	// Ollama limits each call to the model
+	Ollama Duration `yaml:"ollama" json:"ollama"`
+}
+
+type FeatureConfig struct {
+	// Watch streams chat changes to /api/events
+	Watch bool `yaml:"watch" json:"watch"`
+	// Rules runs the automatic summarization rules
+	Rules bool `yaml:"rules" json:"rules"`
+	// Cleaning cleans messages and model output before they are used
+	Cleaning bool `yaml:"cleaning" json:"cleaning"`
+}
+
+type LogConfig struct {
+	// Level is debug, info, warn or error. Chat content, prompts and responses are only logged at debug.
+	Level string `yaml:"level" json:"level"`
+	// Format is json or text
+	Format string `yaml:"format" json:"format"`
+}
+
+// TracingConfig exports OpenTelemetry spans of requests, chat loading, chunking and model calls
+type TracingConfig struct {
+	// Exporter is none, otlp or file
+	Exporter string `yaml:"exporter" json:"exporter"`
+	// Endpoint is the OTLP/HTTP collector URL, OTEL_EXPORTER_OTLP_ENDPOINT or http://localhost:4318 when empty
+	Endpoint string `yaml:"endpoint" json:"endpoint,omitempty"`
+	// File receives one JSON span per line with the file exporter, traces.jsonl in the state path when empty
+	File string `yaml:"file" json:"file,omitempty"`
+}
+
+// HTTPConfig holds the limits and headers applied to every request
+type HTTPConfig struct {
+	CORS      CORSConfig      `yaml:"cors" json:"cors"`
+	RateLimit RateLimitConfig `yaml:"rate_limit" json:"rate_limit"`
+	// MaxBodyBytes limits request bodies, such as lorebook entries and prior summaries
+	MaxBodyBytes int `yaml:"max_body_bytes" json:"max_body_bytes"`
+	// TrustedProxies are the addresses or CIDR ranges of reverse proxies whose X-Forwarded-For is believed, none by
+	// default so clients cannot pick their own address for the rate limit
+	TrustedProxies []string `yaml:"trusted_proxies" json:"trusted_proxies,omitempty"`
+}
+
+// CORSConfig lists what browsers on other origins may do, "*" in AllowedOrigins allows every origin
+type CORSConfig struct {
+	AllowedOrigins []string `yaml:"allowed_origins" json:"allowed_origins"`
+	AllowedMethods []string `yaml:"allowed_methods" json:"allowed_methods"`
+	AllowedHeaders []string `yaml:"allowed_headers" json:"allowed_headers"`
+}
+
+// RateLimitConfig limits how often each client, its credentials or else its address, may call the endpoints that run
+// the model. RequestsPerMinute 0 turns the limit off.
+type RateLimitConfig struct {
+	RequestsPerMinute int `yaml:"requests_per_minute" json:"requests_per_minute"`
+	// Burst is how many requests a client may send at once before the rate applies
+	Burst int `yaml:"burst" json:"burst"`
+}
+
+// AuthConfig turns authentication on when it has tokens or users, or accepts SillyTavern's accounts. Each credential
+// may only access the SillyTavern users it lists, "*" grants every user.
+type AuthConfig struct {
+	// Tokens are sent as "Authorization: Bearer <token>"
+	Tokens []AuthToken `yaml:"tokens" json:"tokens,omitempty"`
+	// Users log in with basic auth
+	Users []AuthUser `yaml:"users" json:"users,omitempty"`
+	// SillyTavern accepts the handles and passwords of SillyTavern's user accounts with basic auth. An account may
+	// access its own data, an admin account every user's.
+	SillyTavern bool `yaml:"sillytavern" json:"sillytavern"`
+}
+
+type AuthToken struct {
+	Name  string   `yaml:"name" json:"name"`
+	Token string   `yaml:"token" json:"token"`
+	Users []string `yaml:"users" json:"users"`
+}
+
+type AuthUser struct {
+	Name string `yaml:"name" json:"name"`
+	// PasswordHash is a bcrypt hash, as printed by "stsum hash-password"
+	PasswordHash string   `yaml:"password_hash" json:"password_hash"`
+	Users        []string `yaml:"users" json:"users"`
+}
+
+// Enabled reports whether requests must authenticate
+func (a AuthConfig) Enabled() bool {
+	return len(a.Tokens) > 0 || len(a.Users) > 0 || a.SillyTavern
+}
+
+// Duration is a time.Duration written as "90s" or "10m" in the config file and JSON
+type Duration time.Duration
+
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(time.Duration(d).String()), nil
+}
+
+func (d *Duration) UnmarshalText(text []byte) error {
+	parsed, err := time.ParseDuration(string(text))
+	if err != nil {
+		return err
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+func Default() *Config {
+	return &Config{
+		Listen:    ":8080",
+		StatePath: "state",
+		Ollama: OllamaConfig{
+			URL:   "http://localhost:11434",
+			Model: "artifish/llama3.2-uncensored:latest",
+		},
+		Summary: SummaryConfig{
+			MaxTokens:     3500,
+			SummaryWords:  400,
+			ContextTokens: 600,
+			Concurrency:   1,
+		},
+		Timeouts: TimeoutConfig{
+			Read:   Duration(30 * time.Second),
+			Ollama: Duration(10 * time.Minute),
+		},
+		Features: FeatureConfig{
+			Watch:    true,
+			Rules:    true,
+			Cleaning: true,
+		},
+		Log: LogConfig{
+			Level:  "info",
+			Format: "json",
+		},
+		Tracing: TracingConfig{
+			Exporter: "none",
+		},
+		HTTP: HTTPConfig{
+			CORS: CORSConfig{
+				AllowedOrigins: []string{"*"},
+				AllowedMethods: []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
+				AllowedHeaders: []string{"Content-Type", "Authorization"},
+			},
+			RateLimit: RateLimitConfig{
+				RequestsPerMinute: 10,
+				Burst:             5,
+			},
+			MaxBodyBytes: 4 << 20,
+		},
+	}
+}
+
+// Flags holds the configuration given on the command line
+type Flags struct {
+	file      *string
+	listen    *string
+	dataPath  *string
+	statePath *string
+	ollamaURL *string
+	model     *string
+}
+
+// AddFlags registers the configuration flags on a flag set, so commands with flags of their own accept them too
+func AddFlags(fs *flag.FlagSet) *Flags {
+	return &Flags{
+		file:      fs.String("config", "", "config file (default "+DefaultConfigFile+" when it exists, or $STSUMMARIZER_CONFIG)"),
+		listen:    fs.String("listen", "", "address to listen on, such as :8080"),
+		dataPath:  fs.String("data-path", "", "SillyTavern data directory"),
+		statePath: fs.String("state-path", "", "directory for the summary cache and rules"),
+		ollamaURL: fs.String("ollama-url", "", "Ollama base URL"),
+		model:     fs.String("model", "", "default model"),
+	}
+}
+
+// Load builds the configuration from the config file, the environment and the flags in args, validates it and makes
+// it the one returned by Get
+func Load(args []string) (*Config, error) {
+	fs := flag.NewFlagSet("stsummarizer", flag.ContinueOnError)
+	flags := AddFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+
+	return flags.Load()
+}
+
+// Load builds the configuration once the flag set holding the flags was parsed, see the Load function
+func (f *Flags) Load() (*Config, error) {
+	cfg, err := f.parse(os.Getenv)
+	if err != nil {
+		return nil, err
+	}
+
+	set(cfg)
+	return cfg, nil
+}
+
+func (f *Flags) parse(getenv func(string) string) (*Config, error) {
+	cfg := Default()
+
+	// 1. Config file
+	path := *f.file
+	if path == "" {
+		path = getenv("STSUMMARIZER_CONFIG")
+	}
+	required := path != ""
+	if path == "" {
+		path = DefaultConfigFile
+	}
+	if err := cfg.applyFile(path, required); err != nil {
+		return nil, err
+	}
+
+	// 2. Environment
+	if err := cfg.applyEnv(getenv); err != nil {
+		return nil, err
+	}
+
+	// 3. Flags
+	for _, flagValue := range []struct {
+		value  string
+		target *string
+	}{
+		{*f.listen, &cfg.Listen},
+		{*f.dataPath, &cfg.DataPath},
+		{*f.statePath, &cfg.StatePath},
+		{*f.ollamaURL, &cfg.Ollama.URL},
+		{*f.model, &cfg.Ollama.Model},
+	} {
+		if flagValue.value != "" {
+			*flagValue.target = flagValue.value
+		}
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+
+	return cfg, nil
+}
+
+func (c *Config) applyFile(path string, required bool) error {
+	content, err := os.ReadFile(path)
+	if os.IsNotExist(err) && !required {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	decoder := yaml.NewDecoder(bytes.NewReader(content))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(c); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("invalid config file %s: %w", path, err)
+	}
+	c.File = path
+
+	return nil
+}
+
+// applyEnv reads the STSUMMARIZER_* variables, and the variables used before the config file existed
+func (c *Config) applyEnv(getenv func(string) string) error {
+	// OLLAMA_HOST and OLLAMA_PORT predate OLLAMA_URL
+	if host, port := getenv("OLLAMA_HOST"), getenv("OLLAMA_PORT"); host != "" || port != "" {
+		if host == "" {
+			host = "localhost"
+		}
+		if port == "" {
+			port = "11434"
+		}
+		c.Ollama.URL = fmt.Sprintf("http://%s:%s", host, port)
+	}
+
+	stringVars := []struct {
+		names  []string
+		target *string
+	}{
+		{[]string{"STSUMMARIZER_LISTEN"}, &c.Listen},
+		{[]string{"ST_DATA_PATH", "STSUMMARIZER_DATA_PATH"}, &c.DataPath},
+		{[]string{"STATE_PATH", "STSUMMARIZER_STATE_PATH"}, &c.StatePath},
+		{[]string{"OLLAMA_URL", "STSUMMARIZER_OLLAMA_URL"}, &c.Ollama.URL},
+		{[]string{"OLLAMA_MODEL", "STSUMMARIZER_OLLAMA_MODEL"}, &c.Ollama.Model},
+		{[]string{"OLLAMA_API_KEY", "STSUMMARIZER_OLLAMA_API_KEY"}, &c.Ollama.APIKey},
+		{[]string{"STSUMMARIZER_LOG_LEVEL"}, &c.Log.Level},
+		{[]string{"STSUMMARIZER_LOG_FORMAT"}, &c.Log.Format},
+		{[]string{"STSUMMARIZER_TRACING_EXPORTER"}, &c.Tracing.Exporter},
+		{[]string{"STSUMMARIZER_TRACING_ENDPOINT"}, &c.Tracing.Endpoint},
+		{[]string{"STSUMMARIZER_TRACING_FILE"}, &c.Tracing.File},
+	}
+	for _, env := range stringVars {
+		for _, name := range env.names {
+			if value := getenv(name); value != "" {
+				*env.target = value
+			}
+		}
+	}
+
+	var errs []error
+
+	// A token from the environment may access every user, for single user setups such as a container
+	if token := getenv("STSUMMARIZER_AUTH_TOKEN"); token != "" {
+		c.Auth.Tokens = append(c.Auth.Tokens, AuthToken{Name: "STSUMMARIZER_AUTH_TOKEN", Token: token, Users: []string{"*"}})
+	}
+
+	ints := []struct {
+		name   string
+		target *int
+	}{
+		{"STSUMMARIZER_SUMMARY_MAX_TOKENS", &c.Summary.MaxTokens},
+		{"STSUMMARIZER_SUMMARY_WORDS", &c.Summary.SummaryWords},
+		{"STSUMMARIZER_SUMMARY_CONTEXT_TOKENS", &c.Summary.ContextTokens},
+		{"STSUMMARIZER_SUMMARY_CONCURRENCY", &c.Summary.Concurrency},
+		{"STSUMMARIZER_HTTP_RATE_LIMIT_REQUESTS_PER_MINUTE", &c.HTTP.RateLimit.RequestsPerMinute},
+		{"STSUMMARIZER_HTTP_RATE_LIMIT_BURST", &c.HTTP.RateLimit.Burst},
+		{"STSUMMARIZER_HTTP_MAX_BODY_BYTES", &c.HTTP.MaxBodyBytes},
+	}
+	for _, env := range ints {
+		if value := getenv(env.name); value != "" {
+			n, err := strconv.Atoi(value)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("invalid %s: %s is not a number", env.name, value))
+				continue
+			}
+			*env.target = n
+		}
+	}
+
+	lists := []struct {
+		name   string
+		target *[]string
+	}{
+		{"STSUMMARIZER_HTTP_CORS_ALLOWED_ORIGINS", &c.HTTP.CORS.AllowedOrigins},
+		{"STSUMMARIZER_HTTP_CORS_ALLOWED_METHODS", &c.HTTP.CORS.AllowedMethods},
+		{"STSUMMARIZER_HTTP_CORS_ALLOWED_HEADERS", &c.HTTP.CORS.AllowedHeaders},
+		{"STSUMMARIZER_HTTP_TRUSTED_PROXIES", &c.HTTP.TrustedProxies},
+	}
+	for _, env := range lists {
+		if value := getenv(env.name); value != "" {
+			*env.target = nil
+			for _, item := range strings.Split(value, ",") {
+				if item = strings.TrimSpace(item); item != "" {
+					*env.target = append(*env.target, item)
+				}
+			}
+		}
+	}
+
+	durations := []struct {
+		name   string
+		target *Duration
+	}{
+		{"STSUMMARIZER_TIMEOUTS_READ", &c.Timeouts.Read},
+		{"STSUMMARIZER_TIMEOUTS_WRITE", &c.Timeouts.Write},
+		{"STSUMMARIZER_TIMEOUTS_OLLAMA", &c.Timeouts.Ollama},
+	}
+	for _, env := range durations {
+		if value := getenv(env.name); value != "" {
+			if err := env.target.UnmarshalText([]byte(value)); err != nil {
+				errs = append(errs, fmt.Errorf("invalid %s: %s is not a duration such as 30s or 10m", env.name, value))
+			}
+		}
+	}
+
+	bools := []struct {
+		name   string
+		target *bool
+	}{
+		{"STSUMMARIZER_FEATURES_WATCH", &c.Features.Watch},
+		{"STSUMMARIZER_FEATURES_RULES", &c.Features.Rules},
+		{"STSUMMARIZER_FEATURES_CLEANING", &c.Features.Cleaning},
+		{"STSUMMARIZER_AUTH_SILLYTAVERN", &c.Auth.SillyTavern},
+	}
+	for _, env := range bools {
+		if value := getenv(env.name); value != "" {
+			enabled, err := strconv.ParseBool(value)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("invalid %s: %s is not true or false", env.name, value))
+				continue
+			}
+			*env.target = enabled
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// Validate reports every problem with the configuration at once
+func (c *Config) Validate() error {
+	var errs []error
+
+	if _, _, err := net.SplitHostPort(c.Listen); err != nil {
+		errs = append(errs, fmt.Errorf("invalid listen address %q: expected host:port or :port", c.Listen))
+	}
+
+	if c.DataPath == "" {
+		errs = append(errs, fmt.Errorf("data_path is not set: set it in the config file, ST_DATA_PATH or --data-path"))
+	} else if info, err := os.Stat(c.DataPath); err != nil {
+		errs = append(errs, fmt.Errorf("invalid data_path %q: %v", c.DataPath, err))
+	} else if !info.IsDir() {
+		errs = append(errs, fmt.Errorf("invalid data_path %q: not a directory", c.DataPath))
+	}
+
+	if c.StatePath == "" {
+		errs = append(errs, fmt.Errorf("state_path is not set"))
+	}
+
+	if u, err := url.Parse(c.Ollama.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		errs = append(errs, fmt.Errorf("invalid ollama.url %q: expected an http or https URL such as http://localhost:11434", c.Ollama.URL))
+	}
+	if c.Ollama.Model == "" {
+		errs = append(errs, fmt.Errorf("ollama.model is not set"))
+	}
+
+	if c.Summary.MaxTokens <= 0 {
+		errs = append(errs, fmt.Errorf("invalid summary.max_tokens %d: must be positive", c.Summary.MaxTokens))
+	}
+	if c.Summary.SummaryWords <= 0 {
+		errs = append(errs, fmt.Errorf("invalid summary.summary_words %d: must be positive", c.Summary.SummaryWords))
+	}
+	if c.Summary.Concurrency <= 0 {
+		errs = append(errs, fmt.Errorf("invalid summary.concurrency %d: must be positive", c.Summary.Concurrency))
+	}
+	if c.Summary.ContextTokens < 0 {
+		errs = append(errs, fmt.Errorf("invalid summary.context_tokens %d: must not be negative", c.Summary.ContextTokens))
+	}
+
+	for name, preset := range c.Presets {
+		if preset.MaxTokens < 0 || preset.SummaryWords < 0 || preset.ContextTokens < 0 {
+			errs = append(errs, fmt.Errorf("invalid preset %q: token and word counts must not be negative", name))
+		}
+	}
+
+	for name, timeout := range map[string]Duration{
+		"timeouts.read":   c.Timeouts.Read,
+		"timeouts.write":  c.Timeouts.Write,
+		"timeouts.ollama": c.Timeouts.Ollama,
+	} {
+		if timeout < 0 {
+			errs = append(errs, fmt.Errorf("invalid %s %s: must not be negative", name, time.Duration(timeout)))
+		}
+	}
+
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(c.Log.Level)); err != nil {
+		errs = append(errs, fmt.Errorf("invalid log.level %q: expected debug, info, warn or error", c.Log.Level))
+	}
+	if c.Log.Format != "json" && c.Log.Format != "text" {
+		errs = append(errs, fmt.Errorf("invalid log.format %q: expected json or text", c.Log.Format))
+	}
+
+	switch c.Tracing.Exporter {
+	case "none", "otlp", "file":
+	default:
+		errs = append(errs, fmt.Errorf("invalid tracing.exporter %q: expected none, otlp or file", c.Tracing.Exporter))
+	}
+	if c.Tracing.Endpoint != "" {
+		if u, err := url.Parse(c.Tracing.Endpoint); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			errs = append(errs, fmt.Errorf("invalid tracing.endpoint %q: expected an http or https URL such as http://localhost:4318", c.Tracing.Endpoint))
+		}
+	}
+
+	errs = append(errs, c.HTTP.validate()...)
+	errs = append(errs, c.Auth.validate()...)
+
+	return errors.Join(errs...)
+}
+
+func (h HTTPConfig) validate() []error {
+	var errs []error
+
+	for _, origin := range h.CORS.AllowedOrigins {
+		if origin == "*" {
+			continue
+		}
+		if u, err := url.Parse(origin); err != nil || u.Scheme == "" || u.Host == "" || (u.Path != "" && u.Path != "/") {
+			errs = append(errs, fmt.Errorf("invalid http.cors.allowed_origins %q: expected \"*\" or an origin such as http://localhost:3000", origin))
+		}
+	}
+	if len(h.CORS.AllowedMethods) == 0 {
+		errs = append(errs, fmt.Errorf("http.cors.allowed_methods is empty"))
+	}
+
+	if h.RateLimit.RequestsPerMinute < 0 {
+		errs = append(errs, fmt.Errorf("invalid http.rate_limit.requests_per_minute %d: must not be negative", h.RateLimit.RequestsPerMinute))
+	}
+	if h.RateLimit.RequestsPerMinute > 0 && h.RateLimit.Burst <= 0 {
+		errs = append(errs, fmt.Errorf("invalid http.rate_limit.burst %d: must be positive", h.RateLimit.Burst))
+	}
+
+	for _, proxy := range h.TrustedProxies {
+		if _, _, err := net.ParseCIDR(proxy); err != nil && net.ParseIP(proxy) == nil {
+			errs = append(errs, fmt.Errorf("invalid http.trusted_proxies %q: expected an IP address or CIDR range", proxy))
+		}
+	}
+
+	if h.MaxBodyBytes <= 0 {
+		errs = append(errs, fmt.Errorf("invalid http.max_body_bytes %d: must be positive", h.MaxBodyBytes))
+	}
+
+	return errs
+}
+
+func (a AuthConfig) validate() []error {
+	var errs []error
+
+	tokens := make(map[string]bool, len(a.Tokens))
+	for i, token := range a.Tokens {
+		name := token.Name
+		if name == "" {
+			name = fmt.Sprintf("#%d", i+1)
+		}
+		switch {
+		case len(token.Token) < 16:
+			errs = append(errs, fmt.Errorf("invalid auth token %s: tokens must be at least 16 characters", name))
+		case tokens[token.Token]:
+			errs = append(errs, fmt.Errorf("invalid auth token %s: the same token is listed twice", name))
+		}
+		if len(token.Users) == 0 {
+			errs = append(errs, fmt.Errorf("invalid auth token %s: users is empty, list the SillyTavern users it may access or \"*\"", name))
+		}
+		tokens[token.Token] = true
+	}
+
+	users := make(map[string]bool, len(a.Users))
+	for i, user := range a.Users {
+		name := user.Name
+		switch {
+		case name == "":
+			name = fmt.Sprintf("#%d", i+1)
+			errs = append(errs, fmt.Errorf("invalid auth user %s: name is not set", name))
+		case strings.Contains(name, ":"):
+			errs = append(errs, fmt.Errorf("invalid auth user %s: names cannot contain \":\"", name))
+		case users[name]:
+			errs = append(errs, fmt.Errorf("invalid auth user %s: listed twice", name))
+		}
+		if _, err := bcrypt.Cost([]byte(user.PasswordHash)); err != nil {
+			errs = append(errs, fmt.Errorf("invalid auth user %s: password_hash is not a bcrypt hash", name))
+		}
+		if len(user.Users) == 0 {
+			errs = append(errs, fmt.Errorf("invalid auth user %s: users is empty, list the SillyTavern users it may access or \"*\"", name))
+		}
+		users[name] = true
+	}
+
+	return errs
+}
+
+// Redacted returns a copy of the configuration with its secrets hidden, safe to show
+func (c *Config) Redacted() Config {
+	redactedConfig := *c
+	if redactedConfig.Ollama.APIKey != "" {
+		redactedConfig.Ollama.APIKey = redacted
+	}
+
+	redactedConfig.Auth.Tokens = make([]AuthToken, len(c.Auth.Tokens))
+	for i, token := range c.Auth.Tokens {
+		token.Token = redacted
+		redactedConfig.Auth.Tokens[i] = token
+	}
+	redactedConfig.Auth.Users = make([]AuthUser, len(c.Auth.Users))
+	for i, user := range c.Auth.Users {
+		user.PasswordHash = redacted
+		redactedConfig.Auth.Users[i] = user
+	}
+
+	return redactedConfig
+}
@@ -0,0 +1,169 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"craigstjean.com/stsummarizer/internal/middleware"
+	"craigstjean.com/stsummarizer/internal/models"
+	"craigstjean.com/stsummarizer/internal/services/batch"
+	"github.com/gin-gonic/gin"
+)
+
+// batchLinkParams are the query parameters of a batch that the summary endpoints read
+var batchLinkParams = []string{
+	"user", "model", "max_tokens", "summary_words", "include_cards", "context_tokens", "lenient", "visibility", "regex",
+	"scenes", "scene_gap",
+}
+
+type BatchesHandler struct {
+	runner *batch.Runner
+}
+
+func NewBatchesHandler(runner *batch.Runner) *BatchesHandler {
+	return &BatchesHandler{
+		runner: runner,
+	}
+}
+
+// CreateBatch starts summarizing every chat of a character, every group chat, or everything of the user. It takes
+// the options of the summary endpoints, which the links in the index repeat.
+func (h *BatchesHandler) CreateBatch(c *gin.Context) {
+	summaryReq, err := parseSummaryRequest(c, models.ChatRef{User: c.Query("user")})
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+	if summaryReq.From != nil || summaryReq.To != nil || summaryReq.Since != nil || summaryReq.Until != nil || summaryReq.PriorSummary != "" {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "invalid batch: ranges and prior summaries only apply to a single chat",
+		})
+		return
+	}
+
+	concurrency := 0
+	if value := c.Query("concurrency"); value != "" {
+		if concurrency, err = strconv.Atoi(value); err != nil || concurrency <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": "invalid concurrency: " + value,
+			})
+			return
+		}
+	}
+
+	// Links fetch the cached summary with the same options. Only those are copied, credentials such as access_token
+	// must not end up in links other principals can read.
+	query := url.Values{}
+	for _, param := range batchLinkParams {
+		if values, ok := c.GetQueryArray(param); ok {
+			query[param] = values
+		}
+	}
+	query.Set("cached", "true")
+	encodedQuery := query.Encode()
+
+	job, err := h.runner.Start(batch.Request{
+		User:        c.Query("user"),
+		Character:   c.Query("character"),
+		Groups:      c.Query("groups") == "true",
+		Concurrency: concurrency,
+		Force:       c.Query("force") == "true",
+		Summary:     summaryReq,
+		Link: func(ref models.ChatRef) string {
+			if ref.Group {
+				return "/api/groupChats/" + url.PathEscape(ref.Chat) + "/summary?" + encodedQuery
+			}
+			return "/api/chats/" + url.PathEscape(ref.Character) + "/" + url.PathEscape(ref.Chat) + "/summary?" + encodedQuery
+		},
+	})
+	if err != nil {
+		writeBatchError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusAccepted, job)
+}
+
+// GetBatches lists the batches of the users the request may access
+func (h *BatchesHandler) GetBatches(c *gin.Context) {
+	jobs := []models.BatchJob{}
+	for _, job := range h.runner.List() {
+		if middleware.UserAllowed(c, job.User) {
+			jobs = append(jobs, job)
+		}
+	}
+
+	c.JSON(http.StatusOK, jobs)
+}
+
+func (h *BatchesHandler) GetBatch(c *gin.Context) {
+	job, err := h.job(c)
+	if err != nil {
+		writeBatchError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, job)
+}
+
+// GetBatchIndex returns the index of the batch as Markdown, with the chats summarized so far
+func (h *BatchesHandler) GetBatchIndex(c *gin.Context) {
+	job, err := h.job(c)
+	if err != nil {
+		writeBatchError(c, err)
+		return
+	}
+
+	c.Data(http.StatusOK, "text/markdown; charset=utf-8", []byte(batch.Index(job)))
+}
+
+// CancelBatch stops the batch, the summaries already running finish first
+func (h *BatchesHandler) CancelBatch(c *gin.Context) {
+	if _, err := h.job(c); err != nil {
+		writeBatchError(c, err)
+		return
+	}
+	if err := h.runner.Cancel(c.Param("id")); err != nil {
+		writeBatchError(c, err)
+		return
+	}
+
+	job, err := h.runner.Get(c.Param("id"))
+	if err != nil {
+		writeBatchError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, job)
+}
+
+// job returns the batch of the id parameter, batches of users the request may not access do not exist for it
+func (h *BatchesHandler) job(c *gin.Context) (models.BatchJob, error) {
+	job, err := h.runner.Get(c.Param("id"))
+	if err != nil {
+		return job, err
+	}
+	if !middleware.UserAllowed(c, job.User) {
+		return models.BatchJob{}, fmt.Errorf("batch does not exist: %s", c.Param("id"))
+	}
+
+	return job, nil
+}
+
+func writeBatchError(c *gin.Context, err error) {
+	status := http.StatusInternalServerError
+	if strings.Contains(err.Error(), "does not exist") {
+		status = http.StatusNotFound
+	} else if isInvalidRequest(err) {
+		status = http.StatusBadRequest
+	}
+
+	c.JSON(status, gin.H{
+		"error": err.Error(),
+	})
+}
@@ -1,8 +1,13 @@
 package handlers
 
 import (
+	"bytes"
 	"net/http"
+	"strconv"
+	"strings"
 
+	"craigstjean.com/stsummarizer/internal/middleware"
+	"craigstjean.com/stsummarizer/internal/models"
 	"craigstjean.com/stsummarizer/internal/services"
 	"github.com/gin-gonic/gin"
 )
@@ -31,6 +36,63 @@ func (h *CharactersHandler) GetCharacters(c *gin.Context) {
 	c.JSON(http.StatusOK, characters)
 }
 
+func (h *CharactersHandler) GetCharacterCard(c *gin.Context) {
+	character := c.Param("character")
+	user := c.Query("user")
+
+	card, err := h.stService.GetCharacterCard(user, character)
+	if err != nil {
+		status := http.StatusInternalServerError
+		if strings.Contains(err.Error(), "does not exist") {
+			status = http.StatusNotFound
+		} else if strings.Contains(err.Error(), "invalid character name") {
+			status = http.StatusBadRequest
+		}
+
+		c.JSON(status, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, card)
+}
+
+func (h *CharactersHandler) GetCharacterAvatar(c *gin.Context) {
+	character := c.Param("character")
+	user := c.Query("user")
+
+	// Defaults match SillyTavern's avatar thumbnail size, 0 returns the full image
+	width, err := strconv.Atoi(c.DefaultQuery("width", "96"))
+	if err != nil {
+		width = 96
+	}
+
+	height, err := strconv.Atoi(c.DefaultQuery("height", "144"))
+	if err != nil {
+		height = 144
+	}
+
+	avatar, modTime, err := h.stService.GetCharacterAvatar(user, character, width, height)
+	if err != nil {
+		status := http.StatusInternalServerError
+		if strings.Contains(err.Error(), "does not exist") {
+			status = http.StatusNotFound
+		} else if strings.Contains(err.Error(), "invalid character name") {
+			status = http.StatusBadRequest
+		}
+
+		c.JSON(status, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	c.Header("Content-Type", "image/png")
+	c.Header("Cache-Control", "private, max-age=300")
+	http.ServeContent(c.Writer, c.Request, character+".png", modTime, bytes.NewReader(avatar))
+}
+
 func (h *CharactersHandler) GetCharacterBackups(c *gin.Context) {
 	character := c.Param("character")
 	user := c.Query("user")
@@ -51,7 +113,34 @@ func (h *CharactersHandler) GetCharacterBackup(c *gin.Context) {
 	backup := c.Param("backup")
 	user := c.Query("user")
 
-	messages, err := h.stService.GetCharacterBackup(user, character, backup)
+	query, paged, err := parseChatQuery(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	if paged {
+		page, err := h.stService.GetCharacterBackupPage(user, character, backup, query)
+		if err != nil {
+			writeBackupError(c, err)
+			return
+		}
+		page.Messages = applyViewRegex(c, h.stService, []string{character}, page.Messages, page.Start, page.Total)
+
+		writeChatPage(c, page, query)
+		return
+	}
+
+	var messages []models.ChatMessage
+	if query.Lenient {
+		var skipped []models.ChatLineError
+		messages, skipped, err = h.stService.GetCharacterBackupLenient(user, character, backup)
+		setSkippedLines(c, skipped)
+	} else {
+		messages, err = h.stService.GetCharacterBackup(user, character, backup)
+	}
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"error": err.Error(),
@@ -59,7 +148,8 @@ func (h *CharactersHandler) GetCharacterBackup(c *gin.Context) {
 		return
 	}
 
-	messageContent := renderMessages(messages)
+	messages = applyViewRegex(c, h.stService, []string{character}, messages, 0, len(messages))
+	messageContent := renderMessages(messages, viewVisibility(c))
 
 	c.JSON(http.StatusOK, messageContent)
 }
@@ -83,6 +173,7 @@ func (h *CharactersHandler) RestoreCharacterBackup(c *gin.Context) {
 	})
 }
 
+// GetUsers lists the SillyTavern users the request may access
 func (h *CharactersHandler) GetUsers(c *gin.Context) {
 	users, err := h.stService.GetUsers()
 	if err != nil {
@@ -92,5 +183,77 @@ func (h *CharactersHandler) GetUsers(c *gin.Context) {
 		return
 	}
 
-	c.JSON(http.StatusOK, users)
+	allowed := []string{}
+	for _, user := range users {
+		if middleware.UserAllowed(c, user) {
+			allowed = append(allowed, user)
+		}
+	}
+
+	c.JSON(http.StatusOK, allowed)
+}
+
+// ValidateCharacterBackup reports every problem found in the backup file
+func (h *CharactersHandler) ValidateCharacterBackup(c *gin.Context) {
+	validation, err := h.stService.ValidateCharacterBackup(c.Query("user"), c.Param("character"), c.Param("backup"))
+	if err != nil {
+		writeBackupError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, validation)
+}
+
+// RepairCharacterBackup writes a fixed copy of the backup as a new chat branch
+func (h *CharactersHandler) RepairCharacterBackup(c *gin.Context) {
+	repair, err := h.stService.RepairCharacterBackup(c.Query("user"), c.Param("character"), c.Param("backup"))
+	if err != nil {
+		writeBackupError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, repair)
+}
+
+// GetCharacterBranches returns the tree of the character's chats and backups, linked by the messages they share
+func (h *CharactersHandler) GetCharacterBranches(c *gin.Context) {
+	tree, err := services.BuildBranchTree(h.stService, c.Query("user"), c.Param("character"), c.Query("lenient") == "true")
+	if err != nil {
+		writeBackupError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, tree)
+}
+
+// GetCharacterBranchDiff compares two nodes of the branch tree, given as a=<id>&b=<id>
+func (h *CharactersHandler) GetCharacterBranchDiff(c *gin.Context) {
+	a, b := c.Query("a"), c.Query("b")
+	if a == "" || b == "" {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "a and b are required",
+		})
+		return
+	}
+
+	diff, err := services.DiffBranches(h.stService, c.Query("user"), c.Param("character"), a, b)
+	if err != nil {
+		writeBackupError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, diff)
+}
+
+func writeBackupError(c *gin.Context, err error) {
+	status := http.StatusInternalServerError
+	if strings.Contains(err.Error(), "does not exist") {
+		status = http.StatusNotFound
+	} else if isInvalidRequest(err) || strings.Contains(err.Error(), "does not match") {
+		status = http.StatusBadRequest
+	}
+
+	c.JSON(status, gin.H{
+		"error": err.Error(),
+	})
 }
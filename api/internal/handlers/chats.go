@@ -1,24 +1,23 @@
 package handlers
 
 import (
-	"fmt"
 	"net/http"
-	"strconv"
 	"strings"
 
+	"craigstjean.com/stsummarizer/internal/models"
 	"craigstjean.com/stsummarizer/internal/services"
 	"github.com/gin-gonic/gin"
 )
 
 type ChatsHandler struct {
-	stService     services.SillyTavernService
-	ollamaService *services.OllamaService
+	stService  services.SillyTavernService
+	summarizer *services.Summarizer
 }
 
-func NewChatsHandler(stService services.SillyTavernService, ollamaService *services.OllamaService) *ChatsHandler {
+func NewChatsHandler(stService services.SillyTavernService, summarizer *services.Summarizer) *ChatsHandler {
 	return &ChatsHandler{
-		stService:     stService,
-		ollamaService: ollamaService,
+		stService:  stService,
+		summarizer: summarizer,
 	}
 }
 
@@ -42,71 +41,159 @@ func (h *ChatsHandler) GetChat(c *gin.Context) {
 	character := c.Param("character")
 	chat := c.Param("chat")
 
-	messages, err := h.stService.GetCharacterChat(user, character, chat)
+	query, paged, err := parseChatQuery(c)
 	if err != nil {
-		status := http.StatusInternalServerError
-		if strings.Contains(err.Error(), "does not exist") {
-			status = http.StatusNotFound
-		} else if strings.Contains(err.Error(), "invalid chat path") {
-			status = http.StatusBadRequest
-		}
-
-		c.JSON(status, gin.H{
+		c.JSON(http.StatusBadRequest, gin.H{
 			"error": err.Error(),
 		})
 		return
 	}
 
-	messageContent := renderMessages(messages)
+	if paged {
+		page, err := h.stService.GetCharacterChatPage(user, character, chat, query)
+		if err != nil {
+			writeChatError(c, err)
+			return
+		}
+		page.Messages = applyViewRegex(c, h.stService, []string{character}, page.Messages, page.Start, page.Total)
+
+		writeChatPage(c, page, query)
+		return
+	}
+
+	var messages []models.ChatMessage
+	if query.Lenient {
+		var skipped []models.ChatLineError
+		messages, skipped, err = h.stService.GetCharacterChatLenient(user, character, chat)
+		setSkippedLines(c, skipped)
+	} else {
+		messages, err = h.stService.GetCharacterChat(user, character, chat)
+	}
+	if err != nil {
+		writeChatError(c, err)
+		return
+	}
+
+	messages = applyViewRegex(c, h.stService, []string{character}, messages, 0, len(messages))
+	messageContent := renderMessages(messages, viewVisibility(c))
 
 	c.JSON(http.StatusOK, messageContent)
 }
 
 func (h *ChatsHandler) GetChatSummary(c *gin.Context) {
-	user := c.Query("user")
-	model := c.Query("model")
+	ref := models.ChatRef{
+		User:      c.Query("user"),
+		Character: c.Param("character"),
+		Chat:      c.Param("chat"),
+	}
 
-	maxTokensStr := c.DefaultQuery("max_tokens", "3500")
-	maxTokens, err := strconv.Atoi(maxTokensStr)
+	req, err := parseSummaryRequest(c, ref)
 	if err != nil {
-		maxTokens = 3500
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	writeSummary(c, h.summarizer, req)
+}
+
+// GetChatTokens counts the tokens of the chat and shows how it would be chunked for a summary
+func (h *ChatsHandler) GetChatTokens(c *gin.Context) {
+	ref := models.ChatRef{
+		User:      c.Query("user"),
+		Character: c.Param("character"),
+		Chat:      c.Param("chat"),
 	}
 
-	summaryWordsStr := c.DefaultQuery("summary_words", "400")
-	summaryWords, err := strconv.Atoi(summaryWordsStr)
+	req, err := parseSummaryRequest(c, ref)
 	if err != nil {
-		summaryWords = 400
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
 	}
 
-	character := c.Param("character")
-	chat := c.Param("chat")
+	writeSummaryPlan(c, h.summarizer, req)
+}
 
-	// Get chat messages
-	messages, err := h.stService.GetCharacterChat(user, character, chat)
+// GetCharacterStory merges the summaries of every chat of the character into one story so far. The chats' cached
+// summaries are reused unless cached=false.
+func (h *ChatsHandler) GetCharacterStory(c *gin.Context) {
+	req, err := parseSummaryRequest(c, models.ChatRef{User: c.Query("user"), Character: c.Param("character")})
 	if err != nil {
-		status := http.StatusInternalServerError
-		if strings.Contains(err.Error(), "does not exist") {
-			status = http.StatusNotFound
-		} else if strings.Contains(err.Error(), "invalid chat path") {
-			status = http.StatusBadRequest
-		}
-
-		c.JSON(status, gin.H{
+		c.JSON(http.StatusBadRequest, gin.H{
 			"error": err.Error(),
 		})
 		return
 	}
+	if req.From != nil || req.To != nil || req.Since != nil || req.Until != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "invalid story: ranges only apply to a single chat",
+		})
+		return
+	}
+	req.UseCache = c.Query("cached") != "false"
+
+	story, err := h.summarizer.Story(c.Request.Context(), req)
+	if err != nil {
+		writeSummaryError(c, "failed to generate story", err)
+		return
+	}
 
-	messageContent := renderMessagesForSummary(messages)
+	c.JSON(http.StatusOK, story)
+}
 
-	// Get summary from Ollama
-	summary, err := h.ollamaService.SummarizeChat(model, messageContent, maxTokens, summaryWords)
+// ValidateChat reports every problem found in the chat file
+func (h *ChatsHandler) ValidateChat(c *gin.Context) {
+	validation, err := h.stService.ValidateCharacterChat(c.Query("user"), c.Param("character"), c.Param("chat"))
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": fmt.Sprintf("failed to generate summary: %v", err),
+		writeChatError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, validation)
+}
+
+// RepairChat writes a fixed copy of the chat as a new branch, the original is never modified
+func (h *ChatsHandler) RepairChat(c *gin.Context) {
+	repair, err := h.stService.RepairCharacterChat(c.Query("user"), c.Param("character"), c.Param("chat"))
+	if err != nil {
+		writeChatError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, repair)
+}
+
+// GetChatSwipes compares the swipes of the message at the given index
+func (h *ChatsHandler) GetChatSwipes(c *gin.Context) {
+	index, query, err := parseMessageIndex(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
 		})
 		return
 	}
 
-	c.JSON(http.StatusOK, summary)
+	page, err := h.stService.GetCharacterChatPage(c.Query("user"), c.Param("character"), c.Param("chat"), query)
+	if err != nil {
+		writeChatError(c, err)
+		return
+	}
+
+	writeSwipes(c, index, page)
+}
+
+func writeChatError(c *gin.Context, err error) {
+	status := http.StatusInternalServerError
+	if strings.Contains(err.Error(), "does not exist") {
+		status = http.StatusNotFound
+	} else if strings.Contains(err.Error(), "invalid chat path") {
+		status = http.StatusBadRequest
+	}
+
+	c.JSON(status, gin.H{
+		"error": err.Error(),
+	})
 }
@@ -0,0 +1,23 @@
+package handlers
+
+import (
+	"net/http"
+
+	"craigstjean.com/stsummarizer/internal/config"
+	"github.com/gin-gonic/gin"
+)
+
+type ConfigHandler struct {
+	cfg *config.Config
+}
+
+func NewConfigHandler(cfg *config.Config) *ConfigHandler {
+	return &ConfigHandler{
+		cfg: cfg,
+	}
+}
+
+// GetConfig returns the effective configuration with secrets redacted
+func (h *ConfigHandler) GetConfig(c *gin.Context) {
+	c.JSON(http.StatusOK, h.cfg.Redacted())
+}
@@ -0,0 +1,85 @@
+package handlers
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"time"
+
+	"craigstjean.com/stsummarizer/internal/config"
+	"craigstjean.com/stsummarizer/internal/services/watcher"
+	"github.com/gin-gonic/gin"
+)
+
+const eventsKeepAlive = 30 * time.Second
+
+// serverContextKey holds the context cancelled when the server shuts down
+type serverContextKey struct{}
+
+// WithServerContext returns ctx carrying the server's context, for http.Server.BaseContext. Event streams end when
+// the server context is cancelled instead of holding the shutdown, other requests keep their own context and may
+// finish.
+func WithServerContext(ctx, server context.Context) context.Context {
+	return context.WithValue(ctx, serverContextKey{}, server)
+}
+
+// serverDone returns the channel closed when the server shuts down, nil when the request carries no server context
+func serverDone(ctx context.Context) <-chan struct{} {
+	if server, ok := ctx.Value(serverContextKey{}).(context.Context); ok {
+		return server.Done()
+	}
+
+	return nil
+}
+
+type EventsHandler struct {
+	watcher *watcher.Watcher
+}
+
+func NewEventsHandler(watcher *watcher.Watcher) *EventsHandler {
+	return &EventsHandler{
+		watcher: watcher,
+	}
+}
+
+// GetEvents streams chat, group chat and backup changes for the user as Server-Sent Events
+func (h *EventsHandler) GetEvents(c *gin.Context) {
+	if h.watcher == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{
+			"error": "file watching is not available",
+		})
+		return
+	}
+
+	user := c.DefaultQuery("user", config.STDefaultUser)
+
+	events, unsubscribe := h.watcher.Subscribe()
+	defer unsubscribe()
+
+	keepAlive := time.NewTicker(eventsKeepAlive)
+	defer keepAlive.Stop()
+
+	// Disable proxy buffering so events are delivered immediately through nginx
+	c.Header("X-Accel-Buffering", "no")
+
+	shutdown := serverDone(c.Request.Context())
+	c.Stream(func(w io.Writer) bool {
+		select {
+		case <-c.Request.Context().Done():
+			return false
+		case <-shutdown:
+			return false
+		case <-keepAlive.C:
+			c.SSEvent("ping", time.Now().Unix())
+			return true
+		case event, ok := <-events:
+			if !ok {
+				return false
+			}
+			if event.User == user {
+				c.SSEvent(string(event.Type), event)
+			}
+			return true
+		}
+	})
+}
@@ -1,24 +1,22 @@
 package handlers
 
 import (
-	"fmt"
 	"net/http"
-	"strconv"
-	"strings"
 
+	"craigstjean.com/stsummarizer/internal/models"
 	"craigstjean.com/stsummarizer/internal/services"
 	"github.com/gin-gonic/gin"
 )
 
 type GroupsHandler struct {
-	stService     services.SillyTavernService
-	ollamaService *services.OllamaService
+	stService  services.SillyTavernService
+	summarizer *services.Summarizer
 }
 
-func NewGroupsHandler(stService services.SillyTavernService, ollamaService *services.OllamaService) *GroupsHandler {
+func NewGroupsHandler(stService services.SillyTavernService, summarizer *services.Summarizer) *GroupsHandler {
 	return &GroupsHandler{
-		stService:     stService,
-		ollamaService: ollamaService,
+		stService:  stService,
+		summarizer: summarizer,
 	}
 }
 
@@ -40,70 +38,119 @@ func (h *GroupsHandler) GetGroupChat(c *gin.Context) {
 	user := c.Query("user")
 	chat := c.Param("chat")
 
-	messages, err := h.stService.GetGroupChat(user, chat)
+	query, paged, err := parseChatQuery(c)
 	if err != nil {
-		status := http.StatusInternalServerError
-		if strings.Contains(err.Error(), "does not exist") {
-			status = http.StatusNotFound
-		} else if strings.Contains(err.Error(), "invalid chat path") {
-			status = http.StatusBadRequest
-		}
-
-		c.JSON(status, gin.H{
+		c.JSON(http.StatusBadRequest, gin.H{
 			"error": err.Error(),
 		})
 		return
 	}
 
-	messageContent := renderMessages(messages)
+	if paged {
+		page, err := h.stService.GetGroupChatPage(user, chat, query)
+		if err != nil {
+			writeChatError(c, err)
+			return
+		}
+		page.Messages = applyViewRegex(c, h.stService, services.GroupMembers(h.stService, user, chat), page.Messages, page.Start, page.Total)
+
+		writeChatPage(c, page, query)
+		return
+	}
+
+	var messages []models.ChatMessage
+	if query.Lenient {
+		var skipped []models.ChatLineError
+		messages, skipped, err = h.stService.GetGroupChatLenient(user, chat)
+		setSkippedLines(c, skipped)
+	} else {
+		messages, err = h.stService.GetGroupChat(user, chat)
+	}
+	if err != nil {
+		writeChatError(c, err)
+		return
+	}
+
+	messages = applyViewRegex(c, h.stService, services.GroupMembers(h.stService, user, chat), messages, 0, len(messages))
+	messageContent := renderMessages(messages, viewVisibility(c))
 
 	c.JSON(http.StatusOK, messageContent)
 }
 
 func (h *GroupsHandler) GetGroupChatSummary(c *gin.Context) {
-	user := c.Query("user")
-	model := c.Query("model")
+	ref := models.ChatRef{
+		User:  c.Query("user"),
+		Chat:  c.Param("chat"),
+		Group: true,
+	}
 
-	maxTokensStr := c.DefaultQuery("max_tokens", "3500")
-	maxTokens, err := strconv.Atoi(maxTokensStr)
+	req, err := parseSummaryRequest(c, ref)
 	if err != nil {
-		maxTokens = 3500
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	writeSummary(c, h.summarizer, req)
+}
+
+// GetGroupChatTokens counts the tokens of the group chat and shows how it would be chunked for a summary
+func (h *GroupsHandler) GetGroupChatTokens(c *gin.Context) {
+	ref := models.ChatRef{
+		User:  c.Query("user"),
+		Chat:  c.Param("chat"),
+		Group: true,
 	}
 
-	summaryWordsStr := c.DefaultQuery("summary_words", "400")
-	summaryWords, err := strconv.Atoi(summaryWordsStr)
+	req, err := parseSummaryRequest(c, ref)
 	if err != nil {
-		summaryWords = 400
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
 	}
 
-	chat := c.Param("chat")
+	writeSummaryPlan(c, h.summarizer, req)
+}
 
-	// Get chat messages
-	messages, err := h.stService.GetGroupChat(user, chat)
+// ValidateGroupChat reports every problem found in the group chat file
+func (h *GroupsHandler) ValidateGroupChat(c *gin.Context) {
+	validation, err := h.stService.ValidateGroupChat(c.Query("user"), c.Param("chat"))
 	if err != nil {
-		status := http.StatusInternalServerError
-		if strings.Contains(err.Error(), "does not exist") {
-			status = http.StatusNotFound
-		} else if strings.Contains(err.Error(), "invalid chat path") {
-			status = http.StatusBadRequest
-		}
+		writeChatError(c, err)
+		return
+	}
 
-		c.JSON(status, gin.H{
-			"error": err.Error(),
-		})
+	c.JSON(http.StatusOK, validation)
+}
+
+// RepairGroupChat writes a fixed copy of the group chat as a new branch of the group, the original is never modified
+func (h *GroupsHandler) RepairGroupChat(c *gin.Context) {
+	repair, err := h.stService.RepairGroupChat(c.Query("user"), c.Param("chat"))
+	if err != nil {
+		writeChatError(c, err)
 		return
 	}
 
-	messageContent := renderMessagesForSummary(messages)
+	c.JSON(http.StatusCreated, repair)
+}
 
-	// Get summary from Ollama
-	summary, err := h.ollamaService.SummarizeChat(model, messageContent, maxTokens, summaryWords)
+// GetGroupChatSwipes compares the swipes of the message at the given index
+func (h *GroupsHandler) GetGroupChatSwipes(c *gin.Context) {
+	index, query, err := parseMessageIndex(c)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": fmt.Sprintf("failed to generate summary: %v", err),
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
 		})
 		return
 	}
 
-	c.JSON(http.StatusOK, summary)
+	page, err := h.stService.GetGroupChatPage(c.Query("user"), c.Param("chat"), query)
+	if err != nil {
+		writeChatError(c, err)
+		return
+	}
+
+	writeSwipes(c, index, page)
 }
@@ -0,0 +1,71 @@
+package handlers
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"os"
+	"time"
+
+	"craigstjean.com/stsummarizer/internal/config"
+	"craigstjean.com/stsummarizer/internal/services"
+	"github.com/gin-gonic/gin"
+)
+
+// readyTimeout bounds each readiness check, so a hung Ollama does not hang the probe
+const readyTimeout = 5 * time.Second
+
+type HealthHandler struct {
+	ollamaService *services.OllamaService
+}
+
+func NewHealthHandler(ollamaService *services.OllamaService) *HealthHandler {
+	return &HealthHandler{
+		ollamaService: ollamaService,
+	}
+}
+
+// GetHealth reports that the process is up and serving requests
+func (h *HealthHandler) GetHealth(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"status": "ok",
+	})
+}
+
+// GetReady reports whether the service can do its work: SillyTavern's data directory is readable and Ollama answers.
+// The probe needs no credentials, so a failed check only reads "unavailable" and its cause is logged.
+func (h *HealthHandler) GetReady(c *gin.Context) {
+	checks := gin.H{}
+	ready := true
+
+	if _, err := os.ReadDir(config.GetSTDataPath()); err != nil {
+		slog.WarnContext(c.Request.Context(), "readiness check failed", "check", "sillytavern", "error", err)
+		checks["sillytavern"] = "unavailable"
+		ready = false
+	} else {
+		checks["sillytavern"] = "ok"
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), readyTimeout)
+	defer cancel()
+	if err := h.ollamaService.Ping(ctx); err != nil {
+		slog.WarnContext(c.Request.Context(), "readiness check failed", "check", "ollama", "error", err)
+		checks["ollama"] = "unavailable"
+		ready = false
+	} else {
+		checks["ollama"] = "ok"
+	}
+
+	if !ready {
+		c.JSON(http.StatusServiceUnavailable, gin.H{
+			"status": "unavailable",
+			"checks": checks,
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status": "ok",
+		"checks": checks,
+	})
+}
@@ -0,0 +1,198 @@
+package handlers
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"craigstjean.com/stsummarizer/internal/models"
+	"craigstjean.com/stsummarizer/internal/services"
+	"github.com/gin-gonic/gin"
+)
+
+type chatPageResponse struct {
+	Content    string `json:"content"`
+	Total      int    `json:"total"`
+	Start      int    `json:"start"`
+	End        int    `json:"end"`
+	NextOffset *int   `json:"next_offset"`
+	PrevOffset *int   `json:"prev_offset"`
+
+	Skipped []models.ChatLineError `json:"skipped,omitempty"`
+	// Messages lists every message with all of its swipes when swipes=true
+	Messages []messageView `json:"messages,omitempty"`
+}
+
+type messageView struct {
+	Index  int            `json:"index"`
+	Name   string         `json:"name"`
+	IsUser bool           `json:"is_user"`
+	Swipes []models.Swipe `json:"swipes"`
+}
+
+// parseChatQuery reads the offset, limit, tail, from, to and lenient parameters and validates visibility and regex.
+// The second return value is false when none are set, in which case the whole chat is returned as before.
+func parseChatQuery(c *gin.Context) (models.ChatQuery, bool, error) {
+	var query models.ChatQuery
+	paged := false
+
+	for _, param := range []struct {
+		name   string
+		target *int
+	}{
+		{"offset", &query.Offset},
+		{"limit", &query.Limit},
+		{"tail", &query.Tail},
+	} {
+		value, ok := c.GetQuery(param.name)
+		if !ok {
+			continue
+		}
+
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			return query, false, fmt.Errorf("invalid %s: %s", param.name, value)
+		}
+		*param.target = n
+		paged = true
+	}
+
+	for _, param := range []struct {
+		name   string
+		target **int
+	}{
+		{"from", &query.From},
+		{"to", &query.To},
+	} {
+		value, ok := c.GetQuery(param.name)
+		if !ok {
+			continue
+		}
+
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			return query, false, fmt.Errorf("invalid %s: %s", param.name, value)
+		}
+		*param.target = &n
+		paged = true
+	}
+
+	query.Lenient = c.Query("lenient") == "true"
+	if _, err := services.ParseVisibility(c.Query("visibility"), services.VisibilityAll); err != nil {
+		return query, false, err
+	}
+	if _, err := services.ParseRegexMode(c.Query("regex")); err != nil {
+		return query, false, err
+	}
+	// The swipes are only in the paged response
+	if c.Query("swipes") == "true" {
+		paged = true
+	}
+
+	return query, paged, nil
+}
+
+// writeChatPage responds with the rendered page and the cursors for the neighbouring pages
+func writeChatPage(c *gin.Context, page *models.ChatPage, query models.ChatQuery) {
+	end := page.Start + len(page.Messages)
+	response := chatPageResponse{
+		Content: renderMessages(page.Messages, viewVisibility(c)),
+		Total:   page.Total,
+		Start:   page.Start,
+		End:     end,
+		Skipped: page.Skipped,
+	}
+
+	if c.Query("swipes") == "true" {
+		response.Messages = make([]messageView, len(page.Messages))
+		for i, message := range page.Messages {
+			response.Messages[i] = messageView{
+				Index:  message.Index,
+				Name:   message.Name,
+				IsUser: message.IsUser,
+				Swipes: services.MessageSwipes(message),
+			}
+		}
+	}
+
+	pageSize := len(page.Messages)
+	if query.Limit > 0 {
+		pageSize = query.Limit
+	} else if query.Tail > 0 {
+		pageSize = query.Tail
+	}
+
+	if end < page.Total {
+		response.NextOffset = &end
+	}
+	if page.Start > 0 && pageSize > 0 {
+		prev := max(page.Start-pageSize, 0)
+		response.PrevOffset = &prev
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// setSkippedLines lists the line numbers skipped by a lenient read in the X-Skipped-Lines header
+func setSkippedLines(c *gin.Context, skipped []models.ChatLineError) {
+	if len(skipped) == 0 {
+		return
+	}
+
+	lines := make([]string, len(skipped))
+	for i, line := range skipped {
+		lines[i] = strconv.Itoa(line.Line)
+	}
+	c.Header("X-Skipped-Lines", strings.Join(lines, ","))
+}
+
+// parseMessageIndex reads the :index path parameter and returns a query for that single message
+func parseMessageIndex(c *gin.Context) (int, models.ChatQuery, error) {
+	index, err := strconv.Atoi(c.Param("index"))
+	if err != nil || index < 0 {
+		return 0, models.ChatQuery{}, fmt.Errorf("invalid message index: %s", c.Param("index"))
+	}
+
+	return index, models.ChatQuery{From: &index, To: &index}, nil
+}
+
+// writeSwipes responds with the swipes of the single message in the page
+func writeSwipes(c *gin.Context, index int, page *models.ChatPage) {
+	if len(page.Messages) == 0 {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": fmt.Sprintf("message %d does not exist, the chat has %d messages", index, page.Total),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, services.CompareSwipes(index, page.Messages[0]))
+}
+
+// viewVisibility is the visibility policy of a chat view, views show every message unless asked otherwise
+func viewVisibility(c *gin.Context) string {
+	visibility, err := services.ParseVisibility(c.Query("visibility"), services.VisibilityAll)
+	if err != nil {
+		return services.VisibilityAll
+	}
+
+	return visibility
+}
+
+// applyViewRegex runs the user's regex scripts over the messages when the regex parameter is set. start and total
+// place the messages in the chat, for the scripts' depth limits.
+func applyViewRegex(c *gin.Context, stService services.SillyTavernService, characters []string, messages []models.ChatMessage, start, total int) []models.ChatMessage {
+	mode, err := services.ParseRegexMode(c.Query("regex"))
+	if err != nil || mode == "" {
+		return messages
+	}
+
+	scripts, err := stService.GetRegexScripts(c.Query("user"), characters)
+	if err != nil {
+		slog.WarnContext(c.Request.Context(), "regex scripts not applied", "user", c.Query("user"), "error", err)
+		return messages
+	}
+
+	return services.ApplyRegexScripts(messages, scripts, mode, start, total)
+}
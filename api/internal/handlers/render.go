@@ -5,38 +5,24 @@ import (
 	"strings"
 
 	"craigstjean.com/stsummarizer/internal/models"
+	"craigstjean.com/stsummarizer/internal/services"
 )
 
-func renderMessages(messages []models.ChatMessage) string {
+// renderMessages renders the messages kept by the visibility policy as "**Name**: text"
+func renderMessages(messages []models.ChatMessage, visibility string) string {
 	var sb strings.Builder
 
-	for _, message := range messages {
+	for _, message := range services.ApplyVisibility(messages, visibility) {
 		userSuffix := ""
 		if message.IsUser {
 			userSuffix = " (User)"
 		}
 
-		if message.Name != "" && message.Message != "" {
-			sb.WriteString(fmt.Sprintf("**%s**%s: %s\n\n---\n\n", message.Name, userSuffix, message.Message))
+		text := services.ActiveSwipe(message)
+		if message.Name != "" && text != "" {
+			sb.WriteString(fmt.Sprintf("**%s**%s: %s\n\n---\n\n", message.Name, userSuffix, text))
 		}
 	}
 
 	return sb.String()
 }
-
-func renderMessagesForSummary(messages []models.ChatMessage) []string {
-	renderedMessages := make([]string, len(messages))
-
-	for _, message := range messages {
-		userSuffix := ""
-		if message.IsUser {
-			userSuffix = " (User)"
-		}
-
-		if message.Name != "" && message.Message != "" {
-			renderedMessages = append(renderedMessages, fmt.Sprintf("**%s**%s: %s\n\n---\n\n", message.Name, userSuffix, message.Message))
-		}
-	}
-
-	return renderedMessages
-}
@@ -0,0 +1,132 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"craigstjean.com/stsummarizer/internal/models"
+	"craigstjean.com/stsummarizer/internal/services"
+	"craigstjean.com/stsummarizer/internal/services/rules"
+	"github.com/gin-gonic/gin"
+)
+
+type RulesHandler struct {
+	scheduler  *rules.Scheduler
+	summarizer *services.Summarizer
+}
+
+func NewRulesHandler(scheduler *rules.Scheduler, summarizer *services.Summarizer) *RulesHandler {
+	return &RulesHandler{
+		scheduler:  scheduler,
+		summarizer: summarizer,
+	}
+}
+
+func (h *RulesHandler) GetRules(c *gin.Context) {
+	c.JSON(http.StatusOK, h.scheduler.Rules())
+}
+
+func (h *RulesHandler) GetRule(c *gin.Context) {
+	rule, err := h.scheduler.Rule(c.Param("id"))
+	if err != nil {
+		writeRuleError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, rule)
+}
+
+func (h *RulesHandler) CreateRule(c *gin.Context) {
+	var rule models.SummaryRule
+	if err := c.ShouldBindJSON(&rule); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": fmt.Sprintf("invalid request body: %v", err),
+		})
+		return
+	}
+
+	created, err := h.scheduler.CreateRule(rule)
+	if err != nil {
+		writeRuleError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, created)
+}
+
+func (h *RulesHandler) UpdateRule(c *gin.Context) {
+	var rule models.SummaryRule
+	if err := c.ShouldBindJSON(&rule); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": fmt.Sprintf("invalid request body: %v", err),
+		})
+		return
+	}
+
+	updated, err := h.scheduler.UpdateRule(c.Param("id"), rule)
+	if err != nil {
+		writeRuleError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, updated)
+}
+
+func (h *RulesHandler) DeleteRule(c *gin.Context) {
+	if err := h.scheduler.DeleteRule(c.Param("id")); err != nil {
+		writeRuleError(c, err)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// RunRule regenerates the rule's summary immediately, ignoring its thresholds
+func (h *RulesHandler) RunRule(c *gin.Context) {
+	rule, err := h.scheduler.RunRule(c.Param("id"))
+	if err != nil {
+		writeRuleError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, rule)
+}
+
+// GetRuleSummary returns the latest summary stored for the rule's chat
+func (h *RulesHandler) GetRuleSummary(c *gin.Context) {
+	rule, err := h.scheduler.Rule(c.Param("id"))
+	if err != nil {
+		writeRuleError(c, err)
+		return
+	}
+
+	summary, err := h.summarizer.Cached(rule.ChatRef)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+	if summary == nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "summary does not exist yet",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, summary)
+}
+
+func writeRuleError(c *gin.Context, err error) {
+	status := http.StatusInternalServerError
+	if strings.Contains(err.Error(), "does not exist") {
+		status = http.StatusNotFound
+	} else if isInvalidRequest(err) {
+		status = http.StatusBadRequest
+	}
+
+	c.JSON(status, gin.H{
+		"error": err.Error(),
+	})
+}
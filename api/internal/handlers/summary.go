@@ -0,0 +1,192 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"craigstjean.com/stsummarizer/internal/config"
+	"craigstjean.com/stsummarizer/internal/models"
+	"craigstjean.com/stsummarizer/internal/services"
+	"github.com/gin-gonic/gin"
+)
+
+// summaryBody is the optional JSON body of a POST to a summary endpoint, for prior summaries too long for a query string
+type summaryBody struct {
+	PriorSummary string `json:"prior_summary"`
+}
+
+// queryInt reads a non-negative integer query parameter, fallback when it is missing
+func queryInt(c *gin.Context, name string, fallback int) (int, error) {
+	value, ok := c.GetQuery(name)
+	if !ok {
+		return fallback, nil
+	}
+
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid %s: %s", name, value)
+	}
+	return n, nil
+}
+
+// parseSummaryRequest reads the summary options shared by the chat and group chat summary endpoints
+func parseSummaryRequest(c *gin.Context, ref models.ChatRef) (services.SummaryRequest, error) {
+	defaults := config.Get().Summary
+
+	maxTokens, err := queryInt(c, "max_tokens", defaults.MaxTokens)
+	if err != nil {
+		return services.SummaryRequest{}, err
+	}
+	if maxTokens < services.MinChunkTokens {
+		return services.SummaryRequest{}, fmt.Errorf("invalid max_tokens: %d is less than %d", maxTokens, services.MinChunkTokens)
+	}
+
+	summaryWords, err := queryInt(c, "summary_words", defaults.SummaryWords)
+	if err != nil {
+		return services.SummaryRequest{}, err
+	}
+
+	includeCards := c.Query("include_cards") == "true"
+
+	contextTokens, err := queryInt(c, "context_tokens", defaults.ContextTokens)
+	if err != nil {
+		return services.SummaryRequest{}, err
+	}
+
+	req := services.SummaryRequest{
+		ChatRef:   ref,
+		Model:     c.Query("model"),
+		MaxTokens: maxTokens,
+		WordLimit: summaryWords,
+		UseCache:  c.Query("cached") == "true",
+		Lenient:   c.Query("lenient") == "true",
+	}
+	if includeCards {
+		req.IncludeCards = true
+		req.ContextTokens = contextTokens
+	}
+
+	for _, param := range []struct {
+		name   string
+		target **int
+	}{
+		{"from", &req.From},
+		{"to", &req.To},
+	} {
+		value, ok := c.GetQuery(param.name)
+		if !ok {
+			continue
+		}
+
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			return req, fmt.Errorf("invalid %s: %s", param.name, value)
+		}
+		*param.target = &n
+	}
+
+	if since := c.Query("since"); since != "" {
+		t, err := services.ParseRangeDate(since, false)
+		if err != nil {
+			return req, err
+		}
+		req.Since = &t
+	}
+	if until := c.Query("until"); until != "" {
+		t, err := services.ParseRangeDate(until, true)
+		if err != nil {
+			return req, err
+		}
+		req.Until = &t
+	}
+
+	visibility, err := services.ParseVisibility(c.Query("visibility"), services.VisibilityPrompt)
+	if err != nil {
+		return req, err
+	}
+	req.Visibility = visibility
+
+	if req.Regex, err = services.ParseRegexMode(c.Query("regex")); err != nil {
+		return req, err
+	}
+
+	if c.Query("scenes") == "true" {
+		req.Scenes = true
+		req.SceneGap = services.DefaultSceneGap
+		if value := c.Query("scene_gap"); value != "" {
+			gap, err := time.ParseDuration(value)
+			if err != nil || gap < 0 {
+				return req, fmt.Errorf("invalid scene_gap: %s", value)
+			}
+			req.SceneGap = gap
+		}
+	}
+
+	req.PriorSummary = c.Query("prior_summary")
+	if c.Request.Method == http.MethodPost && c.Request.ContentLength != 0 {
+		var body summaryBody
+		if err := c.ShouldBindJSON(&body); err != nil {
+			return req, fmt.Errorf("invalid request body: %v", err)
+		}
+		if body.PriorSummary != "" {
+			req.PriorSummary = body.PriorSummary
+		}
+	}
+
+	return req, nil
+}
+
+// writeSummary summarizes the chat and responds with the partial summaries followed by the final summary
+func writeSummary(c *gin.Context, summarizer *services.Summarizer, req services.SummaryRequest) {
+	summary, fromCache, err := summarizer.Summarize(c.Request.Context(), req)
+	if err != nil {
+		writeSummaryError(c, "failed to generate summary", err)
+		return
+	}
+
+	setSkippedLines(c, summary.Skipped)
+	if fromCache {
+		c.Header("X-Summary-Cache", "hit")
+	} else {
+		c.Header("X-Summary-Cache", "miss")
+	}
+
+	c.JSON(http.StatusOK, summary.Summaries)
+}
+
+// writeSummaryPlan responds with the token counts and chunks of the chat, without summarizing it
+func writeSummaryPlan(c *gin.Context, summarizer *services.Summarizer, req services.SummaryRequest) {
+	plan, skipped, err := summarizer.Plan(c.Request.Context(), req)
+	if err != nil {
+		writeSummaryError(c, "failed to count tokens", err)
+		return
+	}
+
+	setSkippedLines(c, skipped)
+	c.JSON(http.StatusOK, plan)
+}
+
+func writeSummaryError(c *gin.Context, prefix string, err error) {
+	status := http.StatusInternalServerError
+	message := fmt.Sprintf("%s: %v", prefix, err)
+	if strings.Contains(err.Error(), "does not exist") {
+		status = http.StatusNotFound
+		message = err.Error()
+	} else if isInvalidRequest(err) {
+		status = http.StatusBadRequest
+		message = err.Error()
+	}
+
+	c.JSON(status, gin.H{
+		"error": message,
+	})
+}
+
+// isInvalidRequest reports whether err rejects the request itself. Those errors start with "invalid", a model or
+// decoding failure that merely mentions it, such as "failed to decode Ollama response: invalid character", does not.
+func isInvalidRequest(err error) bool {
+	return strings.HasPrefix(err.Error(), "invalid ")
+}
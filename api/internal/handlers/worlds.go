@@ -0,0 +1,200 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"craigstjean.com/stsummarizer/internal/config"
+	"craigstjean.com/stsummarizer/internal/models"
+	"craigstjean.com/stsummarizer/internal/services"
+	"github.com/gin-gonic/gin"
+)
+
+type WorldsHandler struct {
+	stService     services.SillyTavernService
+	ollamaService *services.OllamaService
+	cleaner       *services.Cleaner
+}
+
+type saveWorldEntriesRequest struct {
+	Entries []models.WorldInfoEntry `json:"entries"`
+}
+
+func NewWorldsHandler(stService services.SillyTavernService, ollamaService *services.OllamaService, cleaner *services.Cleaner) *WorldsHandler {
+	return &WorldsHandler{
+		stService:     stService,
+		ollamaService: ollamaService,
+		cleaner:       cleaner,
+	}
+}
+
+func (h *WorldsHandler) GetWorlds(c *gin.Context) {
+	user := c.Query("user")
+
+	worlds, err := h.stService.GetWorlds(user)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, worlds)
+}
+
+func (h *WorldsHandler) GetWorld(c *gin.Context) {
+	user := c.Query("user")
+	world := c.Param("world")
+
+	lorebook, err := h.stService.GetWorld(user, world)
+	if err != nil {
+		status := http.StatusInternalServerError
+		if strings.Contains(err.Error(), "does not exist") {
+			status = http.StatusNotFound
+		} else if strings.Contains(err.Error(), "invalid world name") {
+			status = http.StatusBadRequest
+		}
+
+		c.JSON(status, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, lorebook)
+}
+
+func (h *WorldsHandler) SaveWorldEntries(c *gin.Context) {
+	user := c.Query("user")
+	world := c.Param("world")
+
+	var request saveWorldEntriesRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": fmt.Sprintf("invalid request body: %v", err),
+		})
+		return
+	}
+
+	lorebook, err := h.stService.SaveWorldEntries(user, world, request.Entries)
+	if err != nil {
+		status := http.StatusInternalServerError
+		if strings.Contains(err.Error(), "does not exist") {
+			status = http.StatusNotFound
+		} else if isInvalidRequest(err) {
+			status = http.StatusBadRequest
+		}
+
+		c.JSON(status, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, lorebook)
+}
+
+func (h *WorldsHandler) ProposeChatWorldInfo(c *gin.Context) {
+	user := c.Query("user")
+	character := c.Param("character")
+	chat := c.Param("chat")
+
+	messages, err := h.stService.GetCharacterChat(user, character, chat)
+	if err != nil {
+		status := http.StatusInternalServerError
+		if strings.Contains(err.Error(), "does not exist") {
+			status = http.StatusNotFound
+		} else if strings.Contains(err.Error(), "invalid chat path") {
+			status = http.StatusBadRequest
+		}
+
+		c.JSON(status, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	h.proposeWorldInfo(c, user, []string{character}, messages)
+}
+
+func (h *WorldsHandler) ProposeGroupChatWorldInfo(c *gin.Context) {
+	user := c.Query("user")
+	chat := c.Param("chat")
+
+	messages, err := h.stService.GetGroupChat(user, chat)
+	if err != nil {
+		status := http.StatusInternalServerError
+		if strings.Contains(err.Error(), "does not exist") {
+			status = http.StatusNotFound
+		} else if strings.Contains(err.Error(), "invalid chat path") {
+			status = http.StatusBadRequest
+		}
+
+		c.JSON(status, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	h.proposeWorldInfo(c, user, services.GroupMembers(h.stService, user, chat), messages)
+}
+
+// proposeWorldInfo extracts candidate entries from the messages and diffs them against the "world" lorebook, if given
+func (h *WorldsHandler) proposeWorldInfo(c *gin.Context, user string, characters []string, messages []models.ChatMessage) {
+	model := c.Query("model")
+	world := c.Query("world")
+
+	defaults := config.Get().Summary
+
+	maxTokensStr := c.DefaultQuery("max_tokens", strconv.Itoa(defaults.MaxTokens))
+	maxTokens, err := strconv.Atoi(maxTokensStr)
+	if err != nil {
+		maxTokens = defaults.MaxTokens
+	}
+
+	var lorebook *models.Lorebook
+	if world != "" {
+		lorebook, err = h.stService.GetWorld(user, world)
+		if err != nil && !strings.Contains(err.Error(), "does not exist") {
+			status := http.StatusInternalServerError
+			if strings.Contains(err.Error(), "invalid world name") {
+				status = http.StatusBadRequest
+			}
+
+			c.JSON(status, gin.H{
+				"error": err.Error(),
+			})
+			return
+		}
+	}
+
+	// Card context keeps the model from proposing entries for what the character card already covers
+	opts := services.SummaryOptions{
+		Model:         model,
+		MaxTokens:     maxTokens,
+		Context:       services.BuildSummaryContext(h.stService, user, characters, messages),
+		ContextTokens: defaults.ContextTokens,
+	}
+
+	visible := services.ApplyVisibility(messages, services.VisibilityPrompt)
+	if h.cleaner != nil {
+		visible = h.cleaner.CleanMessages(visible)
+	}
+
+	candidates, err := h.ollamaService.ExtractWorldInfo(c.Request.Context(), services.RenderMessagesForSummary(visible), opts)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": fmt.Sprintf("failed to extract world info: %v", err),
+		})
+		return
+	}
+
+	diff := services.DiffWorldInfo(lorebook, candidates)
+	if diff.World == "" {
+		diff.World = world
+	}
+
+	c.JSON(http.StatusOK, diff)
+}
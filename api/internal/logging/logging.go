@@ -0,0 +1,106 @@
+// Package logging sets up structured logging with log/slog and carries request IDs through contexts, so the lines a
+// request causes in services and Ollama calls can be found together.
+package logging
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+type requestIDKey struct{}
+
+// debug shows chat content in logs, set by Setup when the level is debug
+var debug bool
+
+// Setup makes a JSON or text handler at the level the default logger, which the standard log package writes to as
+// well. Chat content is only logged at the debug level.
+func Setup(level, format string) error {
+	var parsed slog.Level
+	if err := parsed.UnmarshalText([]byte(level)); err != nil {
+		return fmt.Errorf("invalid log level %q: expected debug, info, warn or error", level)
+	}
+
+	options := &slog.HandlerOptions{Level: parsed}
+	var handler slog.Handler
+	switch format {
+	case "json", "":
+		handler = slog.NewJSONHandler(os.Stderr, options)
+	case "text":
+		handler = slog.NewTextHandler(os.Stderr, options)
+	default:
+		return fmt.Errorf("invalid log format %q: expected json or text", format)
+	}
+
+	debug = parsed <= slog.LevelDebug
+	slog.SetDefault(slog.New(requestIDHandler{handler}))
+	return nil
+}
+
+// NewRequestID returns a random ID for a request that did not bring one
+func NewRequestID() string {
+	b := make([]byte, 8)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// WithRequestID returns a context carrying the request ID, which every log line written with it includes
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// RequestID returns the request ID of the context, empty when it has none
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// Content logs chat content, prompts and model responses only at the debug level, otherwise just their length
+func Content(key, content string) slog.Attr {
+	if debug {
+		return slog.String(key, content)
+	}
+
+	return slog.String(key, fmt.Sprintf("[redacted %d characters]", len(content)))
+}
+
+// requestIDHandler adds the request ID and, when tracing, the trace ID of the context to every record
+type requestIDHandler struct {
+	slog.Handler
+}
+
+func (h requestIDHandler) Handle(ctx context.Context, record slog.Record) error {
+	if id := RequestID(ctx); id != "" {
+		record.AddAttrs(slog.String("request_id", id))
+	}
+	if span := trace.SpanContextFromContext(ctx); span.IsValid() {
+		record.AddAttrs(slog.String("trace_id", span.TraceID().String()))
+	}
+
+	return h.Handler.Handle(ctx, record)
+}
+
+func (h requestIDHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return requestIDHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h requestIDHandler) WithGroup(name string) slog.Handler {
+	return requestIDHandler{h.Handler.WithGroup(name)}
+}
+
+// ValidRequestID reports whether an X-Request-ID sent by a client is safe to log and pass on
+func ValidRequestID(id string) bool {
+	if id == "" || len(id) > 64 {
+		return false
+	}
+
+	return strings.IndexFunc(id, func(r rune) bool {
+		return !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_' || r == '.')
+	}) < 0
+}
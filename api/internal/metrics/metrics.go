@@ -0,0 +1,88 @@
+// Package metrics holds the Prometheus metrics of the service, served at /metrics
+package metrics
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "stsummarizer"
+
+var (
+	HTTPRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "http_requests_total",
+		Help:      "HTTP requests by method, route and status code.",
+	}, []string{"method", "route", "status"})
+
+	HTTPDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "Time to serve HTTP requests by method and route.",
+		Buckets:   []float64{0.005, 0.025, 0.1, 0.5, 1, 5, 15, 60, 300},
+	}, []string{"method", "route"})
+
+	SummaryDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "summary_duration_seconds",
+		Help:      "Time to generate a chat summary, cached summaries excluded.",
+		Buckets:   []float64{1, 5, 15, 30, 60, 120, 300, 600, 1200},
+	})
+
+	SummaryChunks = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "summary_chunks",
+		Help:      "Chunks a chat was split into for a summary.",
+		Buckets:   []float64{1, 2, 3, 5, 8, 13, 21, 34},
+	})
+
+	OllamaTokens = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "ollama_tokens_total",
+		Help:      "Tokens sent to (prompt) and received from (response) Ollama, as Ollama counts them.",
+	}, []string{"direction"})
+
+	OllamaDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "ollama_request_duration_seconds",
+		Help:      "Time of each call to the model.",
+		Buckets:   []float64{0.5, 1, 5, 15, 30, 60, 120, 300, 600},
+	})
+
+	OllamaErrors = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "ollama_errors_total",
+		Help:      "Calls to the model that failed.",
+	})
+
+	CacheLookups = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "summary_cache_lookups_total",
+		Help:      "Summary cache lookups by result, hit when the cached summary was current.",
+	}, []string{"result"})
+)
+
+var registry = prometheus.NewRegistry()
+
+func init() {
+	registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		HTTPRequests,
+		HTTPDuration,
+		SummaryDuration,
+		SummaryChunks,
+		OllamaTokens,
+		OllamaDuration,
+		OllamaErrors,
+		CacheLookups,
+	)
+}
+
+// Handler serves the metrics in the Prometheus text format
+func Handler() http.Handler {
+	return promhttp.HandlerFor(registry, promhttp.HandlerOpts{})
+}
@@ -0,0 +1,218 @@
+package middleware
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"craigstjean.com/stsummarizer/internal/config"
+	"craigstjean.com/stsummarizer/internal/services"
+	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// principalKey holds the authenticated Principal in the gin context
+const principalKey = "auth.principal"
+
+// credentialTTL is how long a checked password is remembered, bcrypt and scrypt are too slow to run on every request
+const credentialTTL = 5 * time.Minute
+
+// Principal is who a request authenticated as and the SillyTavern users they may access
+type Principal struct {
+	Name  string
+	Users []string
+}
+
+// Allows reports whether the principal may access the SillyTavern user's data
+func (p *Principal) Allows(user string) bool {
+	return slices.Contains(p.Users, "*") || slices.Contains(p.Users, user)
+}
+
+// AllUsers reports whether the principal may access every user's data
+func (p *Principal) AllUsers() bool {
+	return slices.Contains(p.Users, "*")
+}
+
+type cachedCredential struct {
+	principal *Principal
+	expires   time.Time
+}
+
+// Authenticator checks the credentials of requests against the auth configuration
+type Authenticator struct {
+	cfg       config.AuthConfig
+	stService services.SillyTavernService
+
+	mu          sync.Mutex
+	credentials map[[32]byte]cachedCredential
+	// now is the clock cached credentials expire by, replaced in tests
+	now func() time.Time
+}
+
+func NewAuthenticator(cfg config.AuthConfig, stService services.SillyTavernService) *Authenticator {
+	return &Authenticator{
+		cfg:         cfg,
+		stService:   stService,
+		credentials: make(map[[32]byte]cachedCredential),
+		now:         time.Now,
+	}
+}
+
+// Auth authenticates every request and refuses those whose user query parameter (the default user when it is
+// missing) names a SillyTavern user the credentials may not access. Unscoped routes, given as "GET /api/models", do
+// not read a user's data and only check the parameter when it is set. Auth does nothing when authentication is off.
+func (a *Authenticator) Auth(unscoped ...string) gin.HandlerFunc {
+	unscopedRoutes := make(map[string]bool, len(unscoped))
+	for _, route := range unscoped {
+		unscopedRoutes[route] = true
+	}
+
+	return func(c *gin.Context) {
+		if !a.cfg.Enabled() {
+			c.Next()
+			return
+		}
+
+		principal := a.authenticate(c.Request)
+		if principal == nil {
+			if len(a.cfg.Users) > 0 || a.cfg.SillyTavern {
+				c.Header("WWW-Authenticate", `Basic realm="stsummarizer"`)
+			}
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
+				"error": "authentication required",
+			})
+			return
+		}
+
+		user := c.Query("user")
+		if user == "" && !unscopedRoutes[c.Request.Method+" "+c.FullPath()] {
+			user = config.STDefaultUser
+		}
+		if user != "" && !principal.Allows(user) {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{
+				"error": fmt.Sprintf("%s may not access user %s", principal.Name, user),
+			})
+			return
+		}
+
+		c.Set(principalKey, principal)
+		c.Next()
+	}
+}
+
+// RequireAllUsers guards routes that are not scoped by the user query parameter, such as rules, so only credentials
+// that may access every user reach them
+func (a *Authenticator) RequireAllUsers() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if principal := GetPrincipal(c); principal != nil && !principal.AllUsers() {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{
+				"error": fmt.Sprintf("%s may not access every user", principal.Name),
+			})
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// GetPrincipal returns who the request authenticated as, nil when authentication is off
+func GetPrincipal(c *gin.Context) *Principal {
+	value, ok := c.Get(principalKey)
+	if !ok {
+		return nil
+	}
+
+	return value.(*Principal)
+}
+
+// UserAllowed reports whether the request may access the SillyTavern user's data, for handlers that list data of
+// several users
+func UserAllowed(c *gin.Context, user string) bool {
+	principal := GetPrincipal(c)
+	return principal == nil || principal.Allows(user)
+}
+
+// authenticate checks a bearer token, basic auth or the access_token query parameter (for EventSource and images,
+// which cannot send headers), nil when none of them is valid
+func (a *Authenticator) authenticate(r *http.Request) *Principal {
+	if token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+		return a.checkToken(token)
+	}
+	if name, password, ok := r.BasicAuth(); ok {
+		return a.checkPassword(name, password)
+	}
+	if token := r.URL.Query().Get("access_token"); token != "" {
+		return a.checkToken(token)
+	}
+
+	return nil
+}
+
+func (a *Authenticator) checkToken(token string) *Principal {
+	for _, configured := range a.cfg.Tokens {
+		if subtle.ConstantTimeCompare([]byte(token), []byte(configured.Token)) == 1 {
+			return &Principal{Name: configured.Name, Users: configured.Users}
+		}
+	}
+
+	return nil
+}
+
+// checkPassword checks configured users first, then SillyTavern's accounts when they are accepted
+func (a *Authenticator) checkPassword(name, password string) *Principal {
+	key := sha256.Sum256([]byte(name + ":" + password))
+
+	a.mu.Lock()
+	cached, ok := a.credentials[key]
+	a.mu.Unlock()
+	if ok && a.now().Before(cached.expires) {
+		return cached.principal
+	}
+
+	principal := a.verifyPassword(name, password)
+	if principal == nil {
+		return nil
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for k, credential := range a.credentials {
+		if a.now().After(credential.expires) {
+			delete(a.credentials, k)
+		}
+	}
+	a.credentials[key] = cachedCredential{principal: principal, expires: a.now().Add(credentialTTL)}
+
+	return principal
+}
+
+func (a *Authenticator) verifyPassword(name, password string) *Principal {
+	for _, user := range a.cfg.Users {
+		if user.Name != name {
+			continue
+		}
+		if bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(password)) != nil {
+			return nil
+		}
+		return &Principal{Name: user.Name, Users: user.Users}
+	}
+
+	if !a.cfg.SillyTavern {
+		return nil
+	}
+
+	account, err := a.stService.VerifyUserPassword(name, password)
+	if err != nil {
+		return nil
+	}
+	if account.Admin {
+		return &Principal{Name: account.Handle, Users: []string{"*"}}
+	}
+
+	return &Principal{Name: account.Handle, Users: []string{account.Handle}}
+}
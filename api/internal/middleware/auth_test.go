@@ -0,0 +1,165 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"craigstjean.com/stsummarizer/internal/config"
+	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	testAdminToken = "admin-token-0123456789"
+	testAliceToken = "alice-token-0123456789"
+)
+
+func newTestAuthenticator(t *testing.T) *Authenticator {
+	t.Helper()
+
+	hash, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return NewAuthenticator(config.AuthConfig{
+		Tokens: []config.AuthToken{
+			{Name: "admin", Token: testAdminToken, Users: []string{"*"}},
+			{Name: "alice", Token: testAliceToken, Users: []string{"alice"}},
+		},
+		Users: []config.AuthUser{
+			{Name: "bob", PasswordHash: string(hash), Users: []string{"bob", config.STDefaultUser}},
+		},
+	}, nil)
+}
+
+// newTestRouter serves GET /api/chats as a user scoped route, GET /api/models as an unscoped one and GET /api/rules
+// behind RequireAllUsers
+func newTestRouter(a *Authenticator) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+
+	router := gin.New()
+	api := router.Group("/api", a.Auth("GET /api/models", "GET /api/rules"))
+	ok := func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	}
+	api.GET("/chats", ok)
+	api.GET("/models", ok)
+	api.GET("/rules", a.RequireAllUsers(), ok)
+
+	return router
+}
+
+func TestAuth(t *testing.T) {
+	router := newTestRouter(newTestAuthenticator(t))
+
+	bearer := func(token string) func(*http.Request) {
+		return func(r *http.Request) { r.Header.Set("Authorization", "Bearer "+token) }
+	}
+	basic := func(name, password string) func(*http.Request) {
+		return func(r *http.Request) { r.SetBasicAuth(name, password) }
+	}
+
+	tests := []struct {
+		name      string
+		path      string
+		auth      func(*http.Request)
+		want      int
+		challenge bool
+	}{
+		{"no credentials", "/api/chats?user=alice", nil, http.StatusUnauthorized, true},
+		{"bearer token", "/api/chats?user=alice", bearer(testAliceToken), http.StatusOK, false},
+		{"wrong bearer token", "/api/chats?user=alice", bearer("wrong-token-0123456789"), http.StatusUnauthorized, true},
+		{"basic auth", "/api/chats?user=bob", basic("bob", "secret"), http.StatusOK, false},
+		{"wrong password", "/api/chats?user=bob", basic("bob", "wrong"), http.StatusUnauthorized, true},
+		{"unknown user", "/api/chats?user=bob", basic("carol", "secret"), http.StatusUnauthorized, true},
+		{"access_token", "/api/chats?user=alice&access_token=" + testAliceToken, nil, http.StatusOK, false},
+		{"wrong access_token", "/api/chats?user=alice&access_token=wrong", nil, http.StatusUnauthorized, true},
+		{"another user", "/api/chats?user=bob", bearer(testAliceToken), http.StatusForbidden, false},
+		{"another user by basic auth", "/api/chats?user=alice", basic("bob", "secret"), http.StatusForbidden, false},
+		{"wildcard", "/api/chats?user=bob", bearer(testAdminToken), http.StatusOK, false},
+		{"default user on a scoped route", "/api/chats", bearer(testAliceToken), http.StatusForbidden, false},
+		{"default user allowed", "/api/chats", basic("bob", "secret"), http.StatusOK, false},
+		{"unscoped route without user", "/api/models", bearer(testAliceToken), http.StatusOK, false},
+		{"unscoped route with another user", "/api/models?user=bob", bearer(testAliceToken), http.StatusForbidden, false},
+		{"RequireAllUsers refuses a scoped token", "/api/rules", bearer(testAliceToken), http.StatusForbidden, false},
+		{"RequireAllUsers admits the wildcard", "/api/rules", bearer(testAdminToken), http.StatusOK, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			if tt.auth != nil {
+				tt.auth(req)
+			}
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			if w.Code != tt.want {
+				t.Errorf("status %d, want %d: %s", w.Code, tt.want, w.Body.String())
+			}
+			if got := w.Header().Get("WWW-Authenticate") != ""; got != tt.challenge {
+				t.Errorf("WWW-Authenticate set = %v, want %v", got, tt.challenge)
+			}
+		})
+	}
+}
+
+func TestAuthDisabled(t *testing.T) {
+	router := newTestRouter(NewAuthenticator(config.AuthConfig{}, nil))
+
+	for _, path := range []string{"/api/chats?user=alice", "/api/rules"} {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		if w.Code != http.StatusOK {
+			t.Errorf("%s: status %d without authentication, want 200", path, w.Code)
+		}
+	}
+}
+
+func TestCheckPasswordCache(t *testing.T) {
+	a := newTestAuthenticator(t)
+	now := time.Now()
+	a.now = func() time.Time { return now }
+
+	if a.checkPassword("bob", "secret") == nil {
+		t.Fatal("valid password refused")
+	}
+
+	// Changing the password is only noticed once the cached credential expires
+	hash, err := bcrypt.GenerateFromPassword([]byte("changed"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.cfg.Users[0].PasswordHash = string(hash)
+
+	now = now.Add(credentialTTL - time.Second)
+	if a.checkPassword("bob", "secret") == nil {
+		t.Error("cached credential refused before it expired")
+	}
+
+	now = now.Add(2 * time.Second)
+	if a.checkPassword("bob", "secret") != nil {
+		t.Error("old password accepted after the cached credential expired")
+	}
+	if a.checkPassword("bob", "changed") == nil {
+		t.Error("new password refused")
+	}
+
+	// Expired credentials are dropped when another one is cached
+	now = now.Add(credentialTTL + time.Second)
+	if a.checkPassword("bob", "changed") == nil {
+		t.Fatal("new password refused after expiry")
+	}
+	if len(a.credentials) != 1 {
+		t.Errorf("%d cached credentials, want only the latest", len(a.credentials))
+	}
+
+	// Failed attempts are not cached
+	a.checkPassword("bob", "wrong")
+	if len(a.credentials) != 1 {
+		t.Errorf("%d cached credentials after a failed attempt, want 1", len(a.credentials))
+	}
+}
@@ -1,15 +1,43 @@
 package middleware
 
-import "github.com/gin-gonic/gin"
+import (
+	"net/http"
+	"slices"
+	"strings"
+
+	"craigstjean.com/stsummarizer/internal/config"
+	"github.com/gin-gonic/gin"
+)
+
+// exposedHeaders are the response headers the frontend reads from other origins
+const exposedHeaders = "X-Summary-Cache, X-Skipped-Lines, Retry-After"
+
+// CORS answers preflight requests and lets the configured origins read responses. Requests from other origins are
+// served without CORS headers, so browsers keep their responses from the page.
+func CORS(cfg config.CORSConfig) gin.HandlerFunc {
+	anyOrigin := slices.Contains(cfg.AllowedOrigins, "*")
+	methods := strings.Join(cfg.AllowedMethods, ", ")
+	headers := strings.Join(cfg.AllowedHeaders, ", ")
 
-func CORS() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		c.Writer.Header().Set("Access-Control-Allow-Origin", "*")
-		c.Writer.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-		c.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+		origin := c.GetHeader("Origin")
+		allowed := origin != "" && (anyOrigin || slices.Contains(cfg.AllowedOrigins, strings.TrimSuffix(origin, "/")))
+
+		if allowed {
+			if anyOrigin {
+				c.Header("Access-Control-Allow-Origin", "*")
+			} else {
+				c.Header("Access-Control-Allow-Origin", origin)
+				c.Header("Vary", "Origin")
+			}
+			c.Header("Access-Control-Allow-Methods", methods)
+			c.Header("Access-Control-Allow-Headers", headers)
+			c.Header("Access-Control-Expose-Headers", exposedHeaders)
+			c.Header("Access-Control-Max-Age", "600")
+		}
 
-		if c.Request.Method == "OPTIONS" {
-			c.AbortWithStatus(204)
+		if c.Request.Method == http.MethodOptions {
+			c.AbortWithStatus(http.StatusNoContent)
 			return
 		}
 
@@ -1,11 +1,43 @@
 package middleware
 
 import (
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"runtime/debug"
 	"time"
 
+	"craigstjean.com/stsummarizer/internal/logging"
 	"github.com/gin-gonic/gin"
 )
 
+// RequestID gives every request an ID, the client's X-Request-ID when it sent a usable one. The ID is returned in the
+// X-Request-ID response header and carried by the request's context into the services and Ollama calls.
+func RequestID() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.GetHeader("X-Request-ID")
+		if !logging.ValidRequestID(id) {
+			id = logging.NewRequestID()
+		}
+
+		c.Header("X-Request-ID", id)
+		c.Request = c.Request.WithContext(logging.WithRequestID(c.Request.Context(), id))
+		c.Next()
+	}
+}
+
+// Recovery answers 500 when a handler panics and logs the panic with the request ID
+func Recovery() gin.HandlerFunc {
+	return gin.CustomRecoveryWithWriter(io.Discard, func(c *gin.Context, err any) {
+		slog.ErrorContext(c.Request.Context(), "panic", "error", fmt.Sprint(err), "stack", string(debug.Stack()))
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{
+			"error": "internal server error",
+		})
+	})
+}
+
+// RequestLogger logs every request once it is served, warnings for client errors and errors for server errors
 func RequestLogger() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		// Start timer
@@ -14,15 +46,23 @@ func RequestLogger() gin.HandlerFunc {
 		// Process request
 		c.Next()
 
-		// Calculate latency
-		latency := time.Since(start)
+		status := c.Writer.Status()
+		level := slog.LevelInfo
+		switch {
+		case status >= http.StatusInternalServerError:
+			level = slog.LevelError
+		case status >= http.StatusBadRequest:
+			level = slog.LevelWarn
+		}
 
-		// Log request details
-		gin.DefaultWriter.Write([]byte(
-			"Method: " + c.Request.Method + " | " +
-				"Path: " + c.Request.URL.Path + " | " +
-				"Status: " + string(rune(c.Writer.Status())) + " | " +
-				"Latency: " + latency.String() + "\n",
-		))
+		slog.LogAttrs(c.Request.Context(), level, "request",
+			slog.String("method", c.Request.Method),
+			slog.String("route", c.FullPath()),
+			slog.String("path", c.Request.URL.Path),
+			slog.Int("status", status),
+			slog.Duration("latency", time.Since(start)),
+			slog.String("client_ip", c.ClientIP()),
+			slog.Int("bytes", c.Writer.Size()),
+		)
 	}
 }
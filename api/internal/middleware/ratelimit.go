@@ -0,0 +1,98 @@
+package middleware
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"craigstjean.com/stsummarizer/internal/config"
+	"github.com/gin-gonic/gin"
+)
+
+// maxIdleBuckets is how many clients are tracked before the ones back to a full bucket are forgotten
+const maxIdleBuckets = 1024
+
+type bucket struct {
+	tokens  float64
+	updated time.Time
+}
+
+// RateLimiter limits how often each client may call the routes it guards, with a token bucket per client. Clients
+// are told apart by their credentials when they authenticated, by their address otherwise.
+type RateLimiter struct {
+	perSecond float64
+	burst     float64
+
+	mu      sync.Mutex
+	buckets map[string]*bucket
+}
+
+func NewRateLimiter(cfg config.RateLimitConfig) *RateLimiter {
+	return &RateLimiter{
+		perSecond: float64(cfg.RequestsPerMinute) / 60,
+		burst:     float64(cfg.Burst),
+		buckets:   make(map[string]*bucket),
+	}
+}
+
+// Limit refuses requests over the client's rate with 429 and a Retry-After header, it does nothing when the rate is 0
+func (l *RateLimiter) Limit() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if l.perSecond <= 0 {
+			c.Next()
+			return
+		}
+
+		client := "address " + c.ClientIP()
+		if principal := GetPrincipal(c); principal != nil {
+			client = "principal " + principal.Name
+		}
+
+		if wait := l.take(client, time.Now()); wait > 0 {
+			seconds := int(math.Ceil(wait.Seconds()))
+			c.Header("Retry-After", strconv.Itoa(seconds))
+			c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{
+				"error": fmt.Sprintf("too many summary requests, retry in %d seconds", seconds),
+			})
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// take spends one token of the client's bucket, or returns how long until one is available
+func (l *RateLimiter) take(client string, now time.Time) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	b, ok := l.buckets[client]
+	if !ok {
+		if len(l.buckets) >= maxIdleBuckets {
+			l.prune(now)
+		}
+		b = &bucket{tokens: l.burst, updated: now}
+		l.buckets[client] = b
+	}
+
+	b.tokens = min(l.burst, b.tokens+now.Sub(b.updated).Seconds()*l.perSecond)
+	b.updated = now
+	if b.tokens < 1 {
+		return time.Duration((1 - b.tokens) / l.perSecond * float64(time.Second))
+	}
+
+	b.tokens--
+	return 0
+}
+
+// prune forgets the clients whose bucket has filled up again, they would start from a full bucket anyway
+func (l *RateLimiter) prune(now time.Time) {
+	for client, b := range l.buckets {
+		if b.tokens+now.Sub(b.updated).Seconds()*l.perSecond >= l.burst {
+			delete(l.buckets, client)
+		}
+	}
+}
@@ -1,5 +1,10 @@
 package models
 
+import (
+	"encoding/json"
+	"time"
+)
+
 type Model struct {
 	Name    string `json:"name"`
 	Model   string `json:"model"`
@@ -12,10 +17,12 @@ type GroupChat struct {
 	Chats   []string `json:"chats"`
 }
 
+// ChatMetadata is the header line of a chat file. SillyTavern has written create_date as a string and as epoch
+// milliseconds, like send_date.
 type ChatMetadata struct {
 	UserName      string                 `json:"user_name"`
 	CharacterName string                 `json:"character_name"`
-	CreateDate    string                 `json:"create_date"`
+	CreateDate    json.RawMessage        `json:"create_date"`
 	ChatMetadata  map[string]interface{} `json:"chat_metadata"`
 }
 
@@ -23,4 +30,428 @@ type ChatMessage struct {
 	Name    string `json:"name"`
 	IsUser  bool   `json:"is_user"`
 	Message string `json:"mes"`
+	// Index is the message's position in the chat, counted without the header. It is not stored in the file.
+	Index int `json:"-"`
+	// IsSystem marks SillyTavern's own notes and messages the user hid from the prompt
+	IsSystem bool         `json:"is_system"`
+	Extra    MessageExtra `json:"extra"`
+	// SendDate is kept raw, SillyTavern has written it as a formatted string, an ISO timestamp and epoch milliseconds
+	SendDate json.RawMessage `json:"send_date,omitempty"`
+	// Swipes are the alternative responses, SwipeID is the index of the one shown in SillyTavern
+	SwipeID   *int        `json:"swipe_id,omitempty"`
+	Swipes    []string    `json:"swipes,omitempty"`
+	SwipeInfo []SwipeInfo `json:"swipe_info,omitempty"`
+}
+
+type MessageExtra struct {
+	// Type is set on SillyTavern's system messages, e.g. "narrator" for /sys or "comment" for /comment
+	Type string `json:"type,omitempty"`
+}
+
+type SwipeInfo struct {
+	SendDate json.RawMessage `json:"send_date,omitempty"`
+	Extra    struct {
+		API   string `json:"api,omitempty"`
+		Model string `json:"model,omitempty"`
+	} `json:"extra"`
+}
+
+type Character struct {
+	Name        string   `json:"name"`
+	DisplayName string   `json:"display_name"`
+	Avatar      string   `json:"avatar"`
+	Tags        []string `json:"tags"`
+	ChatCount   int      `json:"chat_count"`
+}
+
+// CharacterCard is the normalized content of a V1, V2 or V3 character card.
+type CharacterCard struct {
+	Spec                    string                 `json:"spec"`
+	SpecVersion             string                 `json:"spec_version"`
+	Name                    string                 `json:"name"`
+	Description             string                 `json:"description"`
+	Personality             string                 `json:"personality"`
+	Scenario                string                 `json:"scenario"`
+	FirstMessage            string                 `json:"first_mes"`
+	MessageExamples         string                 `json:"mes_example"`
+	CreatorNotes            string                 `json:"creator_notes"`
+	SystemPrompt            string                 `json:"system_prompt"`
+	PostHistoryInstructions string                 `json:"post_history_instructions"`
+	AlternateGreetings      []string               `json:"alternate_greetings"`
+	Tags                    []string               `json:"tags"`
+	Creator                 string                 `json:"creator"`
+	CharacterVersion        string                 `json:"character_version"`
+	CharacterBook           *CharacterBook         `json:"character_book,omitempty"`
+	Extensions              map[string]interface{} `json:"extensions,omitempty"`
+}
+
+type CharacterBook struct {
+	Name        string               `json:"name,omitempty"`
+	Description string               `json:"description,omitempty"`
+	Entries     []CharacterBookEntry `json:"entries"`
+}
+
+type CharacterBookEntry struct {
+	Keys           []string `json:"keys"`
+	SecondaryKeys  []string `json:"secondary_keys,omitempty"`
+	Comment        string   `json:"comment,omitempty"`
+	Content        string   `json:"content"`
+	Constant       bool     `json:"constant"`
+	Selective      bool     `json:"selective"`
+	Enabled        bool     `json:"enabled"`
+	InsertionOrder int      `json:"insertion_order"`
+}
+
+type Persona struct {
+	Name        string `json:"name"`
+	Avatar      string `json:"avatar"`
+	Description string `json:"description"`
+}
+
+// WorldInfoEntry is a lorebook entry as exchanged with clients, UID is set for entries that exist in a lorebook
+type WorldInfoEntry struct {
+	UID           *int     `json:"uid,omitempty"`
+	Keys          []string `json:"keys"`
+	SecondaryKeys []string `json:"secondary_keys,omitempty"`
+	Comment       string   `json:"comment"`
+	Content       string   `json:"content"`
+	Disabled      bool     `json:"disabled,omitempty"`
+}
+
+type Lorebook struct {
+	Name    string           `json:"name"`
+	Entries []WorldInfoEntry `json:"entries"`
+}
+
+type WorldInfoChange struct {
+	Existing WorldInfoEntry `json:"existing"`
+	Proposed WorldInfoEntry `json:"proposed"`
+}
+
+// WorldInfoDiff is the result of comparing extracted entries against an existing lorebook
+type WorldInfoDiff struct {
+	World      string            `json:"world,omitempty"`
+	Added      []WorldInfoEntry  `json:"added"`
+	Updated    []WorldInfoChange `json:"updated"`
+	Duplicates []WorldInfoChange `json:"duplicates"`
+}
+
+type ChatEventType string
+
+const (
+	ChatCreated      ChatEventType = "chat_created"
+	ChatUpdated      ChatEventType = "chat_updated"
+	ChatDeleted      ChatEventType = "chat_deleted"
+	GroupChatCreated ChatEventType = "group_chat_created"
+	GroupChatUpdated ChatEventType = "group_chat_updated"
+	GroupChatDeleted ChatEventType = "group_chat_deleted"
+	BackupCreated    ChatEventType = "backup_created"
+)
+
+// ChatEvent describes a change to a chat, group chat or backup file. NewMessages is negative when messages were removed.
+type ChatEvent struct {
+	Type         ChatEventType `json:"type"`
+	User         string        `json:"user"`
+	Character    string        `json:"character,omitempty"`
+	Chat         string        `json:"chat,omitempty"`
+	Backup       string        `json:"backup,omitempty"`
+	MessageCount int           `json:"message_count"`
+	NewMessages  int           `json:"new_messages"`
+	Time         time.Time     `json:"time"`
+}
+
+// ChatRef identifies a character chat or, when Group is set, a group chat
+type ChatRef struct {
+	User      string `json:"user"`
+	Character string `json:"character,omitempty"`
+	Chat      string `json:"chat"`
+	Group     bool   `json:"group,omitempty"`
+}
+
+type ChatFileInfo struct {
+	ModTime time.Time `json:"mod_time"`
+	Size    int64     `json:"size"`
+}
+
+// CachedSummary is a stored summary together with the state of the chat file it was generated from
+type CachedSummary struct {
+	ChatRef
+	Options      string       `json:"options"`
+	Summaries    []string     `json:"summaries"`
+	MessageCount int          `json:"message_count"`
+	TokenCount   int          `json:"token_count"`
+	Source       ChatFileInfo `json:"source"`
+	// Skipped lists the lines left out by a lenient read
+	Skipped   []ChatLineError `json:"skipped,omitempty"`
+	CreatedAt time.Time       `json:"created_at"`
+}
+
+// SummaryRule regenerates a chat's summary automatically after EveryMessages new messages or EveryTokens new tokens
+type SummaryRule struct {
+	ID string `json:"id"`
+	ChatRef
+	Enabled       bool   `json:"enabled"`
+	EveryMessages int    `json:"every_messages"`
+	EveryTokens   int    `json:"every_tokens"`
+	Model         string `json:"model"`
+	MaxTokens     int    `json:"max_tokens"`
+	SummaryWords  int    `json:"summary_words"`
+	IncludeCards  bool   `json:"include_cards"`
+	WriteMetadata bool   `json:"write_metadata"`
+	Visibility    string `json:"visibility,omitempty"`
+
+	LastMessageCount int       `json:"last_message_count"`
+	LastTokenCount   int       `json:"last_token_count"`
+	LastRun          time.Time `json:"last_run"`
+	LastError        string    `json:"last_error,omitempty"`
+}
+
+// ChatQuery selects a range of messages by index, the header line is not a message.
+// Tail takes precedence over From/To, which take precedence over Offset/Limit. A zero Limit means no limit.
+type ChatQuery struct {
+	Offset int
+	Limit  int
+	Tail   int
+	From   *int
+	To     *int
+	// Lenient skips lines that cannot be parsed, they are reported in ChatPage.Skipped
+	Lenient bool
+}
+
+// ChatPage is a range of messages, Start is the index of the first message and Total the number of messages in the chat
+type ChatPage struct {
+	Messages []ChatMessage
+	Start    int
+	Total    int
+	Skipped  []ChatLineError
+}
+
+// ChatLineError is a line of a chat file that could not be parsed, Line starts at 1
+type ChatLineError struct {
+	Line  int    `json:"line"`
+	Error string `json:"error"`
+}
+
+// Kinds of ChatProblem
+const (
+	ProblemInvalidJSON      = "invalid_json"
+	ProblemMissingHeader    = "missing_header"
+	ProblemInvalidField     = "invalid_field"
+	ProblemDuplicateMessage = "duplicate_message"
+)
+
+// ChatProblem is an issue found in a chat file, Line starts at 1
+type ChatProblem struct {
+	Line    int    `json:"line"`
+	Kind    string `json:"kind"`
+	Field   string `json:"field,omitempty"`
+	Message string `json:"message"`
+}
+
+type ChatValidation struct {
+	Valid    bool          `json:"valid"`
+	Lines    int           `json:"lines"`
+	Messages int           `json:"messages"`
+	Problems []ChatProblem `json:"problems"`
+}
+
+// ChatRepair is the result of repairing a chat, Chat is the name of the new branch holding the fixed copy
+type ChatRepair struct {
+	Chat     string        `json:"chat"`
+	Messages int           `json:"messages"`
+	Fixed    []ChatProblem `json:"fixed"`
+}
+
+// Swipe is one alternative response of a message, Words counts its words and Similarity is the share of words it has
+// in common with the active swipe
+type Swipe struct {
+	Index      int     `json:"index"`
+	Text       string  `json:"text"`
+	Active     bool    `json:"active"`
+	Model      string  `json:"model,omitempty"`
+	SendDate   string  `json:"send_date,omitempty"`
+	Words      int     `json:"words"`
+	Similarity float64 `json:"similarity"`
+}
+
+type SwipeComparison struct {
+	Index  int     `json:"index"`
+	Name   string  `json:"name"`
+	Active int     `json:"active"`
+	Swipes []Swipe `json:"swipes"`
+}
+
+// Placements of a SillyTavern regex script
+const (
+	RegexPlacementUserInput = 1
+	RegexPlacementAIOutput  = 2
+)
+
+// RegexScript is a script of SillyTavern's Regex extension, from settings.json or a character card.
+// FindRegex is a JavaScript regular expression literal such as "/pattern/gi".
+type RegexScript struct {
+	ID            string   `json:"id"`
+	ScriptName    string   `json:"scriptName"`
+	FindRegex     string   `json:"findRegex"`
+	ReplaceString string   `json:"replaceString"`
+	TrimStrings   []string `json:"trimStrings"`
+	Placement     []int    `json:"placement"`
+	Disabled      bool     `json:"disabled"`
+	MarkdownOnly  bool     `json:"markdownOnly"`
+	PromptOnly    bool     `json:"promptOnly"`
+	// SubstituteRegex substitutes macros in FindRegex: 0 (or false) not at all, 1 (or true) as is, 2 escaped
+	SubstituteRegex json.RawMessage `json:"substituteRegex,omitempty"`
+	MinDepth        *int            `json:"minDepth"`
+	MaxDepth        *int            `json:"maxDepth"`
+}
+
+// MessageTokens is the size of one message as it is sent to the summarizer
+type MessageTokens struct {
+	Index  int    `json:"index"`
+	Name   string `json:"name"`
+	Tokens int    `json:"tokens"`
+}
+
+// SummaryChunk is a group of messages summarized in one call, from Start to End inclusive. A message too large for
+// one chunk is split across several.
+type SummaryChunk struct {
+	Start        int `json:"start"`
+	End          int `json:"end"`
+	Messages     int `json:"messages"`
+	Tokens       int `json:"tokens"`
+	PromptTokens int `json:"prompt_tokens"`
+}
+
+// SummaryPlan describes how a chat would be summarized without calling the model
+type SummaryPlan struct {
+	MessageCount  int             `json:"message_count"`
+	TotalTokens   int             `json:"total_tokens"`
+	MaxTokens     int             `json:"max_tokens"`
+	ChunkTokens   int             `json:"chunk_tokens"`
+	ContextTokens int             `json:"context_tokens"`
+	PriorTokens   int             `json:"prior_tokens"`
+	Messages      []MessageTokens `json:"messages"`
+	Chunks        []SummaryChunk  `json:"chunks"`
+	// LLMCalls is one call per chunk plus the consolidation, or a single call when the chat fits in one chunk
+	LLMCalls int `json:"llm_calls"`
+	// InputTokens estimates the prompt tokens of every call, the consolidation assumes partial summaries of
+	// summary_words words
+	InputTokens int `json:"input_tokens"`
+}
+
+// Batch job states, and the states of each chat in a batch
+const (
+	BatchRunning   = "running"
+	BatchDone      = "done"
+	BatchCancelled = "cancelled"
+
+	BatchChatPending    = "pending"
+	BatchChatRunning    = "running"
+	BatchChatSummarized = "summarized"
+	BatchChatCurrent    = "current"
+	BatchChatFailed     = "failed"
+	BatchChatCancelled  = "cancelled"
+)
+
+// BatchJob summarizes every chat of a character, every group chat, or everything of a user. Chats is the index of
+// the job, linking each chat to its summary.
+type BatchJob struct {
+	ID          string      `json:"id"`
+	User        string      `json:"user"`
+	Character   string      `json:"character,omitempty"`
+	Groups      bool        `json:"groups,omitempty"`
+	Status      string      `json:"status"`
+	Concurrency int         `json:"concurrency"`
+	Created     time.Time   `json:"created"`
+	Finished    *time.Time  `json:"finished,omitempty"`
+	Total       int         `json:"total"`
+	Summarized  int         `json:"summarized"`
+	Current     int         `json:"current"`
+	Failed      int         `json:"failed"`
+	Chats       []BatchChat `json:"chats,omitempty"`
+}
+
+// BatchChat is the outcome of one chat in a batch, Summary is its final summary
+type BatchChat struct {
+	ChatRef
+	Status       string `json:"status"`
+	Link         string `json:"link"`
+	Summary      string `json:"summary,omitempty"`
+	MessageCount int    `json:"message_count,omitempty"`
+	TokenCount   int    `json:"token_count,omitempty"`
+	Error        string `json:"error,omitempty"`
+}
+
+// StoryChat is one chat of a character's story. A branch repeats the first ForkIndex messages of BranchOf.
+type StoryChat struct {
+	Chat         string    `json:"chat"`
+	Created      time.Time `json:"created"`
+	CreatedFrom  string    `json:"created_from"`
+	MessageCount int       `json:"message_count"`
+	BranchOf     string    `json:"branch_of,omitempty"`
+	ForkIndex    int       `json:"fork_index,omitempty"`
+	Summary      string    `json:"summary,omitempty"`
+	Error        string    `json:"error,omitempty"`
+}
+
+// Story merges the summaries of every chat of a character, oldest first, into one story so far
+type Story struct {
+	User      string      `json:"user"`
+	Character string      `json:"character"`
+	Story     string      `json:"story"`
+	Chats     []StoryChat `json:"chats"`
+}
+
+// Kinds of BranchNode
+const (
+	BranchChat   = "chat"
+	BranchBackup = "backup"
+)
+
+// BranchNode is a chat or backup of a character's branch tree. It repeats the first ForkIndex messages of Parent, so
+// ForkIndex is also the index of its first message that differs, and Divergence messages follow from there.
+type BranchNode struct {
+	ID           string     `json:"id"`
+	Kind         string     `json:"kind"`
+	Name         string     `json:"name"`
+	Parent       string     `json:"parent,omitempty"`
+	ForkIndex    int        `json:"fork_index"`
+	Divergence   int        `json:"divergence"`
+	MessageCount int        `json:"message_count"`
+	Created      time.Time  `json:"created"`
+	CreatedFrom  string     `json:"created_from"`
+	Modified     *time.Time `json:"modified,omitempty"`
+	LastMessage  *time.Time `json:"last_message,omitempty"`
+	// SkippedLines counts the lines left out by a lenient read
+	SkippedLines int    `json:"skipped_lines,omitempty"`
+	Error        string `json:"error,omitempty"`
+}
+
+// BranchTree holds the chats and backups of a character, oldest first, each linked to the node it branched from
+type BranchTree struct {
+	User      string       `json:"user"`
+	Character string       `json:"character"`
+	Nodes     []BranchNode `json:"nodes"`
+}
+
+// BranchSide is one side of a BranchDiff, the messages of the node from Start on
+type BranchSide struct {
+	ID       string        `json:"id"`
+	Start    int           `json:"start"`
+	Total    int           `json:"total"`
+	Messages []ChatMessage `json:"messages"`
+}
+
+// BranchDiff compares two nodes of a branch tree, which share their first Shared messages
+type BranchDiff struct {
+	Shared int        `json:"shared"`
+	A      BranchSide `json:"a"`
+	B      BranchSide `json:"b"`
+}
+
+// UserAccount is a SillyTavern user account, without its password
+type UserAccount struct {
+	Handle string `json:"handle"`
+	Name   string `json:"name"`
+	Admin  bool   `json:"admin"`
 }
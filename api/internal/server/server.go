@@ -0,0 +1,276 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"craigstjean.com/stsummarizer/internal/config"
+	"craigstjean.com/stsummarizer/internal/handlers"
+	"craigstjean.com/stsummarizer/internal/logging"
+	"craigstjean.com/stsummarizer/internal/metrics"
+	"craigstjean.com/stsummarizer/internal/middleware"
+	"craigstjean.com/stsummarizer/internal/services"
+	"craigstjean.com/stsummarizer/internal/services/batch"
+	"craigstjean.com/stsummarizer/internal/services/rules"
+	"craigstjean.com/stsummarizer/internal/services/sillytavern"
+	"craigstjean.com/stsummarizer/internal/services/watcher"
+	"craigstjean.com/stsummarizer/internal/tracing"
+	"github.com/gin-gonic/gin"
+)
+
+// shutdownTimeout is how long requests in progress, and then batch summaries, may finish after SIGINT or SIGTERM.
+// Summaries that take longer are cut off, event streams end right away.
+const shutdownTimeout = 10 * time.Second
+
+// Run serves the API with the loaded configuration until the server fails or is stopped by SIGINT or SIGTERM, then
+// stops the rules, batches and watcher and flushes the buffered spans
+func Run(cfg *config.Config) error {
+	if err := logging.Setup(cfg.Log.Level, cfg.Log.Format); err != nil {
+		return err
+	}
+	shutdownTracing, err := tracing.Setup(cfg.Tracing)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		if err := shutdownTracing(ctx); err != nil {
+			slog.Warn("failed to flush traces", "error", err)
+		}
+	}()
+
+	// Gin's own debug output is not structured, it is only shown when debugging unless GIN_MODE asks for it
+	if os.Getenv(gin.EnvGinMode) == "" && cfg.Log.Level != "debug" {
+		gin.SetMode(gin.ReleaseMode)
+	}
+
+	// Initialize router, requests are logged by RequestLogger instead of gin's logger
+	r := gin.New()
+	if err := r.SetTrustedProxies(cfg.HTTP.TrustedProxies); err != nil {
+		return err
+	}
+
+	// Add middleware
+	r.Use(middleware.RequestID())
+	r.Use(middleware.RequestLogger())
+	r.Use(middleware.Metrics())
+	r.Use(middleware.Recovery())
+	r.Use(middleware.SecurityHeaders())
+	r.Use(middleware.CORS(cfg.HTTP.CORS))
+	r.Use(middleware.BodyLimit(int64(cfg.HTTP.MaxBodyBytes)))
+
+	// Initialize API routes
+	stopServices, err := initializeRoutes(r, cfg)
+	if err != nil {
+		return err
+	}
+	// Deferred after the trace flush, so it runs before it and the spans of the last summaries are flushed too
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		stopServices(ctx)
+	}()
+
+	// serverCtx is cancelled when shutting down, ending the event streams
+	serverCtx, cancelServer := context.WithCancel(context.Background())
+	defer cancelServer()
+
+	// Start server
+	server := &http.Server{
+		Addr:        cfg.Listen,
+		Handler:     r,
+		ReadTimeout: time.Duration(cfg.Timeouts.Read),
+		// The write timeout also bounds summaries and the event stream, so it is off unless configured
+		WriteTimeout: time.Duration(cfg.Timeouts.Write),
+		BaseContext: func(net.Listener) context.Context {
+			return handlers.WithServerContext(context.Background(), serverCtx)
+		},
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	served := make(chan error, 1)
+	go func() {
+		slog.Info("listening", "address", cfg.Listen)
+		served <- server.ListenAndServe()
+	}()
+
+	select {
+	case err := <-served:
+		return err
+	case <-ctx.Done():
+	}
+
+	slog.Info("shutting down")
+	cancelServer()
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		slog.Warn("requests did not finish before shutdown", "error", err)
+		server.Close()
+	}
+
+	return nil
+}
+
+// initializeRoutes creates the services and registers the routes using them. It returns the function stopping the
+// services running in the background.
+func initializeRoutes(r *gin.Engine, cfg *config.Config) (func(context.Context), error) {
+	// Initialize services
+	ollamaService := services.NewOllamaService()
+	stService := sillytavern.NewService()
+
+	var cleaner *services.Cleaner
+	if cfg.Features.Cleaning {
+		var err error
+		if cleaner, err = services.NewCleaner(); err != nil {
+			return nil, fmt.Errorf("failed to load cleaning options: %w", err)
+		}
+	}
+	summarizer := services.NewSummarizer(stService, ollamaService, services.NewSummaryCache(), cleaner)
+
+	// Live updates are optional, the API works without them
+	var chatWatcher *watcher.Watcher
+	if cfg.Features.Watch {
+		var err error
+		if chatWatcher, err = watcher.NewWatcher(); err != nil {
+			slog.Warn("file watching disabled", "error", err)
+		}
+	}
+
+	// Automatic summarization rules
+	ruleStore, err := rules.NewStore()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load summary rules: %w", err)
+	}
+	scheduler := rules.NewScheduler(ruleStore, summarizer, stService, chatWatcher)
+	if cfg.Features.Rules {
+		scheduler.Start()
+	}
+
+	// Batch summarization jobs
+	batchRunner := batch.NewRunner(summarizer, stService, cfg.Summary.Concurrency)
+
+	// Initialize handlers
+	modelsHandler := handlers.NewModelsHandler(ollamaService)
+	charactersHandler := handlers.NewCharactersHandler(stService)
+	chatsHandler := handlers.NewChatsHandler(stService, summarizer)
+	groupsHandler := handlers.NewGroupsHandler(stService, summarizer)
+	worldsHandler := handlers.NewWorldsHandler(stService, ollamaService, cleaner)
+	rulesHandler := handlers.NewRulesHandler(scheduler, summarizer)
+	eventsHandler := handlers.NewEventsHandler(chatWatcher)
+	configHandler := handlers.NewConfigHandler(cfg)
+	batchesHandler := handlers.NewBatchesHandler(batchRunner)
+	healthHandler := handlers.NewHealthHandler(ollamaService)
+
+	// Probes and metrics are outside the API so orchestrators and scrapers need no credentials
+	r.GET("/healthz", healthHandler.GetHealth)
+	r.GET("/readyz", healthHandler.GetReady)
+	r.GET("/metrics", gin.WrapH(metrics.Handler()))
+
+	// Every API route is authenticated when auth is configured. Routes that list or look up data of any user are
+	// unscoped and filter it themselves, routes that are not about a user's data at all need access to every user.
+	authenticator := middleware.NewAuthenticator(cfg.Auth, stService)
+	allUsers := authenticator.RequireAllUsers()
+
+	// Routes that run the model are rate limited per client
+	limited := middleware.NewRateLimiter(cfg.HTTP.RateLimit).Limit()
+
+	// API group, traced so probes and scrapes do not fill the traces
+	api := r.Group("/api", middleware.Tracing(), authenticator.Auth(
+		"GET /api/models",
+		"GET /api/users",
+		"GET /api/batches",
+		"GET /api/batches/:id",
+		"GET /api/batches/:id/index",
+		"DELETE /api/batches/:id",
+	))
+	{
+		// Configuration routes
+		api.GET("/config", allUsers, configHandler.GetConfig)
+
+		// Models routes
+		api.GET("/models", modelsHandler.GetModels)
+
+		// Characters routes
+		api.GET("/users", charactersHandler.GetUsers)
+		api.GET("/characters", charactersHandler.GetCharacters)
+		api.GET("/characters/:character/card", charactersHandler.GetCharacterCard)
+		api.GET("/characters/:character/avatar", charactersHandler.GetCharacterAvatar)
+		api.GET("/characters/:character/backups", charactersHandler.GetCharacterBackups)
+		api.GET("/characters/:character/backups/:backup", charactersHandler.GetCharacterBackup)
+		api.POST("/characters/:character/backups/:backup/restore", charactersHandler.RestoreCharacterBackup)
+		api.GET("/characters/:character/backups/:backup/validate", charactersHandler.ValidateCharacterBackup)
+		api.POST("/characters/:character/backups/:backup/repair", charactersHandler.RepairCharacterBackup)
+		api.GET("/characters/:character/branches", charactersHandler.GetCharacterBranches)
+		api.GET("/characters/:character/branches/diff", charactersHandler.GetCharacterBranchDiff)
+		api.GET("/characters/:character/story", limited, chatsHandler.GetCharacterStory)
+		api.POST("/characters/:character/story", limited, chatsHandler.GetCharacterStory)
+
+		// Individual chats routes
+		api.GET("/chats/:character", chatsHandler.GetCharacterChats)
+		api.GET("/chats/:character/:chat", chatsHandler.GetChat)
+		api.GET("/chats/:character/:chat/summary", limited, chatsHandler.GetChatSummary)
+		api.POST("/chats/:character/:chat/summary", limited, chatsHandler.GetChatSummary)
+		api.GET("/chats/:character/:chat/tokens", chatsHandler.GetChatTokens)
+		api.POST("/chats/:character/:chat/worldinfo", limited, worldsHandler.ProposeChatWorldInfo)
+		api.GET("/chats/:character/:chat/swipes/:index", chatsHandler.GetChatSwipes)
+		api.GET("/chats/:character/:chat/validate", chatsHandler.ValidateChat)
+		api.POST("/chats/:character/:chat/repair", chatsHandler.RepairChat)
+
+		// Group chats routes
+		api.GET("/groupChats", groupsHandler.GetGroupChats)
+		api.GET("/groupChats/:chat", groupsHandler.GetGroupChat)
+		api.GET("/groupChats/:chat/summary", limited, groupsHandler.GetGroupChatSummary)
+		api.POST("/groupChats/:chat/summary", limited, groupsHandler.GetGroupChatSummary)
+		api.GET("/groupChats/:chat/tokens", groupsHandler.GetGroupChatTokens)
+		api.POST("/groupChats/:chat/worldinfo", limited, worldsHandler.ProposeGroupChatWorldInfo)
+		api.GET("/groupChats/:chat/swipes/:index", groupsHandler.GetGroupChatSwipes)
+		api.GET("/groupChats/:chat/validate", groupsHandler.ValidateGroupChat)
+		api.POST("/groupChats/:chat/repair", groupsHandler.RepairGroupChat)
+
+		// World Info routes
+		api.GET("/worlds", worldsHandler.GetWorlds)
+		api.GET("/worlds/:world", worldsHandler.GetWorld)
+		api.POST("/worlds/:world/entries", worldsHandler.SaveWorldEntries)
+
+		// Automatic summarization rules routes
+		api.GET("/rules", allUsers, rulesHandler.GetRules)
+		api.POST("/rules", allUsers, rulesHandler.CreateRule)
+		api.GET("/rules/:id", allUsers, rulesHandler.GetRule)
+		api.PUT("/rules/:id", allUsers, rulesHandler.UpdateRule)
+		api.DELETE("/rules/:id", allUsers, rulesHandler.DeleteRule)
+		api.POST("/rules/:id/run", allUsers, limited, rulesHandler.RunRule)
+		api.GET("/rules/:id/summary", allUsers, rulesHandler.GetRuleSummary)
+
+		// Batch summarization routes
+		api.GET("/batches", batchesHandler.GetBatches)
+		api.POST("/batches", limited, batchesHandler.CreateBatch)
+		api.GET("/batches/:id", batchesHandler.GetBatch)
+		api.GET("/batches/:id/index", batchesHandler.GetBatchIndex)
+		api.DELETE("/batches/:id", batchesHandler.CancelBatch)
+
+		// Live update routes
+		api.GET("/events", eventsHandler.GetEvents)
+	}
+
+	stop := func(ctx context.Context) {
+		scheduler.Stop()
+		if err := batchRunner.Stop(ctx); err != nil {
+			slog.Warn("batches did not finish before shutdown", "error", err)
+		}
+		if chatWatcher != nil {
+			chatWatcher.Close()
+		}
+	}
+
+	return stop, nil
+}
@@ -0,0 +1,52 @@
+package batch
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"craigstjean.com/stsummarizer/internal/models"
+)
+
+// Index renders the job as a Markdown document with a section per chat, linking to its summary
+func Index(job models.BatchJob) string {
+	var sb strings.Builder
+
+	title := "Summaries of " + job.User
+	if job.Character != "" {
+		title = fmt.Sprintf("Summaries of %s's chats with %s", job.User, job.Character)
+	} else if job.Groups {
+		title = fmt.Sprintf("Summaries of %s's group chats", job.User)
+	}
+	fmt.Fprintf(&sb, "# %s\n\n", title)
+
+	status := "Running"
+	if job.Finished != nil {
+		status = "Finished " + job.Finished.Format(time.RFC1123)
+		if job.Status == models.BatchCancelled {
+			status = "Cancelled " + job.Finished.Format(time.RFC1123)
+		}
+	}
+	fmt.Fprintf(&sb, "%s: %d chats, %d summarized, %d already current, %d failed.\n\n",
+		status, job.Total, job.Summarized, job.Current, job.Failed)
+
+	for _, chat := range job.Chats {
+		name := chat.Chat
+		if !chat.Group {
+			name = chat.Character + " / " + chat.Chat
+		}
+		fmt.Fprintf(&sb, "## %s\n\n", name)
+
+		switch {
+		case chat.Error != "":
+			fmt.Fprintf(&sb, "Failed: %s\n\n", chat.Error)
+		case chat.Summary != "":
+			fmt.Fprintf(&sb, "%s\n\n", chat.Summary)
+			fmt.Fprintf(&sb, "[Summary](%s) of %d messages, %d tokens.\n\n", chat.Link, chat.MessageCount, chat.TokenCount)
+		default:
+			fmt.Fprintf(&sb, "Not summarized (%s).\n\n", chat.Status)
+		}
+	}
+
+	return sb.String()
+}
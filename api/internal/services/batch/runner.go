@@ -0,0 +1,344 @@
+package batch
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"craigstjean.com/stsummarizer/internal/config"
+	"craigstjean.com/stsummarizer/internal/logging"
+	"craigstjean.com/stsummarizer/internal/models"
+	"craigstjean.com/stsummarizer/internal/services"
+)
+
+// keepFinished is how many finished jobs are remembered, jobs only live in memory
+const keepFinished = 20
+
+// Request selects the chats of a batch: every chat of Character, every group chat with Groups, or everything of the
+// user when neither is set
+type Request struct {
+	User        string
+	Character   string
+	Groups      bool
+	Concurrency int
+	// Force summarizes chats whose cached summary is still current
+	Force bool
+	// Summary holds the summary options, its ChatRef is set for each chat
+	Summary services.SummaryRequest
+	// Link returns where the summary of a chat can be fetched
+	Link func(ref models.ChatRef) string
+}
+
+type job struct {
+	models.BatchJob
+	cancel context.CancelFunc
+}
+
+// Runner runs batch jobs in the background. Summaries of every job share one limit, so batches never send Ollama
+// more than the configured number of requests at once.
+type Runner struct {
+	summarizer *services.Summarizer
+	stService  services.SillyTavernService
+	slots      chan struct{}
+
+	mu   sync.Mutex
+	jobs map[string]*job
+	// running counts the jobs that have not finished, for Stop
+	running sync.WaitGroup
+}
+
+func NewRunner(summarizer *services.Summarizer, stService services.SillyTavernService, concurrency int) *Runner {
+	return &Runner{
+		summarizer: summarizer,
+		stService:  stService,
+		slots:      make(chan struct{}, max(concurrency, 1)),
+		jobs:       make(map[string]*job),
+	}
+}
+
+// Start lists the chats of the batch and summarizes them in the background
+func (r *Runner) Start(req Request) (models.BatchJob, error) {
+	if req.User == "" {
+		req.User = config.STDefaultUser
+	}
+	if req.Concurrency <= 0 || req.Concurrency > cap(r.slots) {
+		req.Concurrency = cap(r.slots)
+	}
+
+	refs, err := r.chats(req)
+	if err != nil {
+		return models.BatchJob{}, err
+	}
+
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return models.BatchJob{}, fmt.Errorf("failed to generate batch id: %w", err)
+	}
+
+	// The batch ID stands in for a request ID in the logs of its summaries
+	ctx, cancel := context.WithCancel(logging.WithRequestID(context.Background(), "batch-"+hex.EncodeToString(id)))
+	j := &job{
+		BatchJob: models.BatchJob{
+			ID:          hex.EncodeToString(id),
+			User:        req.User,
+			Character:   req.Character,
+			Groups:      req.Groups,
+			Status:      models.BatchRunning,
+			Concurrency: req.Concurrency,
+			Created:     time.Now(),
+			Total:       len(refs),
+			Chats:       make([]models.BatchChat, len(refs)),
+		},
+		cancel: cancel,
+	}
+	for i, ref := range refs {
+		j.Chats[i] = models.BatchChat{
+			ChatRef: ref,
+			Status:  models.BatchChatPending,
+		}
+		if req.Link != nil {
+			j.Chats[i].Link = req.Link(ref)
+		}
+	}
+
+	r.mu.Lock()
+	r.jobs[j.ID] = j
+	r.prune()
+	snapshot := j.snapshot()
+	r.mu.Unlock()
+
+	r.running.Add(1)
+	go func() {
+		defer r.running.Done()
+		r.run(ctx, j, req)
+	}()
+
+	return snapshot, nil
+}
+
+func (r *Runner) Get(id string) (models.BatchJob, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	j, ok := r.jobs[id]
+	if !ok {
+		return models.BatchJob{}, fmt.Errorf("batch does not exist: %s", id)
+	}
+
+	return j.snapshot(), nil
+}
+
+// List returns every remembered job, the newest first, without their chats
+func (r *Runner) List() []models.BatchJob {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	jobs := make([]models.BatchJob, 0, len(r.jobs))
+	for _, j := range r.jobs {
+		summary := j.BatchJob
+		summary.Chats = nil
+		jobs = append(jobs, summary)
+	}
+
+	sort.Slice(jobs, func(i, k int) bool {
+		return jobs[i].Created.After(jobs[k].Created)
+	})
+
+	return jobs
+}
+
+// Cancel stops a running job once the summaries in progress finish
+func (r *Runner) Cancel(id string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	j, ok := r.jobs[id]
+	if !ok {
+		return fmt.Errorf("batch does not exist: %s", id)
+	}
+
+	j.cancel()
+	return nil
+}
+
+// Stop cancels every running job and waits for the summaries in progress to finish, or for ctx to be done
+func (r *Runner) Stop(ctx context.Context) error {
+	r.mu.Lock()
+	for _, j := range r.jobs {
+		j.cancel()
+	}
+	r.mu.Unlock()
+
+	stopped := make(chan struct{})
+	go func() {
+		r.running.Wait()
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("batch summaries still running: %w", ctx.Err())
+	}
+}
+
+// chats lists the chats the request selects
+func (r *Runner) chats(req Request) ([]models.ChatRef, error) {
+	var characters []string
+	switch {
+	case req.Character != "":
+		characters = []string{req.Character}
+	case !req.Groups:
+		all, err := r.stService.GetCharacters(req.User)
+		if err != nil {
+			return nil, err
+		}
+		for _, character := range all {
+			characters = append(characters, character.Name)
+		}
+	}
+
+	var refs []models.ChatRef
+	for _, character := range characters {
+		chats, err := r.stService.GetCharacterChats(req.User, character)
+		if err != nil {
+			return nil, err
+		}
+		sort.Strings(chats)
+		for _, chat := range chats {
+			refs = append(refs, models.ChatRef{User: req.User, Character: character, Chat: chat})
+		}
+	}
+
+	if req.Groups || req.Character == "" {
+		groups, err := r.stService.GetGroupChats(req.User)
+		if err != nil {
+			// A user without groups has no groups directory, which only matters when group chats were asked for
+			if req.Groups || !strings.Contains(err.Error(), "does not exist") {
+				return nil, err
+			}
+		}
+		for _, group := range groups {
+			for _, chat := range group.Chats {
+				refs = append(refs, models.ChatRef{User: req.User, Chat: chat, Group: true})
+			}
+		}
+	}
+
+	return refs, nil
+}
+
+func (r *Runner) run(ctx context.Context, j *job, req Request) {
+	next := make(chan int)
+	var wg sync.WaitGroup
+
+	for range j.Concurrency {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				r.summarize(ctx, j, req, i)
+			}
+		}()
+	}
+
+	for i := range j.Chats {
+		next <- i
+	}
+	close(next)
+	wg.Wait()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	finished := time.Now()
+	j.Finished = &finished
+	j.Status = models.BatchDone
+	if ctx.Err() != nil {
+		j.Status = models.BatchCancelled
+	}
+	j.cancel()
+}
+
+// summarize runs the summary of chat i, once a slot is free
+func (r *Runner) summarize(ctx context.Context, j *job, req Request, i int) {
+	select {
+	case r.slots <- struct{}{}:
+		defer func() { <-r.slots }()
+	case <-ctx.Done():
+	}
+	if ctx.Err() != nil {
+		r.update(j, i, func(chat *models.BatchChat) {
+			chat.Status = models.BatchChatCancelled
+		})
+		return
+	}
+
+	r.update(j, i, func(chat *models.BatchChat) {
+		chat.Status = models.BatchChatRunning
+	})
+
+	summaryReq := req.Summary
+	summaryReq.ChatRef = j.Chats[i].ChatRef
+	summaryReq.UseCache = !req.Force
+
+	// Cancelling the batch lets the summaries already running finish
+	summary, fromCache, err := r.summarizer.Summarize(context.WithoutCancel(ctx), summaryReq)
+	r.update(j, i, func(chat *models.BatchChat) {
+		switch {
+		case err != nil:
+			chat.Status = models.BatchChatFailed
+			chat.Error = err.Error()
+			j.Failed++
+		case fromCache:
+			chat.Status = models.BatchChatCurrent
+			j.Current++
+		default:
+			chat.Status = models.BatchChatSummarized
+			j.Summarized++
+		}
+		if err == nil && len(summary.Summaries) > 0 {
+			chat.Summary = summary.Summaries[len(summary.Summaries)-1]
+			chat.MessageCount = summary.MessageCount
+			chat.TokenCount = summary.TokenCount
+		}
+	})
+}
+
+func (r *Runner) update(j *job, i int, update func(chat *models.BatchChat)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	update(&j.Chats[i])
+}
+
+// prune forgets the oldest finished jobs past keepFinished, the caller must hold the lock
+func (r *Runner) prune() {
+	var finished []*job
+	for _, j := range r.jobs {
+		if j.Finished != nil {
+			finished = append(finished, j)
+		}
+	}
+	if len(finished) <= keepFinished {
+		return
+	}
+
+	sort.Slice(finished, func(i, k int) bool {
+		return finished[i].Finished.Before(*finished[k].Finished)
+	})
+	for _, j := range finished[:len(finished)-keepFinished] {
+		delete(r.jobs, j.ID)
+	}
+}
+
+// snapshot copies the job so it can be read without the lock, the caller must hold the lock
+func (j *job) snapshot() models.BatchJob {
+	snapshot := j.BatchJob
+	snapshot.Chats = append([]models.BatchChat(nil), j.Chats...)
+	return snapshot
+}
@@ -0,0 +1,265 @@
+package services
+
+import (
+	"fmt"
+	"hash/maphash"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"craigstjean.com/stsummarizer/internal/config"
+	"craigstjean.com/stsummarizer/internal/models"
+)
+
+// Sources of a chat's creation time, the first one available is used
+const (
+	CreatedFromHeader   = "create_date"
+	CreatedFromFilename = "filename"
+	CreatedFromModified = "modified"
+)
+
+var (
+	// chatFileDatePattern matches the date SillyTavern puts in chat names, "Alice - 2025-02-11@20h57m09s" or
+	// "Branch #3 - 2025-02-11 @20h 57m 09s"
+	chatFileDatePattern = regexp.MustCompile(`(\d{4})-(\d{1,2})-(\d{1,2}) ?@(\d{1,2})h ?(\d{1,2})m ?(\d{1,2})s`)
+	// backupFileDatePattern matches the date in backup names, "chat_alice_20250211-205709.jsonl"
+	backupFileDatePattern = regexp.MustCompile(`(\d{4})(\d{2})(\d{2})-(\d{2})(\d{2})(\d{2})`)
+)
+
+// commonPrefix returns how many messages two chats share from their start. Messages match when the same speaker
+// said the same thing, the swipe that is active does not matter.
+func commonPrefix(a, b []models.ChatMessage) int {
+	n := 0
+	for n < len(a) && n < len(b) && a[n].Name == b[n].Name && a[n].IsUser == b[n].IsUser && a[n].Message == b[n].Message {
+		n++
+	}
+
+	return n
+}
+
+// messageSeed keys the message hashes, which are only compared within one process
+var messageSeed = maphash.MakeSeed()
+
+// hashMessages returns a hash of the speaker and text of each message, so chats can be compared by their first
+// messages without keeping them in memory
+func hashMessages(messages []models.ChatMessage) []uint64 {
+	hashes := make([]uint64, len(messages))
+	var h maphash.Hash
+	h.SetSeed(messageSeed)
+	for i, message := range messages {
+		h.Reset()
+		h.WriteString(message.Name)
+		if message.IsUser {
+			h.WriteByte(1)
+		} else {
+			h.WriteByte(0)
+		}
+		h.WriteString(message.Message)
+		hashes[i] = h.Sum64()
+	}
+
+	return hashes
+}
+
+// sharedPrefix returns how many message hashes two chats share from their start
+func sharedPrefix(a, b []uint64) int {
+	n := 0
+	for n < len(a) && n < len(b) && a[n] == b[n] {
+		n++
+	}
+
+	return n
+}
+
+// findBranchParents takes the message hashes of chats ordered oldest first and returns, for each, the earlier chat it
+// shares the most first messages with and how many it shares. The greeting alone does not make a branch, every chat
+// of a character starts with it, so a chat sharing no more than one message has no parent (-1).
+func findBranchParents(chats [][]uint64) ([]int, []int) {
+	parents := make([]int, len(chats))
+	forks := make([]int, len(chats))
+	for i := range chats {
+		parents[i] = -1
+		for j := range chats[:i] {
+			shared := sharedPrefix(chats[j], chats[i])
+			if shared > 1 && shared > forks[i] {
+				parents[i] = j
+				forks[i] = shared
+			}
+		}
+	}
+
+	return parents, forks
+}
+
+// BuildBranchTree links every chat and backup of the character to the older chat or backup it branched from. Chats
+// are read one at a time and only the hashes of their messages are kept. In lenient mode lines that cannot be parsed
+// are skipped and counted in the node instead of failing it.
+func BuildBranchTree(stService SillyTavernService, user, character string, lenient bool) (*models.BranchTree, error) {
+	if user == "" {
+		user = config.STDefaultUser
+	}
+
+	chats, err := stService.GetCharacterChats(user, character)
+	if err != nil {
+		return nil, err
+	}
+	backups, err := stService.GetCharacterBackups(user, character)
+	if err != nil {
+		return nil, err
+	}
+
+	nodes := make([]models.BranchNode, 0, len(chats)+len(backups))
+	hashes := make(map[string][]uint64, cap(nodes))
+	// read fills in the node from its messages and keeps their hashes
+	read := func(node *models.BranchNode, messages []models.ChatMessage, skipped []models.ChatLineError, err error) {
+		if err != nil {
+			node.Error = err.Error()
+			return
+		}
+
+		node.MessageCount = len(messages)
+		node.SkippedLines = len(skipped)
+		if len(messages) > 0 {
+			if sent, ok := ParseSendDate(messages[len(messages)-1].SendDate); ok {
+				node.LastMessage = &sent
+			}
+		}
+		hashes[node.ID] = hashMessages(messages)
+	}
+
+	for _, chat := range chats {
+		node := models.BranchNode{ID: BranchID(models.BranchChat, chat), Kind: models.BranchChat, Name: chat}
+
+		var messages []models.ChatMessage
+		var skipped []models.ChatLineError
+		if lenient {
+			messages, skipped, err = stService.GetCharacterChatLenient(user, character, chat)
+		} else {
+			messages, err = stService.GetCharacterChat(user, character, chat)
+		}
+		read(&node, messages, skipped, err)
+
+		info, err := stService.GetCharacterChatInfo(user, character, chat)
+		if err == nil {
+			node.Modified = &info.ModTime
+		}
+		// Without a readable header the file name or modification time dates the chat
+		header, _ := stService.GetCharacterChatHeader(user, character, chat)
+		node.Created, node.CreatedFrom = ChatCreated(header, chat, info)
+
+		nodes = append(nodes, node)
+	}
+	for _, backup := range backups {
+		node := models.BranchNode{ID: BranchID(models.BranchBackup, backup), Kind: models.BranchBackup, Name: backup}
+
+		var messages []models.ChatMessage
+		var skipped []models.ChatLineError
+		if lenient {
+			messages, skipped, err = stService.GetCharacterBackupLenient(user, character, backup)
+		} else {
+			messages, err = stService.GetCharacterBackup(user, character, backup)
+		}
+		read(&node, messages, skipped, err)
+
+		// The header of a backup is the one of the chat it copies, its name holds when it was taken
+		node.Created, node.CreatedFrom = ChatCreated(nil, backup, nil)
+
+		nodes = append(nodes, node)
+	}
+
+	sort.SliceStable(nodes, func(i, j int) bool {
+		return nodes[i].Created.Before(nodes[j].Created)
+	})
+
+	ordered := make([][]uint64, len(nodes))
+	for i, node := range nodes {
+		ordered[i] = hashes[node.ID]
+	}
+	parents, forks := findBranchParents(ordered)
+
+	for i := range nodes {
+		node := &nodes[i]
+		if parents[i] >= 0 {
+			node.Parent = nodes[parents[i]].ID
+			node.ForkIndex = forks[i]
+		}
+		node.Divergence = node.MessageCount - node.ForkIndex
+	}
+
+	return &models.BranchTree{
+		User:      user,
+		Character: character,
+		Nodes:     nodes,
+	}, nil
+}
+
+// DiffBranches compares two chats or backups of the character, given by their branch tree IDs, and returns the
+// messages of each after the ones they share
+func DiffBranches(stService SillyTavernService, user, character, a, b string) (*models.BranchDiff, error) {
+	aMessages, err := loadBranch(stService, user, character, a)
+	if err != nil {
+		return nil, err
+	}
+	bMessages, err := loadBranch(stService, user, character, b)
+	if err != nil {
+		return nil, err
+	}
+
+	shared := commonPrefix(aMessages, bMessages)
+	return &models.BranchDiff{
+		Shared: shared,
+		A:      models.BranchSide{ID: a, Start: shared, Total: len(aMessages), Messages: append([]models.ChatMessage{}, aMessages[shared:]...)},
+		B:      models.BranchSide{ID: b, Start: shared, Total: len(bMessages), Messages: append([]models.ChatMessage{}, bMessages[shared:]...)},
+	}, nil
+}
+
+// BranchID identifies a chat or backup in a branch tree, as "chat:<name>" or "backup:<name>"
+func BranchID(kind, name string) string {
+	return kind + ":" + name
+}
+
+// loadBranch reads the chat or backup behind a branch tree ID
+func loadBranch(stService SillyTavernService, user, character, id string) ([]models.ChatMessage, error) {
+	kind, name, _ := strings.Cut(id, ":")
+	switch {
+	case name == "":
+		return nil, fmt.Errorf("invalid branch: %q, expected chat:<name> or backup:<name>", id)
+	case kind == models.BranchChat:
+		return stService.GetCharacterChat(user, character, name)
+	case kind == models.BranchBackup:
+		return stService.GetCharacterBackup(user, character, name)
+	default:
+		return nil, fmt.Errorf("invalid branch: %q, expected chat:<name> or backup:<name>", id)
+	}
+}
+
+// ChatCreated returns when a chat was started and where that came from: the header's create_date, the date in the
+// file name, or the time the file was last modified
+func ChatCreated(header *models.ChatMetadata, name string, info *models.ChatFileInfo) (time.Time, string) {
+	if header != nil {
+		if created, ok := ParseSendDate(header.CreateDate); ok {
+			return created, CreatedFromHeader
+		}
+	}
+
+	for _, pattern := range []*regexp.Regexp{chatFileDatePattern, backupFileDatePattern} {
+		match := pattern.FindStringSubmatch(name)
+		if match == nil {
+			continue
+		}
+
+		parts := make([]int, 6)
+		for i := range parts {
+			parts[i], _ = strconv.Atoi(match[i+1])
+		}
+		return time.Date(parts[0], time.Month(parts[1]), parts[2], parts[3], parts[4], parts[5], 0, time.Local), CreatedFromFilename
+	}
+
+	if info != nil {
+		return info.ModTime, CreatedFromModified
+	}
+
+	return time.Time{}, ""
+}
@@ -0,0 +1,86 @@
+package services
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"craigstjean.com/stsummarizer/internal/config"
+	"craigstjean.com/stsummarizer/internal/models"
+)
+
+// SummaryCache stores the latest summary of each chat as a JSON file under the state directory
+type SummaryCache struct {
+	path string
+	mu   sync.Mutex
+}
+
+func NewSummaryCache() *SummaryCache {
+	return &SummaryCache{
+		path: filepath.Join(config.GetStatePath(), "summaries"),
+	}
+}
+
+// Get returns the cached summary for the chat, or nil when there is none
+func (c *SummaryCache) Get(ref models.ChatRef) (*models.CachedSummary, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	content, err := os.ReadFile(c.entryPath(ref))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read cached summary: %w", err)
+	}
+
+	var summary models.CachedSummary
+	if err := json.Unmarshal(content, &summary); err != nil {
+		return nil, fmt.Errorf("failed to parse cached summary: %w", err)
+	}
+
+	return &summary, nil
+}
+
+func (c *SummaryCache) Put(summary *models.CachedSummary) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	content, err := json.MarshalIndent(summary, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode cached summary: %w", err)
+	}
+
+	if err := os.MkdirAll(c.path, os.ModePerm); err != nil {
+		return fmt.Errorf("failed to create summary cache directory: %w", err)
+	}
+
+	if err := os.WriteFile(c.entryPath(summary.ChatRef), content, 0644); err != nil {
+		return fmt.Errorf("failed to write cached summary: %w", err)
+	}
+
+	return nil
+}
+
+// entryPath hashes the chat reference, as chat names may contain characters that are not valid in file names
+func (c *SummaryCache) entryPath(ref models.ChatRef) string {
+	kind := "chat"
+	if ref.Group {
+		kind = "group"
+	}
+
+	hash := sha256.Sum256([]byte(kind + "\x00" + ref.User + "\x00" + ref.Character + "\x00" + ref.Chat))
+	return filepath.Join(c.path, hex.EncodeToString(hash[:])+".json")
+}
+
+// IsCurrent reports whether the cached summary was generated from the chat file as it is now, with the same options
+func IsCurrent(summary *models.CachedSummary, source *models.ChatFileInfo, options string) bool {
+	return summary != nil && source != nil &&
+		summary.Options == options &&
+		summary.Source.Size == source.Size &&
+		summary.Source.ModTime.Equal(source.ModTime)
+}
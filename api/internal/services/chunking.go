@@ -0,0 +1,245 @@
+package services
+
+import (
+	"context"
+	"strings"
+	"unicode"
+
+	"craigstjean.com/stsummarizer/internal/tracing"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// messageSeparator goes between the messages of a chunk
+const messageSeparator = "\n\n---\n\n"
+
+// chunkEntry is a rendered message, or a piece of one too large to fit in a chunk on its own
+type chunkEntry struct {
+	text   string
+	tokens int
+	// source is the position of the message in the rendered chat
+	source int
+	// scene is set when a new scene starts with this entry
+	scene bool
+}
+
+// messageChunk is a range of entries sent to the model together, end is exclusive
+type messageChunk struct {
+	start  int
+	end    int
+	tokens int
+}
+
+// splitMessagesByTokenLimit groups the messages into chunks of at most maxTokens tokens. sceneStarts holds the
+// positions of messages that start a new scene, chunks are broken there when possible.
+func (s *OllamaService) splitMessagesByTokenLimit(ctx context.Context, chatMessages []string, sceneStarts []int, maxTokens int) ([]string, error) {
+	_, span := tracing.Start(ctx, "splitMessagesByTokenLimit",
+		attribute.Int("chunk.messages", len(chatMessages)),
+		attribute.Int("chunk.max_tokens", maxTokens))
+	defer span.End()
+
+	entries := s.chunkEntries(chatMessages, sceneStarts, maxTokens)
+
+	var groupedMessages []string
+	tokens := 0
+	for _, chunk := range s.chunkMessages(entries, maxTokens) {
+		groupedMessages = append(groupedMessages, joinChunk(entries, chunk))
+		tokens += chunk.tokens
+	}
+
+	span.SetAttributes(attribute.Int("chunk.count", len(groupedMessages)), attribute.Int("chunk.tokens", tokens))
+	return groupedMessages, nil
+}
+
+// chunkEntries counts the tokens of each message, splitting the ones larger than maxTokens at sentence boundaries
+func (s *OllamaService) chunkEntries(chatMessages []string, sceneStarts []int, maxTokens int) []chunkEntry {
+	scenes := make(map[int]bool, len(sceneStarts))
+	for _, start := range sceneStarts {
+		scenes[start] = true
+	}
+
+	entries := make([]chunkEntry, 0, len(chatMessages))
+	for i, message := range chatMessages {
+		tokens := s.countTokens(message)
+		if tokens <= maxTokens {
+			entries = append(entries, chunkEntry{text: message, tokens: tokens, source: i, scene: scenes[i]})
+			continue
+		}
+
+		for j, piece := range s.splitOversized(message, maxTokens) {
+			entries = append(entries, chunkEntry{text: piece, tokens: s.countTokens(piece), source: i, scene: j == 0 && scenes[i]})
+		}
+	}
+
+	return entries
+}
+
+// chunkMessages fills each chunk with as many entries as fit. When the next entry does not fit and a scene started
+// in the second half of the chunk, the chunk ends before that scene instead, so scenes are summarized whole.
+func (s *OllamaService) chunkMessages(entries []chunkEntry, maxTokens int) []messageChunk {
+	separatorTokens := s.countTokens(messageSeparator)
+
+	// tokensOf counts the entries of a range as joinChunk puts them together
+	tokensOf := func(start, end int) int {
+		tokens := 0
+		for i := start; i < end; i++ {
+			if i > start {
+				tokens += separatorTokens
+			}
+			tokens += entries[i].tokens
+		}
+		return tokens
+	}
+
+	var chunks []messageChunk
+	current := messageChunk{}
+
+	for i, entry := range entries {
+		added := entry.tokens
+		if current.end > current.start {
+			added += separatorTokens
+		}
+
+		if current.end > current.start && current.tokens+added > maxTokens {
+			// An early cut leaves the rest of the chunk to start the next one, so the entry must fit with it
+			split := current.end
+			for j := current.end - 1; j > current.start; j-- {
+				if entries[j].scene && tokensOf(current.start, j) >= maxTokens/2 {
+					if tokensOf(j, i)+separatorTokens+entry.tokens <= maxTokens {
+						split = j
+					}
+					break
+				}
+			}
+
+			chunks = append(chunks, messageChunk{start: current.start, end: split, tokens: tokensOf(current.start, split)})
+			current = messageChunk{start: split, end: i, tokens: tokensOf(split, i)}
+			added = entry.tokens
+			if current.end > current.start {
+				added += separatorTokens
+			}
+
+			// The rest that moved over is a chunk of its own when the entry still does not fit after it
+			if current.end > current.start && current.tokens+added > maxTokens {
+				chunks = append(chunks, current)
+				current = messageChunk{start: i, end: i}
+				added = entry.tokens
+			}
+		}
+
+		current.end = i + 1
+		current.tokens += added
+	}
+
+	if current.end > current.start {
+		chunks = append(chunks, current)
+	}
+
+	return chunks
+}
+
+func joinChunk(entries []chunkEntry, chunk messageChunk) string {
+	texts := make([]string, 0, chunk.end-chunk.start)
+	for _, entry := range entries[chunk.start:chunk.end] {
+		texts = append(texts, entry.text)
+	}
+
+	return strings.Join(texts, messageSeparator)
+}
+
+// splitOversized cuts a message into pieces of at most maxTokens tokens, between sentences when it can and between
+// words when a single sentence is too long
+func (s *OllamaService) splitOversized(message string, maxTokens int) []string {
+	maxTokens = max(maxTokens, 1)
+	var pieces []string
+	var current strings.Builder
+
+	flush := func() {
+		if piece := strings.TrimSpace(current.String()); piece != "" {
+			pieces = append(pieces, piece)
+		}
+		current.Reset()
+	}
+
+	for _, sentence := range splitSentences(message) {
+		if s.countTokens(current.String()+sentence) <= maxTokens {
+			current.WriteString(sentence)
+			continue
+		}
+		flush()
+
+		if s.countTokens(sentence) <= maxTokens {
+			current.WriteString(sentence)
+			continue
+		}
+		for _, word := range strings.SplitAfter(sentence, " ") {
+			if s.countTokens(current.String()+word) > maxTokens {
+				flush()
+			}
+			// A single word over the limit can only be cut by tokens
+			for s.countTokens(word) > maxTokens {
+				head := s.headTokens(word, maxTokens)
+				pieces = append(pieces, head)
+				word = word[len(head):]
+			}
+			current.WriteString(word)
+		}
+	}
+	flush()
+
+	return pieces
+}
+
+// headTokens returns the longest prefix of text with at most maxTokens tokens
+func (s *OllamaService) headTokens(text string, maxTokens int) string {
+	maxTokens = max(maxTokens, 1)
+	ids, _, err := s.tokenEncoder.Encode(text)
+	if err == nil && len(ids) > maxTokens {
+		if head, err := s.tokenEncoder.Decode(ids[:maxTokens]); err == nil && head != "" && strings.HasPrefix(text, head) {
+			return head
+		}
+	}
+
+	// Fall back to an even share of the runes
+	runes := []rune(text)
+	n := len(runes) * maxTokens / max(1, len(ids))
+	return string(runes[:min(max(n, 1), len(runes))])
+}
+
+// splitSentences splits text after sentence-ending punctuation, closing quotes included, and after line breaks.
+// Joining the sentences gives the text back.
+func splitSentences(text string) []string {
+	var sentences []string
+	runes := []rune(text)
+	start := 0
+
+	for i := 0; i < len(runes); i++ {
+		end := -1
+		switch runes[i] {
+		case '\n':
+			end = i + 1
+		case '.', '!', '?', '…':
+			j := i + 1
+			for j < len(runes) && strings.ContainsRune(`.!?…"'”’)]*_`, runes[j]) {
+				j++
+			}
+			if j == len(runes) || unicode.IsSpace(runes[j]) {
+				end = j
+			}
+		}
+		if end < 0 {
+			continue
+		}
+
+		for end < len(runes) && runes[end] != '\n' && unicode.IsSpace(runes[end]) {
+			end++
+		}
+		sentences = append(sentences, string(runes[start:end]))
+		start = end
+		i = end - 1
+	}
+	if start < len(runes) {
+		sentences = append(sentences, string(runes[start:]))
+	}
+
+	return sentences
+}
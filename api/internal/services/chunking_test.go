@@ -0,0 +1,305 @@
+package services
+
+import (
+	"encoding/json"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"craigstjean.com/stsummarizer/internal/models"
+	"github.com/tiktoken-go/tokenizer"
+)
+
+func newTestOllamaService(t *testing.T) *OllamaService {
+	t.Helper()
+
+	enc, err := tokenizer.Get(tokenizer.O200kBase)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &OllamaService{tokenEncoder: enc}
+}
+
+// words returns a message repeating word n times
+func words(word string, n int) string {
+	parts := make([]string, n)
+	for i := range parts {
+		parts[i] = word
+	}
+	return strings.Join(parts, " ")
+}
+
+func withoutSpace(text string) string {
+	return strings.Join(strings.Fields(text), "")
+}
+
+func TestChunkMessages(t *testing.T) {
+	s := newTestOllamaService(t)
+
+	tests := []struct {
+		name        string
+		messages    []string
+		sceneStarts []int
+		maxTokens   int
+	}{
+		{
+			name:      "empty",
+			maxTokens: 100,
+		},
+		{
+			name:      "single message",
+			messages:  []string{words("hello", 10)},
+			maxTokens: 100,
+		},
+		{
+			name:      "several chunks",
+			messages:  []string{words("alpha", 40), words("beta", 40), words("gamma", 40), words("delta", 40)},
+			maxTokens: 100,
+		},
+		{
+			name:        "scene split leaves a rest that does not fit with the next message",
+			messages:    []string{words("alpha", 30), words("beta", 30), words("gamma", 30), words("delta", 90)},
+			sceneStarts: []int{2},
+			maxTokens:   100,
+		},
+		{
+			name:        "scene split",
+			messages:    []string{words("alpha", 30), words("beta", 30), words("gamma", 20), words("delta", 30)},
+			sceneStarts: []int{2},
+			maxTokens:   100,
+		},
+		{
+			name:      "message larger than the limit",
+			messages:  []string{words("alpha", 10), strings.Repeat(words("beta", 15)+". ", 20), words("gamma", 10)},
+			maxTokens: 50,
+		},
+		{
+			name:      "word larger than the limit",
+			messages:  []string{words("alpha", 5), strings.Repeat("x", 500), words("gamma", 5)},
+			maxTokens: 20,
+		},
+		{
+			name:      "one token per chunk",
+			messages:  []string{words("alpha", 3), "Hello, world."},
+			maxTokens: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entries := s.chunkEntries(tt.messages, tt.sceneStarts, tt.maxTokens)
+			chunks := s.chunkMessages(entries, tt.maxTokens)
+
+			next := 0
+			for _, chunk := range chunks {
+				if chunk.start != next || chunk.end <= chunk.start {
+					t.Fatalf("chunk %+v does not follow entry %d", chunk, next)
+				}
+				next = chunk.end
+
+				if tokens := s.countTokens(joinChunk(entries, chunk)); tokens > tt.maxTokens {
+					t.Errorf("chunk %+v has %d tokens, more than %d", chunk, tokens, tt.maxTokens)
+				}
+			}
+			if next != len(entries) {
+				t.Fatalf("chunks cover %d of %d entries", next, len(entries))
+			}
+
+			grouped, err := s.splitMessagesByTokenLimit(t.Context(), tt.messages, tt.sceneStarts, tt.maxTokens)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := withoutSpace(strings.ReplaceAll(strings.Join(grouped, ""), strings.TrimSpace(messageSeparator), ""))
+			if want := withoutSpace(strings.Join(tt.messages, "")); got != want {
+				t.Errorf("chunks lost message text: got %q, want %q", got, want)
+			}
+		})
+	}
+}
+
+func TestChunkMessagesBreaksAtScenes(t *testing.T) {
+	s := newTestOllamaService(t)
+
+	messages := []string{words("alpha", 30), words("beta", 30), words("gamma", 20), words("delta", 30)}
+	entries := s.chunkEntries(messages, []int{2}, 100)
+	chunks := s.chunkMessages(entries, 100)
+
+	if len(chunks) != 2 || chunks[0].end != 2 {
+		t.Errorf("chunks %+v do not break before the scene at 2", chunks)
+	}
+}
+
+func TestSplitOversized(t *testing.T) {
+	s := newTestOllamaService(t)
+
+	tests := []struct {
+		name      string
+		message   string
+		maxTokens int
+	}{
+		{"empty", "", 10},
+		{"fits", "One sentence.", 10},
+		{"sentences", strings.Repeat("This is a sentence of the message. ", 20), 30},
+		{"long sentence", words("word", 200), 30},
+		{"long word", strings.Repeat("abcdefgh", 100), 10},
+		{"zero limit", "Hello, world.", 0},
+		{"negative limit", "Hello, world.", -1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pieces := s.splitOversized(tt.message, tt.maxTokens)
+
+			// Limits under one token are cut at one token
+			limit := max(tt.maxTokens, 1)
+			for _, piece := range pieces {
+				if tokens := s.countTokens(piece); tokens > limit {
+					t.Errorf("piece %q has %d tokens, more than %d", piece, tokens, limit)
+				}
+			}
+			if got, want := withoutSpace(strings.Join(pieces, "")), withoutSpace(tt.message); got != want {
+				t.Errorf("pieces lost text: got %q, want %q", got, want)
+			}
+		})
+	}
+}
+
+func TestSummaryBudget(t *testing.T) {
+	s := newTestOllamaService(t)
+
+	tests := []struct {
+		name    string
+		opts    SummaryOptions
+		wantErr bool
+	}{
+		{"default", SummaryOptions{Model: "m"}, false},
+		{"prior summary", SummaryOptions{Model: "m", MaxTokens: 1000, PriorSummary: words("before", 2000)}, false},
+		{"background", SummaryOptions{Model: "m", MaxTokens: 1000, Context: []string{words("lore", 2000)}, ContextTokens: 2000}, false},
+		{"too small", SummaryOptions{Model: "m", MaxTokens: 1}, true},
+		{"too small with background and prior", SummaryOptions{
+			Model: "m", MaxTokens: 1, Context: []string{words("lore", 50)}, ContextTokens: 50, PriorSummary: words("before", 50),
+		}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			budget, err := s.summaryBudget(tt.opts)
+			if tt.wantErr {
+				if err == nil || !strings.HasPrefix(err.Error(), "invalid max_tokens") {
+					t.Fatalf("summaryBudget() error = %v, want invalid max_tokens", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if budget.maxTokens < MinChunkTokens {
+				t.Errorf("chunk budget %d is under %d", budget.maxTokens, MinChunkTokens)
+			}
+		})
+	}
+}
+
+func TestSplitSentences(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want []string
+	}{
+		{"empty", "", nil},
+		{"no punctuation", "just words", []string{"just words"}},
+		{"sentences", "One. Two! Three?", []string{"One. ", "Two! ", "Three?"}},
+		{"closing quotes", `"Stop!" she said. Then left.`, []string{`"Stop!" `, "she said. ", "Then left."}},
+		{"line breaks", "First line\nSecond line", []string{"First line\n", "Second line"}},
+		{"decimal", "It cost 3.50 today.", []string{"It cost 3.50 today."}},
+		{"ellipsis", "Well… maybe.", []string{"Well… ", "maybe."}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := splitSentences(tt.text)
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("splitSentences(%q) = %q, want %q", tt.text, got, tt.want)
+			}
+			if joined := strings.Join(got, ""); joined != tt.text {
+				t.Errorf("joined sentences %q, want %q", joined, tt.text)
+			}
+		})
+	}
+}
+
+func TestFindSceneStarts(t *testing.T) {
+	message := func(text, sendDate string) models.ChatMessage {
+		m := models.ChatMessage{Name: "Alice", Message: text}
+		if sendDate != "" {
+			m.SendDate = json.RawMessage(`"` + sendDate + `"`)
+		}
+		return m
+	}
+
+	tests := []struct {
+		name     string
+		messages []models.ChatMessage
+		gap      time.Duration
+		want     []int
+	}{
+		{
+			name: "empty",
+		},
+		{
+			name:     "no scenes",
+			messages: []models.ChatMessage{message("Hello.", ""), message("Hi.", "")},
+		},
+		{
+			name:     "time skip",
+			messages: []models.ChatMessage{message("Hello.", ""), message("The next morning, they left.", "")},
+			want:     []int{1},
+		},
+		{
+			name:     "marker inside a message",
+			messages: []models.ChatMessage{message("Hello.", ""), message("Bye.\n***\nLater on.", "")},
+			want:     []int{1},
+		},
+		{
+			name:     "marker ending a message",
+			messages: []models.ChatMessage{message("Hello.", ""), message("Bye.\n* * *", ""), message("Morning.", "")},
+			want:     []int{2},
+		},
+		{
+			name: "skipped messages are not counted",
+			messages: []models.ChatMessage{
+				message("Hello.", ""),
+				{Name: "", Message: "note"},
+				message("Hours later, she woke.", ""),
+			},
+			want: []int{1},
+		},
+		{
+			name: "gap",
+			messages: []models.ChatMessage{
+				message("Hello.", "2025-02-11T20:00:00Z"),
+				message("Hi.", "2025-02-11T20:05:00Z"),
+				message("Morning.", "2025-02-12T08:00:00Z"),
+			},
+			gap:  6 * time.Hour,
+			want: []int{2},
+		},
+		{
+			name: "gap disabled",
+			messages: []models.ChatMessage{
+				message("Hello.", "2025-02-11T20:00:00Z"),
+				message("Morning.", "2025-02-12T08:00:00Z"),
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := findSceneStarts(tt.messages, tt.gap)
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("findSceneStarts() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
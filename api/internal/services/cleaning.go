@@ -0,0 +1,194 @@
+package services
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"html"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"craigstjean.com/stsummarizer/internal/config"
+	"craigstjean.com/stsummarizer/internal/models"
+)
+
+// CleaningRule replaces every match of Pattern (Go regexp syntax) with Replacement, which may use $1 style groups
+type CleaningRule struct {
+	Pattern     string `json:"pattern"`
+	Replacement string `json:"replacement"`
+	// Output also applies the rule to the summaries returned by the model
+	Output bool `json:"output"`
+}
+
+// CleaningOptions configures the cleaning pipeline, read from cleaning.json in the state directory
+type CleaningOptions struct {
+	StripThinking    bool           `json:"strip_thinking"`
+	StripHTML        bool           `json:"strip_html"`
+	SubstituteMacros bool           `json:"substitute_macros"`
+	Rules            []CleaningRule `json:"rules"`
+}
+
+// DefaultCleaningOptions enables every step, without custom rules
+var DefaultCleaningOptions = CleaningOptions{
+	StripThinking:    true,
+	StripHTML:        true,
+	SubstituteMacros: true,
+}
+
+var (
+	// thinkingPatterns match the reasoning blocks of DeepSeek-R1 style models
+	thinkingPatterns = []*regexp.Regexp{
+		regexp.MustCompile(`(?is)<think>.*?</think>`),
+		regexp.MustCompile(`(?is)<thinking>.*?</thinking>`),
+		regexp.MustCompile(`(?is)<reasoning>.*?</reasoning>`),
+	}
+	// unopenedThinkingPattern matches reasoning whose opening tag was consumed by the chat template
+	unopenedThinkingPattern = regexp.MustCompile(`(?is)^.*?</think(ing)?>`)
+	htmlBlockPattern        = regexp.MustCompile(`(?is)<(style|script)\b[^>]*>.*?</(style|script)>`)
+	htmlCommentPattern      = regexp.MustCompile(`(?s)<!--.*?-->`)
+	htmlBreakPattern        = regexp.MustCompile(`(?i)<br\s*/?>|</(p|div|li|tr|h[1-6]|details|summary)>`)
+	htmlTagPattern          = regexp.MustCompile(`</?[a-zA-Z][a-zA-Z0-9-]*(\s[^<>]*)?/?>`)
+	blankLinesPattern       = regexp.MustCompile(`\n[ \t]*(\n[ \t]*)+`)
+)
+
+type compiledRule struct {
+	pattern     *regexp.Regexp
+	replacement string
+	output      bool
+}
+
+// Cleaner removes what only wastes tokens from messages before they are chunked, and from the model's summaries
+type Cleaner struct {
+	options     CleaningOptions
+	rules       []compiledRule
+	fingerprint string
+}
+
+// NewCleaner loads cleaning.json from the state directory, using DefaultCleaningOptions when it does not exist
+func NewCleaner() (*Cleaner, error) {
+	options := DefaultCleaningOptions
+
+	content, err := os.ReadFile(filepath.Join(config.GetStatePath(), "cleaning.json"))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to read cleaning options: %w", err)
+	}
+	if err == nil {
+		if err := json.Unmarshal(content, &options); err != nil {
+			return nil, fmt.Errorf("failed to parse cleaning options: %w", err)
+		}
+	}
+
+	return NewCleanerWithOptions(options)
+}
+
+func NewCleanerWithOptions(options CleaningOptions) (*Cleaner, error) {
+	cleaner := &Cleaner{
+		options: options,
+	}
+
+	for i, rule := range options.Rules {
+		pattern, err := regexp.Compile(rule.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid cleaning rule %d: %w", i, err)
+		}
+		cleaner.rules = append(cleaner.rules, compiledRule{
+			pattern:     pattern,
+			replacement: rule.Replacement,
+			output:      rule.Output,
+		})
+	}
+
+	encoded, _ := json.Marshal(options)
+	hash := sha256.Sum256(encoded)
+	cleaner.fingerprint = hex.EncodeToString(hash[:8])
+
+	return cleaner, nil
+}
+
+// Fingerprint identifies the options, so summaries cleaned differently are not mistaken for each other
+func (c *Cleaner) Fingerprint() string {
+	return c.fingerprint
+}
+
+// CleanMessages returns copies of the messages with their active swipe cleaned. {{user}} is the first user name in
+// the chat and {{char}} the speaking character, or the first character to speak for user messages.
+func (c *Cleaner) CleanMessages(messages []models.ChatMessage) []models.ChatMessage {
+	userName, characterName := chatNames(messages)
+
+	cleaned := make([]models.ChatMessage, 0, len(messages))
+	for _, message := range messages {
+		speaker := characterName
+		if !message.IsUser {
+			speaker = message.Name
+		}
+
+		message.Message = c.CleanMessage(ActiveSwipe(message), userName, speaker)
+		message.SwipeID = nil
+		message.Swipes = nil
+		cleaned = append(cleaned, message)
+	}
+
+	return cleaned
+}
+
+// CleanMessage runs one message through the pipeline: reasoning blocks, macros, HTML, then the custom rules.
+// Macros go first since <USER> and <BOT> look like HTML tags.
+func (c *Cleaner) CleanMessage(text, userName, characterName string) string {
+	if c.options.StripThinking {
+		text = stripThinking(text)
+	}
+	if c.options.SubstituteMacros {
+		text = replaceNameMacros(text, userName, characterName)
+	}
+	if c.options.StripHTML {
+		text = stripHTML(text)
+	}
+	for _, rule := range c.rules {
+		text = rule.pattern.ReplaceAllString(text, rule.replacement)
+	}
+
+	return strings.TrimSpace(text)
+}
+
+// CleanOutput removes reasoning blocks and applies the output rules to a response from the model
+func (c *Cleaner) CleanOutput(text string) string {
+	if c.options.StripThinking {
+		text = stripThinking(text)
+	}
+	for _, rule := range c.rules {
+		if rule.output {
+			text = rule.pattern.ReplaceAllString(text, rule.replacement)
+		}
+	}
+
+	return strings.TrimSpace(text)
+}
+
+func stripThinking(text string) string {
+	for _, pattern := range thinkingPatterns {
+		text = pattern.ReplaceAllString(text, "")
+	}
+	if !strings.Contains(strings.ToLower(text), "<think") {
+		text = unopenedThinkingPattern.ReplaceAllString(text, "")
+	}
+
+	return text
+}
+
+// stripHTML drops style and script blocks and comments, keeps the text of other elements and decodes entities
+func stripHTML(text string) string {
+	if !strings.Contains(text, "<") && !strings.Contains(text, "&") {
+		return text
+	}
+
+	text = htmlBlockPattern.ReplaceAllString(text, "")
+	text = htmlCommentPattern.ReplaceAllString(text, "")
+	text = htmlBreakPattern.ReplaceAllString(text, "\n")
+	text = htmlTagPattern.ReplaceAllString(text, "")
+	text = html.UnescapeString(text)
+
+	return blankLinesPattern.ReplaceAllString(text, "\n\n")
+}
@@ -0,0 +1,110 @@
+package services
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"craigstjean.com/stsummarizer/internal/models"
+)
+
+// BuildSummaryContext describes the chat's characters and the user's persona so the summarizer knows who is who.
+// Characters or personas that cannot be resolved are skipped.
+func BuildSummaryContext(stService SillyTavernService, user string, characters []string, messages []models.ChatMessage) []string {
+	userName := ""
+	for _, message := range messages {
+		if message.IsUser && message.Name != "" {
+			userName = message.Name
+			break
+		}
+	}
+
+	var context []string
+	for _, character := range characters {
+		card, err := stService.GetCharacterCard(user, character)
+		if err != nil {
+			continue
+		}
+
+		if description := describeCharacter(card, userName); description != "" {
+			context = append(context, description)
+		}
+	}
+
+	if persona, err := stService.GetUserPersona(user, userName); err == nil {
+		if description := describePersona(persona); description != "" {
+			context = append(context, description)
+		}
+	}
+
+	return context
+}
+
+func describeCharacter(card *models.CharacterCard, userName string) string {
+	if card.Name == "" {
+		return ""
+	}
+
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("%s (character)", card.Name))
+	if card.Description != "" {
+		sb.WriteString(": " + card.Description)
+	}
+	if card.Personality != "" {
+		sb.WriteString("\nPersonality: " + card.Personality)
+	}
+	if card.Scenario != "" {
+		sb.WriteString("\nScenario: " + card.Scenario)
+	}
+
+	return replaceNameMacros(sb.String(), userName, card.Name)
+}
+
+func describePersona(persona *models.Persona) string {
+	if persona.Name == "" {
+		return ""
+	}
+
+	description := fmt.Sprintf("%s (user)", persona.Name)
+	if persona.Description != "" {
+		description += ": " + persona.Description
+	}
+
+	return replaceNameMacros(description, persona.Name, "")
+}
+
+func replaceNameMacros(text, userName, characterName string) string {
+	if userName != "" {
+		text = strings.NewReplacer("{{user}}", userName, "{{User}}", userName, "<USER>", userName).Replace(text)
+	}
+	if characterName != "" {
+		text = strings.NewReplacer("{{char}}", characterName, "{{Char}}", characterName, "<BOT>", characterName).Replace(text)
+	}
+
+	return text
+}
+
+// GroupMembers returns the character names of the group that owns the chat
+func GroupMembers(stService SillyTavernService, user, chat string) []string {
+	groups, err := stService.GetGroupChats(user)
+	if err != nil {
+		return nil
+	}
+
+	for _, group := range groups {
+		for _, groupChat := range group.Chats {
+			if groupChat != chat {
+				continue
+			}
+
+			// Members are stored as avatar filenames, which match the character names without the extension
+			members := make([]string, 0, len(group.Members))
+			for _, member := range group.Members {
+				members = append(members, strings.TrimSuffix(member, filepath.Ext(member)))
+			}
+			return members
+		}
+	}
+
+	return nil
+}
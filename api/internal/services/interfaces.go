@@ -1,15 +1,44 @@
 package services
 
-import "craigstjean.com/stsummarizer/internal/models"
+import (
+	"time"
+
+	"craigstjean.com/stsummarizer/internal/models"
+)
 
 type SillyTavernService interface {
 	GetUsers() ([]string, error)
-	GetCharacters(user string) ([]string, error)
+	VerifyUserPassword(handle, password string) (*models.UserAccount, error)
+	GetCharacters(user string) ([]models.Character, error)
+	GetCharacterCard(user, character string) (*models.CharacterCard, error)
+	GetCharacterAvatar(user, character string, width, height int) ([]byte, time.Time, error)
+	GetUserPersona(user, name string) (*models.Persona, error)
+	GetRegexScripts(user string, characters []string) ([]models.RegexScript, error)
+	GetWorlds(user string) ([]string, error)
+	GetWorld(user, world string) (*models.Lorebook, error)
+	SaveWorldEntries(user, world string, entries []models.WorldInfoEntry) (*models.Lorebook, error)
 	GetCharacterChats(user, character string) ([]string, error)
 	GetCharacterChat(user, character, chat string) ([]models.ChatMessage, error)
+	GetCharacterChatLenient(user, character, chat string) ([]models.ChatMessage, []models.ChatLineError, error)
+	GetCharacterChatHeader(user, character, chat string) (*models.ChatMetadata, error)
 	GetCharacterBackups(user, character string) ([]string, error)
 	GetCharacterBackup(user, character, backup string) ([]models.ChatMessage, error)
+	GetCharacterBackupLenient(user, character, backup string) ([]models.ChatMessage, []models.ChatLineError, error)
 	RestoreCharacterBackup(user, character, backup string) (string, error)
+	ValidateCharacterChat(user, character, chat string) (*models.ChatValidation, error)
+	ValidateGroupChat(user, chat string) (*models.ChatValidation, error)
+	ValidateCharacterBackup(user, character, backup string) (*models.ChatValidation, error)
+	RepairCharacterChat(user, character, chat string) (*models.ChatRepair, error)
+	RepairCharacterBackup(user, character, backup string) (*models.ChatRepair, error)
+	RepairGroupChat(user, chat string) (*models.ChatRepair, error)
 	GetGroupChats(user string) ([]models.GroupChat, error)
 	GetGroupChat(user, chat string) ([]models.ChatMessage, error)
+	GetGroupChatLenient(user, chat string) ([]models.ChatMessage, []models.ChatLineError, error)
+	GetCharacterChatPage(user, character, chat string, query models.ChatQuery) (*models.ChatPage, error)
+	GetGroupChatPage(user, chat string, query models.ChatQuery) (*models.ChatPage, error)
+	GetCharacterBackupPage(user, character, backup string, query models.ChatQuery) (*models.ChatPage, error)
+	GetCharacterChatInfo(user, character, chat string) (*models.ChatFileInfo, error)
+	GetGroupChatInfo(user, chat string) (*models.ChatFileInfo, error)
+	SetCharacterChatMetadata(user, character, chat, key string, value interface{}) error
+	SetGroupChatMetadata(user, chat, key string, value interface{}) error
 }
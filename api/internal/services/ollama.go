@@ -2,14 +2,24 @@ package services
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
+	"log/slog"
 	"net/http"
 	"strings"
+	"time"
 
 	"craigstjean.com/stsummarizer/internal/config"
+	"craigstjean.com/stsummarizer/internal/logging"
+	"craigstjean.com/stsummarizer/internal/metrics"
 	"craigstjean.com/stsummarizer/internal/models"
+	"craigstjean.com/stsummarizer/internal/tracing"
 	"github.com/tiktoken-go/tokenizer"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
 )
 
 type OllamaService struct {
@@ -28,6 +38,7 @@ type ollamaRequest struct {
 	Model    string    `json:"model"`
 	Messages []message `json:"messages"`
 	Stream   bool      `json:"stream"`
+	Format   string    `json:"format,omitempty"`
 }
 
 type message struct {
@@ -39,6 +50,9 @@ type ollamaResponse struct {
 	Message struct {
 		Content string `json:"content"`
 	} `json:"message"`
+	// PromptEvalCount and EvalCount are the tokens of the prompt and the response, as the model counts them
+	PromptEvalCount int `json:"prompt_eval_count"`
+	EvalCount       int `json:"eval_count"`
 }
 
 func NewOllamaService() *OllamaService {
@@ -47,12 +61,32 @@ func NewOllamaService() *OllamaService {
 		panic(err)
 	}
 
+	cfg := config.Get()
+	client := &http.Client{
+		Timeout: time.Duration(cfg.Timeouts.Ollama),
+	}
+	if cfg.Ollama.APIKey != "" {
+		client.Transport = bearerTransport{apiKey: cfg.Ollama.APIKey, base: http.DefaultTransport}
+	}
+
 	return &OllamaService{
-		client:       &http.Client{},
+		client:       client,
 		tokenEncoder: enc,
 	}
 }
 
+// bearerTransport adds the configured API key to every request, for Ollama servers behind an authenticating proxy
+type bearerTransport struct {
+	apiKey string
+	base   http.RoundTripper
+}
+
+func (t bearerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	req.Header.Set("Authorization", "Bearer "+t.apiKey)
+	return t.base.RoundTrip(req)
+}
+
 func (s *OllamaService) GetModels() ([]models.Model, error) {
 	resp, err := s.client.Get(fmt.Sprintf("%s/api/tags", config.GetOllamaBaseURL()))
 	if err != nil {
@@ -74,14 +108,72 @@ func (s *OllamaService) GetModels() ([]models.Model, error) {
 		result[i] = models.Model{
 			Name:    model.Name,
 			Model:   model.ModType,
-			Default: model.Name == config.DefaultModel,
+			Default: model.Name == config.GetDefaultModel(),
 		}
 	}
 
 	return result, nil
 }
 
-func (s *OllamaService) SummarizeChat(model string, chatMessages []string, maxTokens int, summaryWordLimit int) ([]string, error) {
+// Ping checks that Ollama answers, without reading the models it lists
+func (s *OllamaService) Ping(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fmt.Sprintf("%s/api/tags", config.GetOllamaBaseURL()), nil)
+	if err != nil {
+		return fmt.Errorf("failed to create request to Ollama: %w", err)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to reach Ollama: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("ollama API returned status code: %d", resp.StatusCode)
+	}
+
+	return nil
+}
+
+// SummaryOptions controls how a chat is summarized
+type SummaryOptions struct {
+	Model     string
+	MaxTokens int
+	WordLimit int
+
+	// Context holds background descriptions (characters, persona, setting) included in every prompt.
+	// Each entry receives an equal share of ContextTokens.
+	Context       []string
+	ContextTokens int
+
+	// PriorSummary summarizes the story before these messages, so the new summary stays consistent with it
+	PriorSummary string
+
+	// Cleaner, when set, cleans every response before it is used, partial summaries included
+	Cleaner *Cleaner
+
+	// SceneStarts holds the positions of the messages that start a new scene, chunks end before them when possible
+	SceneStarts []int
+}
+
+// summaryBudget is what SummarizeChat works with once the options are resolved
+type summaryBudget struct {
+	model      string
+	maxTokens  int // tokens of chat per chunk, after the background and prior summary
+	wordLimit  int
+	background string
+	prior      string
+}
+
+// MinChunkTokens is the smallest chunk of chat worth sending once the background and prior summary are taken out, so
+// also the smallest max_tokens accepted
+const MinChunkTokens = 100
+
+func (s *OllamaService) summaryBudget(opts SummaryOptions) (summaryBudget, error) {
+	maxTokens := opts.MaxTokens
+	summaryWordLimit := opts.WordLimit
+	model := opts.Model
+
 	// Defaults
 	if maxTokens <= 0 {
 		maxTokens = 4096 - 100 // Default: reserve 100 tokens for request text
@@ -91,17 +183,61 @@ func (s *OllamaService) SummarizeChat(model string, chatMessages []string, maxTo
 	}
 
 	if model == "" {
-		model = config.DefaultModel
+		model = config.GetDefaultModel()
+	}
+
+	// Background context shares the prompt with the chat, so it comes out of the chunk budget
+	background := s.fitContext(opts.Context, opts.ContextTokens)
+	if background != "" {
+		maxTokens = max(maxTokens-s.countTokens(background), maxTokens/2)
+	}
+
+	// The prior summary is limited to a quarter of the budget, and left out when there is no room for it
+	prior := strings.TrimSpace(opts.PriorSummary)
+	if prior != "" {
+		prior = s.truncateTokens(prior, maxTokens/4)
+		maxTokens = max(maxTokens-s.countTokens(prior), 0)
+	}
+	if maxTokens < MinChunkTokens {
+		return summaryBudget{}, fmt.Errorf("invalid max_tokens: too small for the background and prior summary")
+	}
+
+	return summaryBudget{
+		model:      model,
+		maxTokens:  maxTokens,
+		wordLimit:  summaryWordLimit,
+		background: background,
+		prior:      prior,
+	}, nil
+}
+
+func (s *OllamaService) SummarizeChat(ctx context.Context, chatMessages []string, opts SummaryOptions) ([]string, error) {
+	budget, err := s.summaryBudget(opts)
+	if err != nil {
+		return nil, err
+	}
+	model := budget.model
+	maxTokens := budget.maxTokens
+	summaryWordLimit := budget.wordLimit
+	background := budget.background
+	prior := budget.prior
+
+	summarize := func(ctx context.Context, chunk int, input string, passage bool, wordLimit int) (string, error) {
+		summary, err := s.callSummarizer(ctx, model, chunk, background, prior, input, passage, wordLimit)
+		if err == nil && opts.Cleaner != nil {
+			summary = opts.Cleaner.CleanOutput(summary)
+		}
+		return summary, err
 	}
 
 	// 1. Split chat messages into groupings that fit maxTokens
-	groupedMessages, err := s.splitMessagesByTokenLimit(chatMessages, maxTokens)
+	groupedMessages, err := s.splitMessagesByTokenLimit(ctx, chatMessages, opts.SceneStarts, maxTokens)
 	if err != nil {
 		return nil, err
 	}
 
 	if len(groupedMessages) == 1 {
-		summary, err := s.callSummarizer(model, groupedMessages[0], false, summaryWordLimit) // Use word limit for final summary
+		summary, err := summarize(ctx, 0, groupedMessages[0], false, summaryWordLimit) // Use word limit for final summary
 		if err != nil {
 			return nil, fmt.Errorf("failed to generate summary: %w", err)
 		}
@@ -111,7 +247,7 @@ func (s *OllamaService) SummarizeChat(model string, chatMessages []string, maxTo
 	// 2. Summarize each grouping
 	var individualSummaries []string
 	for i, group := range groupedMessages {
-		partialSummary, err := s.callSummarizer(model, group, true, 0) // No word limit per individual summary
+		partialSummary, err := summarize(ctx, i, group, true, 0) // No word limit per individual summary
 		if err != nil {
 			return nil, fmt.Errorf("failed to summarize group %d: %w", i, err)
 		}
@@ -120,7 +256,9 @@ func (s *OllamaService) SummarizeChat(model string, chatMessages []string, maxTo
 
 	// 3. Consolidate summaries for a final summary
 	combinedSummaries := strings.Join(individualSummaries, "\n")
-	finalSummary, err := s.callSummarizer(model, combinedSummaries, false, summaryWordLimit) // Use word limit for final summary
+	consolidateCtx, span := tracing.Start(ctx, "consolidateSummaries", attribute.Int("summary.partials", len(individualSummaries)))
+	finalSummary, err := summarize(consolidateCtx, len(groupedMessages), combinedSummaries, false, summaryWordLimit) // Use word limit for final summary
+	tracing.End(span, err)
 	if err != nil {
 		return nil, fmt.Errorf("failed to generate final summary: %w", err)
 	}
@@ -129,31 +267,146 @@ func (s *OllamaService) SummarizeChat(model string, chatMessages []string, maxTo
 	return append(individualSummaries, finalSummary), nil
 }
 
-func (s *OllamaService) splitMessagesByTokenLimit(chatMessages []string, maxTokens int) ([]string, error) {
-	var groupedMessages []string
-	var currentGroup []string
-	var currentTokenCount int
+// MergeSummaries combines the summaries of several chats of one story, oldest first, into a single story so far.
+// Sections that do not fit one prompt are merged in turns, each turn extending the story of the turns before it.
+func (s *OllamaService) MergeSummaries(ctx context.Context, sections []string, opts SummaryOptions) (string, error) {
+	budget, err := s.summaryBudget(opts)
+	if err != nil {
+		return "", err
+	}
+
+	// Each turn also carries the story so far, up to a quarter of the budget
+	groupedSections, err := s.splitMessagesByTokenLimit(ctx, sections, nil, budget.maxTokens-budget.maxTokens/4)
+	if err != nil {
+		return "", err
+	}
 
-	for _, message := range chatMessages {
-		messageTokenCount := s.countTokens(message)
-		if currentTokenCount+messageTokenCount > maxTokens {
-			// Join current group into a single string and add to result
-			groupedMessages = append(groupedMessages, strings.Join(currentGroup, "\n\n---\n\n"))
-			// Reset the current group
-			currentGroup = []string{}
-			currentTokenCount = 0
+	ctx, span := tracing.Start(ctx, "MergeSummaries",
+		attribute.String("ollama.model", budget.model),
+		attribute.Int("summary.sections", len(sections)),
+		attribute.Int("summary.turns", len(groupedSections)))
+	defer span.End()
+
+	story := budget.prior
+	for i, group := range groupedSections {
+		prompt := buildStoryPrompt(budget.background, s.truncateTokens(story, budget.maxTokens/4), group, budget.wordLimit)
+		merged, err := s.chat(ctx, budget.model, prompt, "")
+		if err != nil {
+			return "", fmt.Errorf("failed to merge summaries %d: %w", i, err)
+		}
+		if opts.Cleaner != nil {
+			merged = opts.Cleaner.CleanOutput(merged)
 		}
-		// Add message to current group
-		currentGroup = append(currentGroup, message)
-		currentTokenCount += messageTokenCount
+		story = strings.TrimSpace(merged)
 	}
 
-	// Add the last group if it exists
-	if len(currentGroup) > 0 {
-		groupedMessages = append(groupedMessages, strings.Join(currentGroup, "\n"))
+	return story, nil
+}
+
+// PlanSummary reports how SummarizeChat would chunk the messages and how many tokens it would send, without calling
+// the model. indexes holds the chat index of each rendered message.
+func (s *OllamaService) PlanSummary(chatMessages []string, indexes []int, opts SummaryOptions) (models.SummaryPlan, error) {
+	budget, err := s.summaryBudget(opts)
+	if err != nil {
+		return models.SummaryPlan{}, err
 	}
 
-	return groupedMessages, nil
+	plan := models.SummaryPlan{
+		MaxTokens:     opts.MaxTokens,
+		ChunkTokens:   budget.maxTokens,
+		ContextTokens: s.countTokens(budget.background),
+		PriorTokens:   s.countTokens(budget.prior),
+		Messages:      []models.MessageTokens{},
+		Chunks:        []models.SummaryChunk{},
+	}
+	if plan.MaxTokens <= 0 {
+		plan.MaxTokens = 4096 - 100
+	}
+
+	for i, message := range chatMessages {
+		tokens := s.countTokens(message)
+		plan.Messages = append(plan.Messages, models.MessageTokens{
+			Index:  indexes[i],
+			Tokens: tokens,
+		})
+		plan.MessageCount++
+		plan.TotalTokens += tokens
+	}
+
+	entries := s.chunkEntries(chatMessages, opts.SceneStarts, budget.maxTokens)
+	chunks := s.chunkMessages(entries, budget.maxTokens)
+	single := len(chunks) == 1
+	for _, chunk := range chunks {
+		// A chat that fits in one chunk is summarized directly with the word limit, as in SummarizeChat
+		passage, wordLimit := true, 0
+		if single {
+			passage, wordLimit = false, budget.wordLimit
+		}
+
+		summaryChunk := models.SummaryChunk{
+			Tokens:       chunk.tokens,
+			PromptTokens: s.countTokens(buildSummaryPrompt(budget.background, budget.prior, joinChunk(entries, chunk), passage, wordLimit)),
+		}
+		summaryChunk.Start = indexes[entries[chunk.start].source]
+		summaryChunk.End = indexes[entries[chunk.end-1].source]
+		previous := -1
+		for _, entry := range entries[chunk.start:chunk.end] {
+			if entry.source != previous {
+				summaryChunk.Messages++
+			}
+			previous = entry.source
+		}
+
+		plan.Chunks = append(plan.Chunks, summaryChunk)
+		plan.InputTokens += summaryChunk.PromptTokens
+	}
+
+	plan.LLMCalls = len(plan.Chunks)
+	if len(plan.Chunks) > 1 {
+		// Partial summaries have no word limit, assume each is about as long as the final one (~4 tokens per 3 words)
+		plan.LLMCalls++
+		partialTokens := len(plan.Chunks) * budget.wordLimit * 4 / 3
+		plan.InputTokens += s.countTokens(buildSummaryPrompt(budget.background, budget.prior, "", false, budget.wordLimit)) + partialTokens
+	}
+
+	return plan, nil
+}
+
+// fitContext joins the context entries, truncating each to an equal share of the token budget
+func (s *OllamaService) fitContext(entries []string, budget int) string {
+	if len(entries) == 0 || budget <= 0 {
+		return ""
+	}
+
+	perEntry := budget / len(entries)
+	fitted := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		fitted = append(fitted, s.truncateTokens(entry, perEntry))
+	}
+
+	return strings.Join(fitted, "\n\n")
+}
+
+// truncateTokens cuts text down to at most maxTokens tokens
+func (s *OllamaService) truncateTokens(text string, maxTokens int) string {
+	if maxTokens <= 0 {
+		return ""
+	}
+	ids, _, err := s.tokenEncoder.Encode(text)
+	if err != nil || len(ids) <= maxTokens {
+		return text
+	}
+
+	truncated, err := s.tokenEncoder.Decode(ids[:maxTokens])
+	if err != nil {
+		return text
+	}
+
+	return strings.TrimSpace(truncated) + "…"
 }
 
 // Helper: Count Tokens (stub, replace logic to count tokens based on your tokenizer)
@@ -164,7 +417,7 @@ func (s *OllamaService) countTokens(message string) int {
 	return len(ids)
 }
 
-func (s *OllamaService) callSummarizer(model string, input string, passage bool, wordLimit int) (string, error) {
+func buildSummaryPrompt(background string, prior string, input string, passage bool, wordLimit int) string {
 	instructions := "Please provide a concise summary of the following story. Your response should include nothing but the summary."
 	if passage {
 		instructions = "Below are summaries of different passages of a story, please provide a combined summary. Your response should include nothing but the summary."
@@ -175,15 +428,108 @@ func (s *OllamaService) callSummarizer(model string, input string, passage bool,
 		wordLimitStr = fmt.Sprintf(" (generate roughly %d words)", wordLimit)
 	}
 
-	prompt := fmt.Sprintf(`%s Focus on the main topics discussed, key events, and important interactions between participants.%s
+	backgroundStr := ""
+	if background != "" {
+		backgroundStr = fmt.Sprintf(`
+
+Background on the participants and setting (use it to keep names and relationships straight, do not summarize it):
+%s`, background)
+	}
+
+	priorStr := ""
+	if prior != "" {
+		priorStr = fmt.Sprintf(`
+
+Summary of the story up to this point (stay consistent with it, do not repeat it):
+%s`, prior)
+	}
+
+	return fmt.Sprintf(`%s Focus on the main topics discussed, key events, and important interactions between participants.%s%s%s
 
 Chat conversation:
 %s
 
-Please summarize:`, instructions, wordLimitStr, input)
+Please summarize:`, instructions, wordLimitStr, backgroundStr, priorStr, input)
+}
+
+func buildStoryPrompt(background string, story string, input string, wordLimit int) string {
+	backgroundStr := ""
+	if background != "" {
+		backgroundStr = fmt.Sprintf(`
+
+Background on the participants and setting (use it to keep names and relationships straight, do not summarize it):
+%s`, background)
+	}
+
+	storyStr := ""
+	if story != "" {
+		storyStr = fmt.Sprintf(`
+
+Story so far, from earlier chats (continue it with the chats below, keep what still matters):
+%s`, story)
+	}
+
+	return fmt.Sprintf(`Below are summaries of the chats of one ongoing story, oldest first. Some chats are branches: they repeat the start of an earlier chat and then continue differently. Please combine them into a single "story so far" to read before continuing the story. Follow the events in order and, where branches disagree, follow the most recent one. Your response should include nothing but the story so far (generate roughly %d words).%s%s
+
+Chat summaries:
+%s
+
+Please write the story so far:`, wordLimit, backgroundStr, storyStr, input)
+}
+
+// callSummarizer summarizes one chunk, chunk is its index among the chunks of the chat (the consolidation of the
+// partial summaries comes after the last one)
+func (s *OllamaService) callSummarizer(ctx context.Context, model string, chunk int, background string, prior string, input string, passage bool, wordLimit int) (summary string, err error) {
+	prompt := buildSummaryPrompt(background, prior, input, passage, wordLimit)
+
+	ctx, span := tracing.Start(ctx, "callSummarizer",
+		attribute.String("ollama.model", model),
+		attribute.Int("summary.chunk", chunk),
+		attribute.Bool("summary.passage", passage),
+		attribute.Int("summary.input_tokens", s.countTokens(input)),
+		attribute.Int("summary.prompt_tokens", s.countTokens(prompt)))
+	defer func() {
+		span.SetAttributes(attribute.Int("summary.output_tokens", s.countTokens(summary)))
+		tracing.End(span, err)
+	}()
+
+	return s.chat(ctx, model, prompt, "")
+}
+
+// chat sends a single user prompt to Ollama and returns the response content.
+// format may be "json" to constrain the response to valid JSON.
+func (s *OllamaService) chat(ctx context.Context, model string, prompt string, format string) (string, error) {
+	ctx, span := tracing.Start(ctx, "ollama.chat",
+		attribute.String("ollama.model", model),
+		attribute.String("ollama.format", format))
+	defer span.End()
+
+	start := time.Now()
+	response, err := s.post(ctx, model, prompt, format)
+	duration := time.Since(start)
+	metrics.OllamaDuration.Observe(duration.Seconds())
+	if err != nil {
+		metrics.OllamaErrors.Inc()
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		slog.ErrorContext(ctx, "ollama request failed", "model", model, "duration", duration, "error", err)
+		return "", err
+	}
 
-	fmt.Println(prompt)
+	metrics.OllamaTokens.WithLabelValues("prompt").Add(float64(response.PromptEvalCount))
+	metrics.OllamaTokens.WithLabelValues("response").Add(float64(response.EvalCount))
+	span.SetAttributes(
+		attribute.Int("ollama.prompt_eval_count", response.PromptEvalCount),
+		attribute.Int("ollama.eval_count", response.EvalCount))
+
+	content := response.Message.Content
+	slog.DebugContext(ctx, "ollama request", "model", model, "format", format, "duration", duration,
+		"prompt_tokens", response.PromptEvalCount, "response_tokens", response.EvalCount,
+		logging.Content("prompt", prompt), logging.Content("response", content))
+	return content, nil
+}
 
+func (s *OllamaService) post(ctx context.Context, model string, prompt string, format string) (*ollamaResponse, error) {
 	// Prepare request
 	reqBody := ollamaRequest{
 		Model: model,
@@ -194,32 +540,40 @@ Please summarize:`, instructions, wordLimitStr, input)
 			},
 		},
 		Stream: false,
+		Format: format,
 	}
 
 	reqJSON, err := json.Marshal(reqBody)
 	if err != nil {
-		return "", fmt.Errorf("failed to marshal request: %w", err)
+		return nil, fmt.Errorf("failed to marshal request: %w", err)
 	}
 
-	// Make request to Ollama
-	resp, err := s.client.Post(
-		fmt.Sprintf("%s/api/chat", config.GetOllamaBaseURL()),
-		"application/json",
-		bytes.NewBuffer(reqJSON),
-	)
+	// Make request to Ollama, passing on the request ID so its logs can be matched with ours
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, fmt.Sprintf("%s/api/chat", config.GetOllamaBaseURL()), bytes.NewBuffer(reqJSON))
 	if err != nil {
-		return "", fmt.Errorf("failed to make request to Ollama: %w", err)
+		return nil, fmt.Errorf("failed to create request to Ollama: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if id := logging.RequestID(ctx); id != "" {
+		req.Header.Set("X-Request-ID", id)
+	}
+	// A traceparent header lets a traced proxy in front of Ollama join the trace
+	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(req.Header))
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to make request to Ollama: %w", err)
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		return "", fmt.Errorf("ollama API returned status code: %d", resp.StatusCode)
+		return nil, fmt.Errorf("ollama API returned status code: %d", resp.StatusCode)
 	}
 
 	var ollamaResp ollamaResponse
 	if err := json.NewDecoder(resp.Body).Decode(&ollamaResp); err != nil {
-		return "", fmt.Errorf("failed to decode Ollama response: %w", err)
+		return nil, fmt.Errorf("failed to decode Ollama response: %w", err)
 	}
 
-	return ollamaResp.Message.Content, nil
+	return &ollamaResp, nil
 }
@@ -0,0 +1,117 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"craigstjean.com/stsummarizer/internal/models"
+)
+
+// sendDateLayouts are the formats SillyTavern has used for send_date, parsed in the server's local time
+var sendDateLayouts = []string{
+	"January 2, 2006 3:04pm",
+	"January 2, 2006 3:04 pm",
+	"2006-01-02 @15h 04m 05s",
+	"2006-01-02@15h04m05s",
+	time.RFC3339Nano,
+}
+
+// ParseSendDate reads a message's send_date, which may be a formatted string or epoch milliseconds
+func ParseSendDate(raw json.RawMessage) (time.Time, bool) {
+	if len(raw) == 0 {
+		return time.Time{}, false
+	}
+
+	var millis int64
+	if err := json.Unmarshal(raw, &millis); err == nil {
+		return time.UnixMilli(millis), true
+	}
+
+	var value string
+	if err := json.Unmarshal(raw, &value); err != nil {
+		return time.Time{}, false
+	}
+	value = strings.TrimSpace(value)
+	// Go layouts cannot express the milliseconds of "2024-05-01 @14h 03m 22s 123ms"
+	if i := strings.LastIndex(value, " "); i > 0 && strings.HasSuffix(value, "ms") {
+		value = value[:i]
+	}
+
+	for _, layout := range sendDateLayouts {
+		if t, err := time.ParseInLocation(layout, value, time.Local); err == nil {
+			return t, true
+		}
+	}
+
+	return time.Time{}, false
+}
+
+// ParseRangeDate reads a since/until parameter. A date without a time covers the whole day, so until=2025-02-11
+// includes messages sent on the 11th.
+func ParseRangeDate(value string, endOfDay bool) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	if t, err := time.ParseInLocation("2006-01-02T15:04", value, time.Local); err == nil {
+		return t, nil
+	}
+	if t, err := time.ParseInLocation("2006-01-02", value, time.Local); err == nil {
+		if endOfDay {
+			t = t.AddDate(0, 0, 1).Add(-time.Nanosecond)
+		}
+		return t, nil
+	}
+
+	return time.Time{}, fmt.Errorf("invalid date: %s (expected YYYY-MM-DD, YYYY-MM-DDTHH:MM or RFC 3339)", value)
+}
+
+// selectMessages returns the messages within the request's index and date range.
+// Messages without a readable send_date take the date of the message before them.
+func selectMessages(messages []models.ChatMessage, req SummaryRequest) ([]models.ChatMessage, error) {
+	if !req.hasRange() {
+		return messages, nil
+	}
+
+	start := 0
+	end := len(messages)
+	if req.From != nil {
+		start = *req.From
+	}
+	if req.To != nil {
+		end = min(*req.To+1, end) // To is inclusive
+	}
+	if start >= end {
+		return nil, fmt.Errorf("invalid range: no messages between %d and %d (the chat has %d)", start, end-1, len(messages))
+	}
+
+	if req.Since == nil && req.Until == nil {
+		return messages[start:end], nil
+	}
+
+	var selected []models.ChatMessage
+	var sent time.Time
+	for i, message := range messages {
+		if t, ok := ParseSendDate(message.SendDate); ok {
+			sent = t
+		}
+		if i < start || i >= end || sent.IsZero() {
+			continue
+		}
+		if req.Since != nil && sent.Before(*req.Since) || req.Until != nil && sent.After(*req.Until) {
+			continue
+		}
+		selected = append(selected, message)
+	}
+
+	if len(selected) == 0 {
+		return nil, fmt.Errorf("invalid range: no messages were sent in the requested period")
+	}
+
+	return selected, nil
+}
+
+func (r SummaryRequest) hasRange() bool {
+	return r.From != nil || r.To != nil || r.Since != nil || r.Until != nil
+}
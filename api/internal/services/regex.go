@@ -0,0 +1,256 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"craigstjean.com/stsummarizer/internal/models"
+)
+
+// Regex modes select which SillyTavern regex scripts are applied
+const (
+	// RegexDisplay applies the scripts that only change how messages are shown (markdownOnly)
+	RegexDisplay = "display"
+	// RegexPrompt applies the scripts that only change what is sent to the model (promptOnly)
+	RegexPrompt = "prompt"
+)
+
+var (
+	matchMacroPattern = regexp.MustCompile(`(?i)\{\{match\}\}`)
+	groupRefPattern   = regexp.MustCompile(`\$(\d+)|\$<([^>]+)>`)
+)
+
+// ParseRegexMode validates a regex mode, an empty value means no scripts are applied
+func ParseRegexMode(value string) (string, error) {
+	switch value {
+	case "", RegexDisplay, RegexPrompt:
+		return value, nil
+	default:
+		return "", fmt.Errorf("invalid regex mode: %s (expected %s or %s)", value, RegexDisplay, RegexPrompt)
+	}
+}
+
+type regexScript struct {
+	models.RegexScript
+	pattern *regexp.Regexp
+	global  bool
+}
+
+// ApplyRegexScripts runs the scripts selected by mode over the active swipe of each message, as SillyTavern does
+// when it renders or builds a prompt. Scripts without markdownOnly or promptOnly are skipped: SillyTavern applied
+// them when the message was saved. start is the index of the first message and total the length of the chat, used
+// for the scripts' depth limits (depth 0 is the last message).
+func ApplyRegexScripts(messages []models.ChatMessage, scripts []models.RegexScript, mode string, start, total int) []models.ChatMessage {
+	if mode == "" || len(scripts) == 0 {
+		return messages
+	}
+
+	userName, characterName := chatNames(messages)
+	compiled := compileRegexScripts(scripts, mode, userName, characterName)
+	if len(compiled) == 0 {
+		return messages
+	}
+
+	result := make([]models.ChatMessage, len(messages))
+	for i, message := range messages {
+		placement := models.RegexPlacementAIOutput
+		speaker := message.Name
+		if message.IsUser {
+			placement = models.RegexPlacementUserInput
+			speaker = characterName
+		}
+		depth := total - 1 - (start + i)
+
+		text := ActiveSwipe(message)
+		for _, script := range compiled {
+			if !script.applies(placement, depth) {
+				continue
+			}
+			text = script.run(text, userName, speaker)
+		}
+
+		result[i] = withActiveSwipe(message, text)
+	}
+
+	return result
+}
+
+func compileRegexScripts(scripts []models.RegexScript, mode, userName, characterName string) []regexScript {
+	var compiled []regexScript
+	for _, script := range scripts {
+		if mode == RegexDisplay && !script.MarkdownOnly || mode == RegexPrompt && !script.PromptOnly {
+			continue
+		}
+
+		find := script.FindRegex
+		switch substituteMode(script.SubstituteRegex) {
+		case 1:
+			find = replaceNameMacros(find, userName, characterName)
+		case 2:
+			find = replaceNameMacros(find, regexp.QuoteMeta(userName), regexp.QuoteMeta(characterName))
+		}
+
+		pattern, global, err := compileJSRegex(find)
+		if err != nil {
+			slog.Warn("regex script skipped", "script", script.ScriptName, "error", err)
+			continue
+		}
+
+		compiled = append(compiled, regexScript{
+			RegexScript: script,
+			pattern:     pattern,
+			global:      global,
+		})
+	}
+
+	return compiled
+}
+
+// compileJSRegex converts a JavaScript regular expression literal to Go. Features RE2 lacks, such as lookarounds
+// and backreferences, make the script fail to compile.
+func compileJSRegex(literal string) (*regexp.Regexp, bool, error) {
+	source := literal
+	flags := ""
+	if strings.HasPrefix(literal, "/") {
+		if end := strings.LastIndex(literal, "/"); end > 0 {
+			source = literal[1:end]
+			flags = literal[end+1:]
+		}
+	}
+
+	goFlags := ""
+	global := false
+	for _, flag := range flags {
+		switch flag {
+		case 'g':
+			global = true
+		case 'i', 'm', 's':
+			goFlags += string(flag)
+		}
+	}
+	if goFlags != "" {
+		source = "(?" + goFlags + ")" + source
+	}
+
+	pattern, err := regexp.Compile(source)
+	return pattern, global, err
+}
+
+func substituteMode(raw json.RawMessage) int {
+	var mode int
+	if err := json.Unmarshal(raw, &mode); err == nil {
+		return mode
+	}
+
+	var enabled bool
+	if err := json.Unmarshal(raw, &enabled); err == nil && enabled {
+		return 1
+	}
+
+	return 0
+}
+
+func (s regexScript) applies(placement, depth int) bool {
+	placed := false
+	for _, p := range s.Placement {
+		placed = placed || p == placement
+	}
+	if !placed {
+		return false
+	}
+
+	if s.MinDepth != nil && *s.MinDepth >= -1 && depth < *s.MinDepth {
+		return false
+	}
+	if s.MaxDepth != nil && *s.MaxDepth >= 0 && depth > *s.MaxDepth {
+		return false
+	}
+
+	return true
+}
+
+// run replaces the first match, or every match for global scripts. In the replacement {{match}} and $0 are the whole
+// match, $1 or $<name> a group with the trim strings removed, and {{user}}/{{char}} are substituted last.
+func (s regexScript) run(text, userName, characterName string) string {
+	limit := 1
+	if s.global {
+		limit = -1
+	}
+
+	matches := s.pattern.FindAllStringSubmatchIndex(text, limit)
+	if len(matches) == 0 {
+		return text
+	}
+
+	replacement := matchMacroPattern.ReplaceAllString(s.ReplaceString, "$$0")
+	names := s.pattern.SubexpNames()
+
+	var sb strings.Builder
+	last := 0
+	for _, match := range matches {
+		sb.WriteString(text[last:match[0]])
+
+		group := func(i int) string {
+			if i*2+1 >= len(match) || match[i*2] < 0 {
+				return ""
+			}
+			value := text[match[i*2]:match[i*2+1]]
+			for _, trim := range s.TrimStrings {
+				value = strings.ReplaceAll(value, replaceNameMacros(trim, userName, characterName), "")
+			}
+			return value
+		}
+
+		expanded := groupRefPattern.ReplaceAllStringFunc(replacement, func(ref string) string {
+			parts := groupRefPattern.FindStringSubmatch(ref)
+			if parts[1] != "" {
+				i, _ := strconv.Atoi(parts[1])
+				return group(i)
+			}
+			for i, name := range names {
+				if name == parts[2] {
+					return group(i)
+				}
+			}
+			return ""
+		})
+
+		sb.WriteString(replaceNameMacros(expanded, userName, characterName))
+		last = match[1]
+	}
+	sb.WriteString(text[last:])
+
+	return sb.String()
+}
+
+// chatNames returns the first user name and the first character name in the messages
+func chatNames(messages []models.ChatMessage) (string, string) {
+	userName := ""
+	characterName := ""
+	for _, message := range messages {
+		if message.IsUser && userName == "" {
+			userName = message.Name
+		} else if !message.IsUser && characterName == "" && message.Name != "" {
+			characterName = message.Name
+		}
+	}
+
+	return userName, characterName
+}
+
+// withActiveSwipe returns a copy of the message whose active swipe, and mes, is text
+func withActiveSwipe(message models.ChatMessage, text string) models.ChatMessage {
+	message.Message = text
+	if message.SwipeID != nil && *message.SwipeID >= 0 && *message.SwipeID < len(message.Swipes) {
+		swipes := make([]string, len(message.Swipes))
+		copy(swipes, message.Swipes)
+		swipes[*message.SwipeID] = text
+		message.Swipes = swipes
+	}
+
+	return message
+}
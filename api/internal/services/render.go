@@ -0,0 +1,36 @@
+package services
+
+import (
+	"fmt"
+
+	"craigstjean.com/stsummarizer/internal/models"
+)
+
+// RenderMessagesForSummary renders each message with a name and text as "**Name**: text" for the summarizer,
+// using the active swipe of each message
+func RenderMessagesForSummary(messages []models.ChatMessage) []string {
+	renderedMessages, _ := renderMessagesWithIndexes(messages)
+	return renderedMessages
+}
+
+// renderMessagesWithIndexes renders the messages as RenderMessagesForSummary does and also returns the chat index of
+// the message behind each rendered entry
+func renderMessagesWithIndexes(messages []models.ChatMessage) ([]string, []int) {
+	renderedMessages := make([]string, 0, len(messages))
+	indexes := make([]int, 0, len(messages))
+
+	for _, message := range messages {
+		userSuffix := ""
+		if message.IsUser {
+			userSuffix = " (User)"
+		}
+
+		text := ActiveSwipe(message)
+		if message.Name != "" && text != "" {
+			renderedMessages = append(renderedMessages, fmt.Sprintf("**%s**%s: %s", message.Name, userSuffix, text))
+			indexes = append(indexes, message.Index)
+		}
+	}
+
+	return renderedMessages, indexes
+}
@@ -0,0 +1,345 @@
+package rules
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"craigstjean.com/stsummarizer/internal/config"
+	"craigstjean.com/stsummarizer/internal/logging"
+	"craigstjean.com/stsummarizer/internal/models"
+	"craigstjean.com/stsummarizer/internal/services"
+	"craigstjean.com/stsummarizer/internal/services/watcher"
+)
+
+const (
+	// quietPeriod is how long a chat must go unchanged before it is evaluated, so rules never run mid-conversation
+	quietPeriod = 30 * time.Second
+	// pollInterval is how often chat files are checked for changes the watcher may have missed
+	pollInterval = time.Minute
+	// MetadataKey is the chat_metadata key summaries are written under when WriteMetadata is set
+	MetadataKey = "stsummarizer"
+)
+
+type metadataSummary struct {
+	Summary      string    `json:"summary"`
+	MessageCount int       `json:"message_count"`
+	Updated      time.Time `json:"updated"`
+}
+
+// Scheduler evaluates the summary rules whenever their chats change and regenerates summaries past the thresholds
+type Scheduler struct {
+	store      *Store
+	summarizer *services.Summarizer
+	stService  services.SillyTavernService
+	watcher    *watcher.Watcher
+
+	mu       sync.Mutex
+	lastSeen map[string]models.ChatFileInfo // rule ID -> chat file state at the last evaluation
+	timers   map[string]*time.Timer
+
+	// runMu serializes summarization so rules never compete for Ollama
+	runMu sync.Mutex
+
+	done chan struct{}
+}
+
+// NewScheduler creates a scheduler, chatWatcher may be nil in which case changes are only found by polling
+func NewScheduler(store *Store, summarizer *services.Summarizer, stService services.SillyTavernService, chatWatcher *watcher.Watcher) *Scheduler {
+	return &Scheduler{
+		store:      store,
+		summarizer: summarizer,
+		stService:  stService,
+		watcher:    chatWatcher,
+		lastSeen:   make(map[string]models.ChatFileInfo),
+		timers:     make(map[string]*time.Timer),
+		done:       make(chan struct{}),
+	}
+}
+
+func (s *Scheduler) Start() {
+	go s.poll()
+
+	if s.watcher != nil {
+		events, unsubscribe := s.watcher.Subscribe()
+		go func() {
+			defer unsubscribe()
+			for {
+				select {
+				case <-s.done:
+					return
+				case event, ok := <-events:
+					if !ok {
+						return
+					}
+					s.handleEvent(event)
+				}
+			}
+		}()
+	}
+}
+
+func (s *Scheduler) Stop() {
+	close(s.done)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, timer := range s.timers {
+		timer.Stop()
+	}
+}
+
+func (s *Scheduler) Rules() []models.SummaryRule {
+	return s.store.List()
+}
+
+func (s *Scheduler) Rule(id string) (models.SummaryRule, error) {
+	return s.store.Get(id)
+}
+
+// CreateRule stores a new rule. Its baseline is the cached summary of the chat, when there is one, so a chat that
+// was summarized before is not summarized again until it grows past the thresholds.
+func (s *Scheduler) CreateRule(rule models.SummaryRule) (models.SummaryRule, error) {
+	if rule.User == "" {
+		rule.User = config.STDefaultUser
+	}
+	if rule.Group {
+		rule.Character = ""
+	}
+
+	if _, err := s.chatInfo(rule.ChatRef); err != nil {
+		return models.SummaryRule{}, err
+	}
+
+	rule.LastMessageCount = 0
+	rule.LastTokenCount = 0
+	rule.LastRun = time.Time{}
+	rule.LastError = ""
+	if cached, err := s.summarizer.Cached(rule.ChatRef); err == nil && cached != nil {
+		rule.LastMessageCount = cached.MessageCount
+		rule.LastTokenCount = cached.TokenCount
+		rule.LastRun = cached.CreatedAt
+	}
+
+	created, err := s.store.Create(rule)
+	if err != nil {
+		return models.SummaryRule{}, err
+	}
+
+	s.schedule(created.ID)
+	return created, nil
+}
+
+func (s *Scheduler) UpdateRule(id string, rule models.SummaryRule) (models.SummaryRule, error) {
+	existing, err := s.store.Get(id)
+	if err != nil {
+		return models.SummaryRule{}, err
+	}
+
+	// The chat a rule watches cannot change, its run state belongs to that chat
+	rule.ChatRef = existing.ChatRef
+
+	updated, err := s.store.Update(id, rule)
+	if err != nil {
+		return models.SummaryRule{}, err
+	}
+
+	s.schedule(id)
+	return updated, nil
+}
+
+func (s *Scheduler) DeleteRule(id string) error {
+	if err := s.store.Delete(id); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if timer, ok := s.timers[id]; ok {
+		timer.Stop()
+		delete(s.timers, id)
+	}
+	delete(s.lastSeen, id)
+
+	return nil
+}
+
+// RunRule regenerates the rule's summary now, regardless of its thresholds
+func (s *Scheduler) RunRule(id string) (models.SummaryRule, error) {
+	if err := s.evaluate(id, true); err != nil {
+		return models.SummaryRule{}, err
+	}
+
+	return s.store.Get(id)
+}
+
+func (s *Scheduler) poll() {
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+			for _, rule := range s.store.List() {
+				if !rule.Enabled {
+					continue
+				}
+
+				info, err := s.chatInfo(rule.ChatRef)
+				if err != nil {
+					continue
+				}
+
+				s.mu.Lock()
+				lastSeen, seen := s.lastSeen[rule.ID]
+				s.mu.Unlock()
+
+				changed := !seen || !lastSeen.ModTime.Equal(info.ModTime) || lastSeen.Size != info.Size
+				if changed && time.Since(info.ModTime) >= quietPeriod {
+					s.schedule(rule.ID)
+				}
+			}
+		}
+	}
+}
+
+func (s *Scheduler) handleEvent(event models.ChatEvent) {
+	if event.Type != models.ChatUpdated && event.Type != models.GroupChatUpdated {
+		return
+	}
+
+	for _, rule := range s.store.List() {
+		if !rule.Enabled || rule.User != event.User || rule.Chat != event.Chat {
+			continue
+		}
+		if rule.Group != (event.Type == models.GroupChatUpdated) || !rule.Group && rule.Character != event.Character {
+			continue
+		}
+
+		s.schedule(rule.ID)
+	}
+}
+
+// schedule evaluates the rule once its chat has been quiet for quietPeriod, restarting the wait on every call
+func (s *Scheduler) schedule(id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if timer, ok := s.timers[id]; ok {
+		timer.Reset(quietPeriod)
+		return
+	}
+
+	s.timers[id] = time.AfterFunc(quietPeriod, func() {
+		s.mu.Lock()
+		delete(s.timers, id)
+		s.mu.Unlock()
+
+		if err := s.evaluate(id, false); err != nil {
+			slog.Warn("summary rule failed", "rule", id, "error", err)
+		}
+	})
+}
+
+// evaluate regenerates the summary when the chat has grown past the rule's thresholds, or always when force is set
+func (s *Scheduler) evaluate(id string, force bool) error {
+	s.runMu.Lock()
+	defer s.runMu.Unlock()
+
+	rule, err := s.store.Get(id)
+	if err != nil {
+		return err
+	}
+	if !rule.Enabled && !force {
+		return nil
+	}
+
+	info, err := s.chatInfo(rule.ChatRef)
+	if err != nil {
+		return s.recordError(id, err)
+	}
+
+	s.mu.Lock()
+	s.lastSeen[id] = *info
+	s.mu.Unlock()
+
+	messageCount, tokenCount, err := s.summarizer.Measure(rule.ChatRef, rule.Visibility)
+	if err != nil {
+		return s.recordError(id, err)
+	}
+
+	// Messages were deleted, start counting from the shorter chat
+	if messageCount < rule.LastMessageCount && !force {
+		return s.store.updateState(id, func(r *models.SummaryRule) {
+			r.LastMessageCount = messageCount
+			r.LastTokenCount = tokenCount
+		})
+	}
+
+	due := rule.EveryMessages > 0 && messageCount-rule.LastMessageCount >= rule.EveryMessages ||
+		rule.EveryTokens > 0 && tokenCount-rule.LastTokenCount >= rule.EveryTokens
+	if !due && !force {
+		return nil
+	}
+
+	ctx := logging.WithRequestID(context.Background(), "rule-"+id)
+	summary, _, err := s.summarizer.Summarize(ctx, services.SummaryRequest{
+		ChatRef:       rule.ChatRef,
+		Model:         rule.Model,
+		MaxTokens:     rule.MaxTokens,
+		WordLimit:     rule.SummaryWords,
+		IncludeCards:  rule.IncludeCards,
+		ContextTokens: config.Get().Summary.ContextTokens,
+		Visibility:    rule.Visibility,
+	})
+	if err != nil {
+		return s.recordError(id, err)
+	}
+
+	if rule.WriteMetadata && len(summary.Summaries) > 0 {
+		value := metadataSummary{
+			Summary:      summary.Summaries[len(summary.Summaries)-1],
+			MessageCount: summary.MessageCount,
+			Updated:      summary.CreatedAt,
+		}
+
+		if rule.Group {
+			err = s.stService.SetGroupChatMetadata(rule.User, rule.Chat, MetadataKey, value)
+		} else {
+			err = s.stService.SetCharacterChatMetadata(rule.User, rule.Character, rule.Chat, MetadataKey, value)
+		}
+		if err != nil {
+			return s.recordError(id, fmt.Errorf("summary generated but not written to the chat: %w", err))
+		}
+	}
+
+	return s.store.updateState(id, func(r *models.SummaryRule) {
+		r.LastMessageCount = summary.MessageCount
+		r.LastTokenCount = summary.TokenCount
+		r.LastRun = summary.CreatedAt
+		r.LastError = ""
+	})
+}
+
+func (s *Scheduler) recordError(id string, err error) error {
+	if saveErr := s.store.updateState(id, func(r *models.SummaryRule) {
+		r.LastError = err.Error()
+	}); saveErr != nil {
+		slog.Error("summary rule not saved", "rule", id, "error", saveErr)
+	}
+
+	return err
+}
+
+func (s *Scheduler) chatInfo(ref models.ChatRef) (*models.ChatFileInfo, error) {
+	if ref.Group {
+		return s.stService.GetGroupChatInfo(ref.User, ref.Chat)
+	}
+
+	return s.stService.GetCharacterChatInfo(ref.User, ref.Character, ref.Chat)
+}
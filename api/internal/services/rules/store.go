@@ -0,0 +1,212 @@
+package rules
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"craigstjean.com/stsummarizer/internal/config"
+	"craigstjean.com/stsummarizer/internal/models"
+	"craigstjean.com/stsummarizer/internal/services"
+)
+
+// Store keeps the summary rules in memory and persists them to rules.json in the state directory
+type Store struct {
+	path  string
+	mu    sync.Mutex
+	rules map[string]*models.SummaryRule
+}
+
+func NewStore() (*Store, error) {
+	store := &Store{
+		path:  filepath.Join(config.GetStatePath(), "rules.json"),
+		rules: make(map[string]*models.SummaryRule),
+	}
+
+	content, err := os.ReadFile(store.path)
+	if os.IsNotExist(err) {
+		return store, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read rules: %w", err)
+	}
+
+	var rules []models.SummaryRule
+	if err := json.Unmarshal(content, &rules); err != nil {
+		return nil, fmt.Errorf("failed to parse rules: %w", err)
+	}
+
+	for i := range rules {
+		store.rules[rules[i].ID] = &rules[i]
+	}
+
+	return store, nil
+}
+
+func (s *Store) List() []models.SummaryRule {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	rules := make([]models.SummaryRule, 0, len(s.rules))
+	for _, rule := range s.rules {
+		rules = append(rules, *rule)
+	}
+
+	sort.Slice(rules, func(i, j int) bool {
+		return rules[i].ID < rules[j].ID
+	})
+
+	return rules
+}
+
+func (s *Store) Get(id string) (models.SummaryRule, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	rule, ok := s.rules[id]
+	if !ok {
+		return models.SummaryRule{}, fmt.Errorf("rule does not exist: %s", id)
+	}
+
+	return *rule, nil
+}
+
+// Create stores a new rule under a generated ID
+func (s *Store) Create(rule models.SummaryRule) (models.SummaryRule, error) {
+	if err := validate(rule); err != nil {
+		return models.SummaryRule{}, err
+	}
+
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return models.SummaryRule{}, fmt.Errorf("failed to generate rule id: %w", err)
+	}
+	rule.ID = hex.EncodeToString(id)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.rules[rule.ID] = &rule
+	if err := s.save(); err != nil {
+		delete(s.rules, rule.ID)
+		return models.SummaryRule{}, err
+	}
+
+	return rule, nil
+}
+
+// Update replaces the settings of a rule, keeping its run state
+func (s *Store) Update(id string, rule models.SummaryRule) (models.SummaryRule, error) {
+	if err := validate(rule); err != nil {
+		return models.SummaryRule{}, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	existing, ok := s.rules[id]
+	if !ok {
+		return models.SummaryRule{}, fmt.Errorf("rule does not exist: %s", id)
+	}
+
+	previous := *existing
+	rule.ID = id
+	rule.LastMessageCount = existing.LastMessageCount
+	rule.LastTokenCount = existing.LastTokenCount
+	rule.LastRun = existing.LastRun
+	rule.LastError = existing.LastError
+
+	*existing = rule
+	if err := s.save(); err != nil {
+		*existing = previous
+		return models.SummaryRule{}, err
+	}
+
+	return rule, nil
+}
+
+func (s *Store) Delete(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	rule, ok := s.rules[id]
+	if !ok {
+		return fmt.Errorf("rule does not exist: %s", id)
+	}
+
+	delete(s.rules, id)
+	if err := s.save(); err != nil {
+		s.rules[id] = rule
+		return err
+	}
+
+	return nil
+}
+
+// updateState records the outcome of evaluating a rule
+func (s *Store) updateState(id string, update func(rule *models.SummaryRule)) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	rule, ok := s.rules[id]
+	if !ok {
+		return fmt.Errorf("rule does not exist: %s", id)
+	}
+
+	update(rule)
+	return s.save()
+}
+
+// save writes all rules, the caller must hold the lock
+func (s *Store) save() error {
+	rules := make([]*models.SummaryRule, 0, len(s.rules))
+	for _, rule := range s.rules {
+		rules = append(rules, rule)
+	}
+
+	sort.Slice(rules, func(i, j int) bool {
+		return rules[i].ID < rules[j].ID
+	})
+
+	content, err := json.MarshalIndent(rules, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode rules: %w", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(s.path), os.ModePerm); err != nil {
+		return fmt.Errorf("failed to create state directory: %w", err)
+	}
+
+	// Write to a temporary file first so a crash never leaves a truncated rules file
+	tmpPath := s.path + ".tmp"
+	if err := os.WriteFile(tmpPath, content, 0644); err != nil {
+		return fmt.Errorf("failed to write rules: %w", err)
+	}
+	if err := os.Rename(tmpPath, s.path); err != nil {
+		return fmt.Errorf("failed to write rules: %w", err)
+	}
+
+	return nil
+}
+
+func validate(rule models.SummaryRule) error {
+	if rule.Chat == "" {
+		return fmt.Errorf("invalid rule: chat is required")
+	}
+	if !rule.Group && rule.Character == "" {
+		return fmt.Errorf("invalid rule: character is required for character chats")
+	}
+	if rule.EveryMessages <= 0 && rule.EveryTokens <= 0 {
+		return fmt.Errorf("invalid rule: every_messages or every_tokens must be set")
+	}
+	if _, err := services.ParseVisibility(rule.Visibility, services.VisibilityPrompt); err != nil {
+		return fmt.Errorf("invalid rule: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,71 @@
+package services
+
+import (
+	"regexp"
+	"strings"
+	"time"
+
+	"craigstjean.com/stsummarizer/internal/models"
+)
+
+// DefaultSceneGap is the pause between two messages that starts a new scene when scene breaks are enabled
+const DefaultSceneGap = 6 * time.Hour
+
+var (
+	// sceneMarkerPattern matches a line that only holds a scene break such as ***, * * *, --- or ###
+	sceneMarkerPattern = regexp.MustCompile(`^(\*[ \t]*){3,}$|^([-_~=#])([ \t]*[-_~=#]){2,}$|^[⁂§]$`)
+	// timeSkipPattern matches a message that opens with a time skip
+	timeSkipPattern = regexp.MustCompile(`(?i)^[\s*_\[(]*(time ?skip|(a few |several |some )?(hours|days|weeks|months|years) later|(some|much) time later|later that (day|night|evening|morning)|the (next|following) (morning|day|evening|night|week))\b`)
+)
+
+// findSceneStarts returns the positions, among the messages rendered for the summarizer, of the messages that start a
+// new scene: those opening with a time skip or holding a scene marker, those after a message that ends with a marker,
+// and when gap is positive those sent at least gap after the previous message
+func findSceneStarts(messages []models.ChatMessage, gap time.Duration) []int {
+	var starts []int
+	position := 0
+	markerEnded := false
+	var previousDate time.Time
+
+	for _, message := range messages {
+		text := ActiveSwipe(message)
+		if message.Name == "" || text == "" {
+			continue
+		}
+
+		var lines []string
+		for _, line := range strings.Split(text, "\n") {
+			if line = strings.TrimSpace(line); line != "" {
+				lines = append(lines, line)
+			}
+		}
+
+		start := markerEnded || timeSkipPattern.MatchString(text)
+		markerEnded = false
+		for i, line := range lines {
+			if !sceneMarkerPattern.MatchString(line) {
+				continue
+			}
+			if i == len(lines)-1 {
+				markerEnded = true
+			} else {
+				start = true
+			}
+		}
+
+		sendDate, ok := ParseSendDate(message.SendDate)
+		if ok && gap > 0 && !previousDate.IsZero() && sendDate.Sub(previousDate) >= gap {
+			start = true
+		}
+		if ok {
+			previousDate = sendDate
+		}
+
+		if start && position > 0 {
+			starts = append(starts, position)
+		}
+		position++
+	}
+
+	return starts
+}
@@ -0,0 +1,75 @@
+package sillytavern
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/png"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"golang.org/x/image/draw"
+)
+
+// GetCharacterAvatar returns the character's PNG avatar scaled down to fit within width x height.
+// A width or height of 0 returns the original image.
+func (s *SillyTavernService) GetCharacterAvatar(user, character string, width, height int) ([]byte, time.Time, error) {
+	if user == "" {
+		user = s.defaultUser
+	}
+
+	cardPath, err := s.findCharacterCard(user, character)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+
+	if !strings.EqualFold(filepath.Ext(cardPath), ".png") {
+		return nil, time.Time{}, fmt.Errorf("character avatar does not exist: %s", character)
+	}
+
+	info, err := os.Stat(cardPath)
+	if err != nil {
+		return nil, time.Time{}, fmt.Errorf("failed to read character avatar: %w", err)
+	}
+
+	content, err := os.ReadFile(cardPath)
+	if err != nil {
+		return nil, time.Time{}, fmt.Errorf("failed to read character avatar: %w", err)
+	}
+
+	if width <= 0 || height <= 0 {
+		return content, info.ModTime(), nil
+	}
+
+	thumbnail, err := resizePNG(content, width, height)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+
+	return thumbnail, info.ModTime(), nil
+}
+
+func resizePNG(content []byte, width, height int) ([]byte, error) {
+	src, err := png.Decode(bytes.NewReader(content))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode avatar image: %w", err)
+	}
+
+	// Fit within the requested box, keeping the aspect ratio and never scaling up
+	bounds := src.Bounds()
+	scale := min(float64(width)/float64(bounds.Dx()), float64(height)/float64(bounds.Dy()), 1)
+	dstWidth := max(int(float64(bounds.Dx())*scale), 1)
+	dstHeight := max(int(float64(bounds.Dy())*scale), 1)
+
+	dst := image.NewRGBA(image.Rect(0, 0, dstWidth, dstHeight))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), src, bounds, draw.Over, nil)
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, dst); err != nil {
+		return nil, fmt.Errorf("failed to encode avatar thumbnail: %w", err)
+	}
+
+	return buf.Bytes(), nil
+}
@@ -1,8 +1,6 @@
 package sillytavern
 
 import (
-	"bufio"
-	"encoding/json"
 	"fmt"
 	"os"
 	"path/filepath"
@@ -78,68 +76,65 @@ func (s *SillyTavernService) GetCharacterBackups(user, character string) ([]stri
 }
 
 func (s *SillyTavernService) GetCharacterBackup(user, character, backup string) ([]models.ChatMessage, error) {
+	messages, _, err := s.readCharacterBackup(user, character, backup, false)
+	return messages, err
+}
+
+// GetCharacterBackupLenient reads the backup, skipping lines that cannot be parsed and returning them instead
+func (s *SillyTavernService) GetCharacterBackupLenient(user, character, backup string) ([]models.ChatMessage, []models.ChatLineError, error) {
+	return s.readCharacterBackup(user, character, backup, true)
+}
+
+func (s *SillyTavernService) readCharacterBackup(user, character, backup string, lenient bool) ([]models.ChatMessage, []models.ChatLineError, error) {
+	backupPath, err := s.characterBackupPath(user, character, backup)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	lines, skipped, err := readChatFile(backupPath, lenient)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Backups list only the messages, without the header. Each message keeps its Index, its position in the file, so
+	// it can still be matched with the paged view and validation reports.
+	var messages []models.ChatMessage
+	for _, message := range lines {
+		if message.Message != "" {
+			messages = append(messages, message)
+		}
+	}
+
+	return messages, skipped, nil
+}
+
+// characterBackupPath validates that the backup belongs to the character and returns its path
+func (s *SillyTavernService) characterBackupPath(user, character, backup string) (string, error) {
 	if user == "" {
 		user = s.defaultUser
 	}
 
 	// First validate the character path
 	if err := s.ValidateCharacterPath(user, character); err != nil {
-		return nil, err
+		return "", err
 	}
 
 	// Create backup directory path
 	backupsDir := filepath.Join(s.dataPath, user, s.backupsPath)
 
 	// Validate the backup filename
-	if !strings.HasPrefix(backup, "chat_") || !strings.HasSuffix(backup, ".jsonl") {
-		return nil, fmt.Errorf("invalid backup filename format")
+	if !strings.HasPrefix(backup, "chat_") || !strings.HasSuffix(backup, ".jsonl") || strings.ContainsAny(backup, `/\`) {
+		return "", fmt.Errorf("invalid backup filename format")
 	}
 
 	// Convert character name to safe format for validation
 	safeCharName := regexp.MustCompile(`[^a-zA-Z0-9]`).ReplaceAllString(strings.ToLower(character), "_")
 	expectedPrefix := fmt.Sprintf("chat_%s_", safeCharName)
 	if !strings.HasPrefix(backup, expectedPrefix) {
-		return nil, fmt.Errorf("backup filename does not match character")
-	}
-
-	// Read the backup file
-	backupPath := filepath.Join(backupsDir, backup)
-	file, err := os.Open(backupPath)
-	if err != nil {
-		return nil, fmt.Errorf("failed to open backup file: %w", err)
-	}
-	defer file.Close()
-
-	// Read the file line by line, as each line is a JSON object
-	var messages []models.ChatMessage
-	scanner := bufio.NewScanner(file)
-	lineNum := 0
-
-	// Read the file line by line
-	for scanner.Scan() {
-		lineNum++
-		line := scanner.Text()
-
-		// Skip empty lines
-		if strings.TrimSpace(line) == "" {
-			continue
-		}
-
-		var message models.ChatMessage
-		if err := json.Unmarshal([]byte(line), &message); err != nil {
-			return nil, fmt.Errorf("error parsing JSON at line %d: %w", lineNum, err)
-		}
-
-		if message.Message != "" {
-			messages = append(messages, message)
-		}
-	}
-
-	if err := scanner.Err(); err != nil {
-		return nil, fmt.Errorf("error reading file: %w", err)
+		return "", fmt.Errorf("backup filename does not match character")
 	}
 
-	return messages, nil
+	return filepath.Join(backupsDir, backup), nil
 }
 
 func (s *SillyTavernService) RestoreCharacterBackup(user, character, backup string) (string, error) {
@@ -174,6 +169,22 @@ func (s *SillyTavernService) RestoreCharacterBackup(user, character, backup stri
 		return "", fmt.Errorf("backup file not found")
 	}
 
+	newFileName, err := nextBranchName(chatsDir)
+	if err != nil {
+		return "", err
+	}
+
+	// Copy the backup file to the chat directory
+	destPath := filepath.Join(chatsDir, newFileName)
+	if err := copyFile(backupPath, destPath); err != nil {
+		return "", fmt.Errorf("failed to restore backup: %w", err)
+	}
+
+	return newFileName, nil
+}
+
+// nextBranchName creates the chat directory if needed and returns the file name for a new branch in it
+func nextBranchName(chatsDir string) (string, error) {
 	// Ensure chat directory exists
 	if err := os.MkdirAll(chatsDir, os.ModePerm); err != nil {
 		return "", fmt.Errorf("failed to create chat directory: %w", err)
@@ -185,34 +196,24 @@ func (s *SillyTavernService) RestoreCharacterBackup(user, character, backup stri
 		return "", fmt.Errorf("failed to read chat directory: %w", err)
 	}
 
-	var newFileName string
-
 	// Determine the new filename based on existing files
 	if len(files) == 0 { // No existing files
 		// Format: "yyyy-M-d @HHh mm'm' ss's' 000ms.jsonl"
-		newFileName = time.Now().Format("2006-1-2 @15h 04m 05s 000ms.jsonl")
-	} else { // There are existing files
-		highestBranch := 1
-		branchRegex := regexp.MustCompile(`Branch #(\d+) - `)
-
-		for _, file := range files {
-			match := branchRegex.FindStringSubmatch(file.Name())
-			if match != nil {
-				branch, err := strconv.Atoi(match[1])
-				if err == nil && branch > highestBranch {
-					highestBranch = branch
-				}
-			}
-		}
-
-		newFileName = fmt.Sprintf("Branch #%d - %s.jsonl", highestBranch+1, time.Now().Format("2006-01-02@15h04m05s"))
+		return time.Now().Format("2006-1-2 @15h 04m 05s 000ms.jsonl"), nil
 	}
 
-	// Copy the backup file to the chat directory
-	destPath := filepath.Join(chatsDir, newFileName)
-	if err := copyFile(backupPath, destPath); err != nil {
-		return "", fmt.Errorf("failed to restore backup: %w", err)
+	highestBranch := 1
+	branchRegex := regexp.MustCompile(`Branch #(\d+) - `)
+
+	for _, file := range files {
+		match := branchRegex.FindStringSubmatch(file.Name())
+		if match != nil {
+			branch, err := strconv.Atoi(match[1])
+			if err == nil && branch > highestBranch {
+				highestBranch = branch
+			}
+		}
 	}
 
-	return newFileName, nil
+	return fmt.Sprintf("Branch #%d - %s.jsonl", highestBranch+1, time.Now().Format("2006-01-02@15h04m05s")), nil
 }
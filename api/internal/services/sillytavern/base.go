@@ -5,9 +5,12 @@ import "craigstjean.com/stsummarizer/internal/config"
 type SillyTavernService struct {
 	dataPath       string
 	chatsPath      string
+	charactersPath string
 	groupsPath     string
 	groupChatsPath string
 	backupsPath    string
+	worldsPath     string
+	settingsFile   string
 	defaultUser    string
 }
 
@@ -15,9 +18,12 @@ func NewService() *SillyTavernService {
 	return &SillyTavernService{
 		dataPath:       config.GetSTDataPath(),
 		chatsPath:      config.STChatsPath,
+		charactersPath: config.STCharactersPath,
 		groupsPath:     config.STGroupsPath,
 		groupChatsPath: config.STGroupChatsPath,
 		backupsPath:    config.STBackupsPath,
+		worldsPath:     config.STWorldsPath,
+		settingsFile:   config.STSettingsFile,
 		defaultUser:    config.STDefaultUser,
 	}
 }
@@ -0,0 +1,301 @@
+package sillytavern
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"craigstjean.com/stsummarizer/internal/models"
+)
+
+var pngSignature = []byte{0x89, 'P', 'N', 'G', '\r', '\n', 0x1a, '\n'}
+
+// cardFields holds the fields shared by the V1 top level and the V2/V3 "data" object
+type cardFields struct {
+	Name                    string                 `json:"name"`
+	Description             string                 `json:"description"`
+	Personality             string                 `json:"personality"`
+	Scenario                string                 `json:"scenario"`
+	FirstMessage            string                 `json:"first_mes"`
+	MessageExamples         string                 `json:"mes_example"`
+	CreatorNotes            string                 `json:"creator_notes"`
+	SystemPrompt            string                 `json:"system_prompt"`
+	PostHistoryInstructions string                 `json:"post_history_instructions"`
+	AlternateGreetings      []string               `json:"alternate_greetings"`
+	Tags                    []string               `json:"tags"`
+	Creator                 string                 `json:"creator"`
+	CharacterVersion        string                 `json:"character_version"`
+	CharacterBook           *models.CharacterBook  `json:"character_book"`
+	Extensions              map[string]interface{} `json:"extensions"`
+}
+
+type rawCard struct {
+	cardFields
+	Spec        string      `json:"spec"`
+	SpecVersion string      `json:"spec_version"`
+	Data        *cardFields `json:"data"`
+}
+
+func (s *SillyTavernService) GetCharacterCard(user, character string) (*models.CharacterCard, error) {
+	if user == "" {
+		user = s.defaultUser
+	}
+
+	cardPath, err := s.findCharacterCard(user, character)
+	if err != nil {
+		return nil, err
+	}
+
+	return readCharacterCard(cardPath)
+}
+
+// findCharacterCard locates the card file for a character, preferring PNG cards over JSON cards
+func (s *SillyTavernService) findCharacterCard(user, character string) (string, error) {
+	// Prevent directory traversal attempts
+	if strings.Contains(user, "..") || strings.Contains(character, "..") || strings.ContainsAny(character, `/\`) {
+		return "", fmt.Errorf("invalid character name")
+	}
+
+	charactersDir := filepath.Join(s.dataPath, user, s.charactersPath)
+	for _, ext := range []string{".png", ".json"} {
+		cardPath := filepath.Join(charactersDir, character+ext)
+		if info, err := os.Stat(cardPath); err == nil && !info.IsDir() {
+			return cardPath, nil
+		}
+	}
+
+	return "", fmt.Errorf("character card does not exist: %s", character)
+}
+
+// cachedCard is a parsed card with the state of its file when it was read
+type cachedCard struct {
+	size    int64
+	modTime time.Time
+	card    *models.CharacterCard
+}
+
+var (
+	cardsMu sync.Mutex
+	cards   = make(map[string]cachedCard)
+)
+
+// readCharacterCard parses the card file. Cards are cached by path, size and modification time, so listing characters
+// does not parse every card again.
+func readCharacterCard(cardPath string) (*models.CharacterCard, error) {
+	file, err := os.Open(cardPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read character card: %w", err)
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read character card: %w", err)
+	}
+
+	cardsMu.Lock()
+	cached, ok := cards[cardPath]
+	cardsMu.Unlock()
+	if ok && cached.size == info.Size() && cached.modTime.Equal(info.ModTime()) {
+		return copyCharacterCard(cached.card), nil
+	}
+
+	var content []byte
+	if strings.EqualFold(filepath.Ext(cardPath), ".png") {
+		content, err = extractPNGCardData(file)
+	} else {
+		content, err = io.ReadAll(file)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	card, err := parseCharacterCard(content)
+	if err != nil {
+		return nil, err
+	}
+
+	cardsMu.Lock()
+	cards[cardPath] = cachedCard{size: info.Size(), modTime: info.ModTime(), card: card}
+	cardsMu.Unlock()
+
+	return copyCharacterCard(card), nil
+}
+
+// copyCharacterCard returns a deep copy of the card, so callers may change it without changing the cached one
+func copyCharacterCard(card *models.CharacterCard) *models.CharacterCard {
+	copied := *card
+	copied.AlternateGreetings = slices.Clone(card.AlternateGreetings)
+	copied.Tags = slices.Clone(card.Tags)
+	if card.CharacterBook != nil {
+		book := *card.CharacterBook
+		book.Entries = slices.Clone(book.Entries)
+		for i := range book.Entries {
+			book.Entries[i].Keys = slices.Clone(book.Entries[i].Keys)
+			book.Entries[i].SecondaryKeys = slices.Clone(book.Entries[i].SecondaryKeys)
+		}
+		copied.CharacterBook = &book
+	}
+	if card.Extensions != nil {
+		copied.Extensions = copyJSONValue(card.Extensions).(map[string]interface{})
+	}
+
+	return &copied
+}
+
+// copyJSONValue deep copies a value decoded from JSON into interface{}
+func copyJSONValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		copied := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			copied[key] = copyJSONValue(item)
+		}
+		return copied
+	case []interface{}:
+		copied := make([]interface{}, len(v))
+		for i, item := range v {
+			copied[i] = copyJSONValue(item)
+		}
+		return copied
+	default:
+		return v
+	}
+}
+
+// extractPNGCardData returns the decoded card JSON stored in the tEXt chunks of a PNG file. Other chunks, the image
+// data among them, are skipped without being read; SillyTavern writes its tEXt chunks after the image data.
+// The V3 "ccv3" chunk takes precedence over the V2 "chara" chunk, as in SillyTavern.
+func extractPNGCardData(file io.ReadSeeker) ([]byte, error) {
+	size, err := file.Seek(0, io.SeekEnd)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read character card: %w", err)
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return nil, fmt.Errorf("failed to read character card: %w", err)
+	}
+
+	signature := make([]byte, len(pngSignature))
+	if _, err := io.ReadFull(file, signature); err != nil || !bytes.Equal(signature, pngSignature) {
+		return nil, fmt.Errorf("character card is not a valid PNG file")
+	}
+
+	chunks := make(map[string]string)
+	reader := bufio.NewReader(file)
+	offset := int64(len(pngSignature))
+	for {
+		var header struct {
+			Length uint32
+			Type   [4]byte
+		}
+		if err := binary.Read(reader, binary.BigEndian, &header); err != nil {
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				break
+			}
+			return nil, fmt.Errorf("failed to read PNG chunk: %w", err)
+		}
+
+		chunkType := string(header.Type[:])
+		if chunkType == "IEND" {
+			break
+		}
+
+		offset += 8
+		if int64(header.Length) > size-offset {
+			return nil, fmt.Errorf("truncated PNG chunk %s", chunkType)
+		}
+
+		if chunkType != "tEXt" {
+			// Skip the data and the CRC
+			offset += int64(header.Length) + 4
+			if _, err := file.Seek(offset, io.SeekStart); err != nil {
+				return nil, fmt.Errorf("failed to read PNG chunk %s: %w", chunkType, err)
+			}
+			reader.Reset(file)
+			continue
+		}
+
+		data := make([]byte, header.Length)
+		if _, err := io.ReadFull(reader, data); err != nil {
+			return nil, fmt.Errorf("failed to read PNG chunk %s: %w", chunkType, err)
+		}
+
+		// Skip the CRC
+		if _, err := reader.Discard(4); err != nil && err != io.EOF {
+			return nil, fmt.Errorf("failed to read PNG chunk %s: %w", chunkType, err)
+		}
+		offset += int64(header.Length) + 4
+
+		keyword, text, found := bytes.Cut(data, []byte{0})
+		if !found {
+			continue
+		}
+		chunks[strings.ToLower(string(keyword))] = string(text)
+	}
+
+	for _, keyword := range []string{"ccv3", "chara"} {
+		encoded, ok := chunks[keyword]
+		if !ok {
+			continue
+		}
+
+		decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode %s chunk: %w", keyword, err)
+		}
+		return decoded, nil
+	}
+
+	return nil, fmt.Errorf("PNG file does not contain character card data")
+}
+
+func parseCharacterCard(content []byte) (*models.CharacterCard, error) {
+	var raw rawCard
+	if err := json.Unmarshal(content, &raw); err != nil {
+		return nil, fmt.Errorf("failed to parse character card: %w", err)
+	}
+
+	// V2 and V3 cards keep their definitions under "data", V1 cards at the top level
+	fields := raw.cardFields
+	spec := raw.Spec
+	specVersion := raw.SpecVersion
+	if raw.Data != nil {
+		fields = *raw.Data
+		if len(fields.Tags) == 0 {
+			fields.Tags = raw.Tags
+		}
+	} else {
+		spec = "chara_card_v1"
+		specVersion = "1.0"
+	}
+
+	return &models.CharacterCard{
+		Spec:                    spec,
+		SpecVersion:             specVersion,
+		Name:                    fields.Name,
+		Description:             fields.Description,
+		Personality:             fields.Personality,
+		Scenario:                fields.Scenario,
+		FirstMessage:            fields.FirstMessage,
+		MessageExamples:         fields.MessageExamples,
+		CreatorNotes:            fields.CreatorNotes,
+		SystemPrompt:            fields.SystemPrompt,
+		PostHistoryInstructions: fields.PostHistoryInstructions,
+		AlternateGreetings:      fields.AlternateGreetings,
+		Tags:                    fields.Tags,
+		Creator:                 fields.Creator,
+		CharacterVersion:        fields.CharacterVersion,
+		CharacterBook:           fields.CharacterBook,
+		Extensions:              fields.Extensions,
+	}, nil
+}
@@ -0,0 +1,49 @@
+package sillytavern
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestReadCharacterCardCopies(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "Alice.json")
+	content := `{"spec":"chara_card_v2","spec_version":"2.0","data":{"name":"Alice","description":"A traveler.",` +
+		`"alternate_greetings":["Hi."],"tags":["fantasy"],` +
+		`"character_book":{"entries":[{"keys":["inn"],"content":"The inn.","enabled":true}]},` +
+		`"extensions":{"depth_prompt":{"prompt":"Stay in character.","depth":4},"list":[1,{"a":"b"}]}}}`
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	first, err := readCharacterCard(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := readCharacterCard(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first.Name != "Alice" || first.CharacterBook == nil || first.Extensions == nil {
+		t.Fatalf("card not parsed: %+v", first)
+	}
+
+	// Changing a returned card must not change the cached one
+	first.Name = "Changed"
+	first.AlternateGreetings[0] = "Changed"
+	first.Tags[0] = "changed"
+	first.CharacterBook.Entries[0].Keys[0] = "changed"
+	first.CharacterBook.Entries[0].Content = "Changed"
+	first.Extensions["depth_prompt"].(map[string]interface{})["prompt"] = "Changed"
+	first.Extensions["list"].([]interface{})[1].(map[string]interface{})["a"] = "changed"
+	first.Extensions["added"] = true
+
+	got, err := readCharacterCard(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("cached card changed with the returned one:\ngot  %+v\nwant %+v", got, want)
+	}
+}
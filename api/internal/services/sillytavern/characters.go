@@ -5,9 +5,11 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+
+	"craigstjean.com/stsummarizer/internal/models"
 )
 
-func (s *SillyTavernService) GetCharacters(user string) ([]string, error) {
+func (s *SillyTavernService) GetCharacters(user string) ([]models.Character, error) {
 	if user == "" {
 		user = s.defaultUser
 	}
@@ -27,11 +29,45 @@ func (s *SillyTavernService) GetCharacters(user string) ([]string, error) {
 		return nil, fmt.Errorf("failed to read SillyTavern chats directory: %w", err)
 	}
 
-	var characters []string
+	var characters []models.Character
 	for _, entry := range entries {
-		if entry.IsDir() {
-			characters = append(characters, entry.Name())
+		if !entry.IsDir() {
+			continue
+		}
+
+		character := models.Character{
+			Name:        entry.Name(),
+			DisplayName: entry.Name(),
+			Tags:        []string{},
+		}
+
+		// Count the chat files for the character
+		chatEntries, err := os.ReadDir(filepath.Join(path, entry.Name()))
+		if err == nil {
+			for _, chatEntry := range chatEntries {
+				if !chatEntry.IsDir() && strings.HasSuffix(chatEntry.Name(), ".jsonl") {
+					character.ChatCount++
+				}
+			}
 		}
+
+		// Enrich with the character card when one exists, characters without cards are still listed
+		if cardPath, err := s.findCharacterCard(user, entry.Name()); err == nil {
+			if strings.EqualFold(filepath.Ext(cardPath), ".png") {
+				character.Avatar = filepath.Base(cardPath)
+			}
+
+			if card, err := readCharacterCard(cardPath); err == nil {
+				if card.Name != "" {
+					character.DisplayName = card.Name
+				}
+				if len(card.Tags) > 0 {
+					character.Tags = card.Tags
+				}
+			}
+		}
+
+		characters = append(characters, character)
 	}
 
 	return characters, nil
@@ -1,11 +1,11 @@
 package sillytavern
 
 import (
-	"bufio"
 	"encoding/json"
 	"fmt"
 	"os"
 	"path/filepath"
+	"slices"
 	"strings"
 
 	"craigstjean.com/stsummarizer/internal/models"
@@ -46,57 +46,23 @@ func (s *SillyTavernService) GetCharacterChats(user, character string) ([]string
 }
 
 func (s *SillyTavernService) GetCharacterChat(user, character, chat string) ([]models.ChatMessage, error) {
-	if user == "" {
-		user = s.defaultUser
-	}
-
-	// Check for directory traversal attempts
-	if strings.Contains(user, "..") || strings.Contains(character, "..") || strings.Contains(chat, "..") {
-		return nil, fmt.Errorf("invalid chat path")
-	}
-
-	// Construct file path
-	chatPath := filepath.Join(s.dataPath, user, s.chatsPath, character, chat+".jsonl")
-
-	// Check if file exists
-	if _, err := os.Stat(chatPath); os.IsNotExist(err) {
-		return nil, fmt.Errorf("chat file does not exist: %s", chat)
-	}
-
-	// Open the file
-	file, err := os.Open(chatPath)
+	chatPath, err := s.characterChatPath(user, character, chat)
 	if err != nil {
-		return nil, fmt.Errorf("failed to open chat file: %w", err)
+		return nil, err
 	}
-	defer file.Close()
-
-	var messages []models.ChatMessage
-	scanner := bufio.NewScanner(file)
-	lineNum := 0
-
-	// Read the file line by line
-	for scanner.Scan() {
-		lineNum++
-		line := scanner.Text()
-
-		// Skip empty lines
-		if strings.TrimSpace(line) == "" {
-			continue
-		}
-
-		var message models.ChatMessage
-		if err := json.Unmarshal([]byte(line), &message); err != nil {
-			return nil, fmt.Errorf("error parsing JSON at line %d: %w", lineNum, err)
-		}
 
-		messages = append(messages, message)
-	}
+	messages, _, err := readChatFile(chatPath, false)
+	return messages, err
+}
 
-	if err := scanner.Err(); err != nil {
-		return nil, fmt.Errorf("error reading file: %w", err)
+// GetCharacterChatLenient reads the chat, skipping lines that cannot be parsed and returning them instead
+func (s *SillyTavernService) GetCharacterChatLenient(user, character, chat string) ([]models.ChatMessage, []models.ChatLineError, error) {
+	chatPath, err := s.characterChatPath(user, character, chat)
+	if err != nil {
+		return nil, nil, err
 	}
 
-	return messages, nil
+	return readChatFile(chatPath, true)
 }
 
 func (s *SillyTavernService) GetGroupChats(user string) ([]models.GroupChat, error) {
@@ -148,56 +114,139 @@ func (s *SillyTavernService) GetGroupChats(user string) ([]models.GroupChat, err
 	return groups, nil
 }
 
+// addGroupChat adds newChat to the chats of the group holding chat, so SillyTavern lists it with the group. Fields of
+// the group file this service does not know are kept.
+func (s *SillyTavernService) addGroupChat(user, chat, newChat string) error {
+	groupsDir := filepath.Join(s.dataPath, user, s.groupsPath)
+	entries, err := os.ReadDir(groupsDir)
+	if err != nil {
+		return fmt.Errorf("failed to read SillyTavern groups directory: %w", err)
+	}
+
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".json") {
+			continue
+		}
+
+		filePath := filepath.Join(groupsDir, entry.Name())
+		fileContent, err := os.ReadFile(filePath)
+		if err != nil {
+			return fmt.Errorf("failed to read group file %s: %w", entry.Name(), err)
+		}
+
+		var group map[string]json.RawMessage
+		var chats []string
+		if err := json.Unmarshal(fileContent, &group); err != nil || json.Unmarshal(group["chats"], &chats) != nil {
+			continue
+		}
+		if !slices.Contains(chats, chat) {
+			continue
+		}
+
+		if group["chats"], err = json.Marshal(append(chats, newChat)); err != nil {
+			return fmt.Errorf("failed to update group file %s: %w", entry.Name(), err)
+		}
+		updated, err := json.Marshal(group)
+		if err != nil {
+			return fmt.Errorf("failed to update group file %s: %w", entry.Name(), err)
+		}
+
+		return writeFileAtomic(filePath, updated)
+	}
+
+	return nil
+}
+
 func (s *SillyTavernService) GetGroupChat(user, chat string) ([]models.ChatMessage, error) {
+	chatPath, err := s.groupChatPath(user, chat)
+	if err != nil {
+		return nil, err
+	}
+
+	messages, _, err := readChatFile(chatPath, false)
+	return messages, err
+}
+
+// GetGroupChatLenient reads the chat, skipping lines that cannot be parsed and returning them instead
+func (s *SillyTavernService) GetGroupChatLenient(user, chat string) ([]models.ChatMessage, []models.ChatLineError, error) {
+	chatPath, err := s.groupChatPath(user, chat)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return readChatFile(chatPath, true)
+}
+
+func (s *SillyTavernService) GetCharacterChatInfo(user, character, chat string) (*models.ChatFileInfo, error) {
+	chatPath, err := s.characterChatPath(user, character, chat)
+	if err != nil {
+		return nil, err
+	}
+
+	return statChatFile(chatPath)
+}
+
+func (s *SillyTavernService) GetGroupChatInfo(user, chat string) (*models.ChatFileInfo, error) {
+	chatPath, err := s.groupChatPath(user, chat)
+	if err != nil {
+		return nil, err
+	}
+
+	return statChatFile(chatPath)
+}
+
+// characterChatPath validates the names and returns the path of an existing character chat file
+func (s *SillyTavernService) characterChatPath(user, character, chat string) (string, error) {
 	if user == "" {
 		user = s.defaultUser
 	}
 
 	// Check for directory traversal attempts
-	if strings.Contains(user, "..") || strings.Contains(chat, "..") {
-		return nil, fmt.Errorf("invalid chat path")
+	if strings.Contains(user, "..") || strings.Contains(character, "..") || strings.Contains(chat, "..") {
+		return "", fmt.Errorf("invalid chat path")
 	}
 
 	// Construct file path
-	chatPath := filepath.Join(s.dataPath, user, s.groupChatsPath, chat+".jsonl")
+	chatPath := filepath.Join(s.dataPath, user, s.chatsPath, character, chat+".jsonl")
 
 	// Check if file exists
 	if _, err := os.Stat(chatPath); os.IsNotExist(err) {
-		return nil, fmt.Errorf("chat file does not exist: %s", chat)
-	}
-
-	// Open the file
-	file, err := os.Open(chatPath)
-	if err != nil {
-		return nil, fmt.Errorf("failed to open chat file: %w", err)
+		return "", fmt.Errorf("chat file does not exist: %s", chat)
 	}
-	defer file.Close()
 
-	var messages []models.ChatMessage
-	scanner := bufio.NewScanner(file)
-	lineNum := 0
+	return chatPath, nil
+}
 
-	// Read the file line by line
-	for scanner.Scan() {
-		lineNum++
-		line := scanner.Text()
+// groupChatPath validates the names and returns the path of an existing group chat file
+func (s *SillyTavernService) groupChatPath(user, chat string) (string, error) {
+	if user == "" {
+		user = s.defaultUser
+	}
 
-		// Skip empty lines
-		if strings.TrimSpace(line) == "" {
-			continue
-		}
+	// Check for directory traversal attempts
+	if strings.Contains(user, "..") || strings.Contains(chat, "..") {
+		return "", fmt.Errorf("invalid chat path")
+	}
 
-		var message models.ChatMessage
-		if err := json.Unmarshal([]byte(line), &message); err != nil {
-			return nil, fmt.Errorf("error parsing JSON at line %d: %w", lineNum, err)
-		}
+	// Construct file path
+	chatPath := filepath.Join(s.dataPath, user, s.groupChatsPath, chat+".jsonl")
 
-		messages = append(messages, message)
+	// Check if file exists
+	if _, err := os.Stat(chatPath); os.IsNotExist(err) {
+		return "", fmt.Errorf("chat file does not exist: %s", chat)
 	}
 
-	if err := scanner.Err(); err != nil {
-		return nil, fmt.Errorf("error reading file: %w", err)
+	return chatPath, nil
+}
+
+func statChatFile(chatPath string) (*models.ChatFileInfo, error) {
+	info, err := os.Stat(chatPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read chat file: %w", err)
 	}
 
-	return messages, nil
+	return &models.ChatFileInfo{
+		ModTime: info.ModTime(),
+		Size:    info.Size(),
+	}, nil
 }
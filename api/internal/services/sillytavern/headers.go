@@ -0,0 +1,47 @@
+package sillytavern
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+
+	"craigstjean.com/stsummarizer/internal/models"
+)
+
+// GetCharacterChatHeader returns the metadata header of a chat, nil when the file has none
+func (s *SillyTavernService) GetCharacterChatHeader(user, character, chat string) (*models.ChatMetadata, error) {
+	chatPath, err := s.characterChatPath(user, character, chat)
+	if err != nil {
+		return nil, err
+	}
+
+	return readChatHeader(chatPath)
+}
+
+func readChatHeader(path string) (*models.ChatMetadata, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open chat file: %w", err)
+	}
+	defer file.Close()
+
+	line, _, err := newJSONLReader(file).next()
+	if err == io.EOF {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !isHeaderLine(line) {
+		return nil, nil
+	}
+
+	var header models.ChatMetadata
+	if err := json.Unmarshal(line, &header); err != nil {
+		// The header is only informative, a field of an unexpected type should not make the chat unusable
+		return nil, nil
+	}
+
+	return &header, nil
+}
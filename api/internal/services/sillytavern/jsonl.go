@@ -0,0 +1,95 @@
+package sillytavern
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+
+	"craigstjean.com/stsummarizer/internal/models"
+)
+
+// jsonlReader reads the lines of a JSONL file one at a time. Unlike bufio.Scanner it has no line length limit, so
+// messages with long swipes or embedded images do not make the file unreadable.
+type jsonlReader struct {
+	reader  *bufio.Reader
+	lineNum int
+}
+
+func newJSONLReader(r io.Reader) *jsonlReader {
+	return &jsonlReader{
+		reader: bufio.NewReaderSize(r, 64*1024),
+	}
+}
+
+// next returns the next non-empty line and its line number, starting at 1, or io.EOF after the last line
+func (r *jsonlReader) next() ([]byte, int, error) {
+	for {
+		line, err := r.reader.ReadBytes('\n')
+		if err != nil && err != io.EOF {
+			return nil, r.lineNum, fmt.Errorf("error reading file: %w", err)
+		}
+		if len(line) == 0 && err == io.EOF {
+			return nil, r.lineNum, io.EOF
+		}
+		r.lineNum++
+
+		if line = bytes.TrimSpace(line); len(line) > 0 {
+			return line, r.lineNum, nil
+		}
+		if err == io.EOF {
+			return nil, r.lineNum, io.EOF
+		}
+	}
+}
+
+// readChatFile parses the messages of a chat or backup file, skipping the header. In lenient mode lines that cannot
+// be parsed are skipped and returned with their line numbers instead of failing the whole file.
+func readChatFile(path string, lenient bool) ([]models.ChatMessage, []models.ChatLineError, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to open chat file: %w", err)
+	}
+	defer file.Close()
+
+	var messages []models.ChatMessage
+	var skipped []models.ChatLineError
+	reader := newJSONLReader(file)
+	headerChecked := false
+
+	for {
+		line, lineNum, err := reader.next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if !headerChecked {
+			headerChecked = true
+			if isHeaderLine(line) {
+				continue
+			}
+		}
+
+		var message models.ChatMessage
+		if err := json.Unmarshal(line, &message); err != nil {
+			if !lenient {
+				return nil, nil, fmt.Errorf("error parsing JSON at line %d: %w", lineNum, err)
+			}
+			skipped = append(skipped, models.ChatLineError{
+				Line:  lineNum,
+				Error: err.Error(),
+			})
+			continue
+		}
+
+		message.Index = len(messages)
+		messages = append(messages, message)
+	}
+
+	return messages, skipped, nil
+}
@@ -0,0 +1,112 @@
+package sillytavern
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestJSONLReader(t *testing.T) {
+	long := `{"name":"Alice","mes":"` + strings.Repeat("a", 100*1024) + `"}`
+
+	tests := []struct {
+		name      string
+		content   string
+		wantLines []string
+		wantNums  []int
+	}{
+		{"empty", "", nil, nil},
+		{"lines", "a\nb\n", []string{"a", "b"}, []int{1, 2}},
+		{"no final line break", "a\nb", []string{"a", "b"}, []int{1, 2}},
+		{"blank lines are counted", "a\n\n  \nb\n", []string{"a", "b"}, []int{1, 4}},
+		{"line over 64 KB", "a\n" + long + "\nb\n", []string{"a", long, "b"}, []int{1, 2, 3}},
+		{"carriage returns", "a\r\nb\r\n", []string{"a", "b"}, []int{1, 2}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reader := newJSONLReader(strings.NewReader(tt.content))
+
+			var lines []string
+			var nums []int
+			for {
+				line, lineNum, err := reader.next()
+				if err == io.EOF {
+					break
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+				lines = append(lines, string(line))
+				nums = append(nums, lineNum)
+			}
+
+			if !slices.Equal(lines, tt.wantLines) {
+				t.Errorf("lines %.80q, want %.80q", lines, tt.wantLines)
+			}
+			if !slices.Equal(nums, tt.wantNums) {
+				t.Errorf("line numbers %v, want %v", nums, tt.wantNums)
+			}
+		})
+	}
+}
+
+func TestReadChatFileLenient(t *testing.T) {
+	hello := `{"name":"Alice","mes":"Hello."}`
+	hi := `{"name":"User","is_user":true,"mes":"Hi."}`
+	long := `{"name":"Alice","mes":"` + strings.Repeat("a", 100*1024) + `"}`
+
+	tests := []struct {
+		name        string
+		content     string
+		wantMes     []string
+		wantSkipped []int
+	}{
+		{"header only", testHeader + "\n", nil, nil},
+		{"line over 64 KB", testHeader + "\n" + long + "\n" + hi + "\n", []string{strings.Repeat("a", 100*1024), "Hi."}, nil},
+		{"corrupt middle line", testHeader + "\n" + hello + "\n{garbled\n" + hi + "\n", []string{"Hello.", "Hi."}, []int{3}},
+		{"truncated last line", testHeader + "\n" + hello + "\n" + hi + "\n" + `{"name":"Ali`, []string{"Hello.", "Hi."}, []int{4}},
+		{"without header", hello + "\n" + hi + "\n", []string{"Hello.", "Hi."}, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "chat.jsonl")
+			if err := os.WriteFile(path, []byte(tt.content), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			messages, skipped, err := readChatFile(path, true)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var mes []string
+			for i, message := range messages {
+				mes = append(mes, message.Message)
+				if message.Index != i {
+					t.Errorf("message %d has index %d", i, message.Index)
+				}
+			}
+			if !slices.Equal(mes, tt.wantMes) {
+				t.Errorf("messages %.80q, want %.80q", mes, tt.wantMes)
+			}
+
+			var lines []int
+			for _, line := range skipped {
+				lines = append(lines, line.Line)
+			}
+			if !slices.Equal(lines, tt.wantSkipped) {
+				t.Errorf("skipped lines %v, want %v", lines, tt.wantSkipped)
+			}
+
+			// Without lenient mode the first bad line fails the whole file
+			if _, _, err := readChatFile(path, false); (err != nil) != (len(tt.wantSkipped) > 0) {
+				t.Errorf("strict read error = %v, want one only for skipped lines", err)
+			}
+		})
+	}
+}
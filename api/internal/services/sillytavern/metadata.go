@@ -0,0 +1,96 @@
+package sillytavern
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+)
+
+// SetCharacterChatMetadata stores value under key in the chat's chat_metadata header
+func (s *SillyTavernService) SetCharacterChatMetadata(user, character, chat, key string, value interface{}) error {
+	chatPath, err := s.characterChatPath(user, character, chat)
+	if err != nil {
+		return err
+	}
+
+	return setChatMetadata(chatPath, key, value)
+}
+
+// SetGroupChatMetadata stores value under key in the group chat's chat_metadata header
+func (s *SillyTavernService) SetGroupChatMetadata(user, chat, key string, value interface{}) error {
+	chatPath, err := s.groupChatPath(user, chat)
+	if err != nil {
+		return err
+	}
+
+	return setChatMetadata(chatPath, key, value)
+}
+
+// setChatMetadata rewrites the header line of a chat file, leaving the messages untouched.
+// It fails rather than overwriting when the file changes while it is being rewritten.
+func setChatMetadata(chatPath, key string, value interface{}) error {
+	before, err := os.Stat(chatPath)
+	if err != nil {
+		return fmt.Errorf("failed to read chat file: %w", err)
+	}
+
+	content, err := os.ReadFile(chatPath)
+	if err != nil {
+		return fmt.Errorf("failed to read chat file: %w", err)
+	}
+
+	reader := bufio.NewReader(bytes.NewReader(content))
+	headerLine, err := reader.ReadBytes('\n')
+	if err != nil && err != io.EOF {
+		return fmt.Errorf("failed to read chat header: %w", err)
+	}
+
+	var header map[string]json.RawMessage
+	if err := json.Unmarshal(headerLine, &header); err != nil {
+		return fmt.Errorf("failed to parse chat header: %w", err)
+	}
+
+	if _, ok := header["chat_metadata"]; !ok {
+		if _, ok := header["user_name"]; !ok {
+			return fmt.Errorf("chat file has no metadata header")
+		}
+	}
+
+	chatMetadata := make(map[string]json.RawMessage)
+	if raw, ok := header["chat_metadata"]; ok && string(raw) != "null" {
+		if err := json.Unmarshal(raw, &chatMetadata); err != nil {
+			return fmt.Errorf("failed to parse chat metadata: %w", err)
+		}
+	}
+
+	if chatMetadata[key], err = json.Marshal(value); err != nil {
+		return fmt.Errorf("failed to encode chat metadata: %w", err)
+	}
+	if header["chat_metadata"], err = json.Marshal(chatMetadata); err != nil {
+		return fmt.Errorf("failed to encode chat metadata: %w", err)
+	}
+
+	newHeader, err := json.Marshal(header)
+	if err != nil {
+		return fmt.Errorf("failed to encode chat header: %w", err)
+	}
+
+	var buf bytes.Buffer
+	buf.Write(newHeader)
+	buf.WriteByte('\n')
+	buf.Write(content[len(headerLine):])
+
+	// SillyTavern may have saved the chat in the meantime
+	if after, err := os.Stat(chatPath); err != nil || !after.ModTime().Equal(before.ModTime()) || after.Size() != before.Size() {
+		return fmt.Errorf("chat file changed while updating metadata")
+	}
+
+	if err := writeFileAtomic(chatPath, buf.Bytes()); err != nil {
+		return fmt.Errorf("failed to write chat file: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,348 @@
+package sillytavern
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"craigstjean.com/stsummarizer/internal/models"
+)
+
+// tailBlockSize is how much of the file is read per step when reading backwards
+const tailBlockSize = 64 * 1024
+
+func (s *SillyTavernService) GetCharacterChatPage(user, character, chat string, query models.ChatQuery) (*models.ChatPage, error) {
+	chatPath, err := s.characterChatPath(user, character, chat)
+	if err != nil {
+		return nil, err
+	}
+
+	return readChatPage(chatPath, query)
+}
+
+func (s *SillyTavernService) GetGroupChatPage(user, chat string, query models.ChatQuery) (*models.ChatPage, error) {
+	chatPath, err := s.groupChatPath(user, chat)
+	if err != nil {
+		return nil, err
+	}
+
+	return readChatPage(chatPath, query)
+}
+
+func (s *SillyTavernService) GetCharacterBackupPage(user, character, backup string, query models.ChatQuery) (*models.ChatPage, error) {
+	backupPath, err := s.characterBackupPath(user, character, backup)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := os.Stat(backupPath); os.IsNotExist(err) {
+		return nil, fmt.Errorf("backup file does not exist: %s", backup)
+	}
+
+	return readChatPage(backupPath, query)
+}
+
+func readChatPage(path string, query models.ChatQuery) (*models.ChatPage, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open chat file: %w", err)
+	}
+	defer file.Close()
+
+	if query.Tail > 0 {
+		page, err := readChatTail(file, query.Tail)
+		if err == nil || !query.Lenient {
+			return page, err
+		}
+
+		// Corrupt lines shift the message indexes, so the file is read forwards to skip and number them
+		if _, err := file.Seek(0, io.SeekStart); err != nil {
+			return nil, fmt.Errorf("error reading file: %w", err)
+		}
+	}
+
+	start := max(query.Offset, 0)
+	end := -1
+	if query.Limit > 0 {
+		end = start + query.Limit
+	}
+	if query.Tail > 0 {
+		start = 0
+		end = -1
+	} else if query.From != nil || query.To != nil {
+		start = 0
+		end = -1
+		if query.From != nil {
+			start = max(*query.From, 0)
+		}
+		if query.To != nil {
+			end = *query.To + 1 // To is inclusive
+		}
+	}
+
+	page := &models.ChatPage{
+		Messages: []models.ChatMessage{},
+		Start:    start,
+	}
+
+	reader := newJSONLReader(file)
+	index := 0
+	headerChecked := false
+
+	for {
+		line, lineNum, err := reader.next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if !headerChecked {
+			headerChecked = true
+			if isHeaderLine(line) {
+				continue
+			}
+		}
+
+		// Lines outside the range are only validated in lenient mode, otherwise they are only counted
+		inRange := index >= start && (end < 0 || index < end)
+		if inRange || query.Lenient {
+			var message models.ChatMessage
+			if err := json.Unmarshal(line, &message); err != nil {
+				if !query.Lenient {
+					return nil, fmt.Errorf("error parsing JSON at line %d: %w", lineNum, err)
+				}
+				page.Skipped = append(page.Skipped, models.ChatLineError{
+					Line:  lineNum,
+					Error: err.Error(),
+				})
+				continue
+			}
+			if inRange {
+				message.Index = index
+				page.Messages = append(page.Messages, message)
+			}
+		}
+		index++
+	}
+
+	page.Total = index
+	page.Start = min(start, index)
+
+	if query.Tail > 0 && len(page.Messages) > query.Tail {
+		page.Start = len(page.Messages) - query.Tail
+		page.Messages = page.Messages[page.Start:]
+	}
+
+	return page, nil
+}
+
+// readChatTail parses only the last n messages, reading the file backwards from the end.
+// The total is found by counting line breaks, without parsing the earlier messages, and cached for the next request.
+func readChatTail(file *os.File, n int) (*models.ChatPage, error) {
+	info, err := file.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read chat file: %w", err)
+	}
+
+	var lines [][]byte
+	var partial []byte
+	offset := info.Size()
+	reachedStart := false
+
+	for len(lines) <= n && !reachedStart {
+		blockSize := min(int64(tailBlockSize), offset)
+		offset -= blockSize
+		reachedStart = offset == 0
+
+		block := make([]byte, blockSize)
+		if _, err := file.ReadAt(block, offset); err != nil && err != io.EOF {
+			return nil, fmt.Errorf("error reading file: %w", err)
+		}
+
+		// Split the block into lines, the first piece may continue in the previous block
+		data := append(block, partial...)
+		for {
+			i := bytes.LastIndexByte(data, '\n')
+			if i < 0 {
+				break
+			}
+			if line := bytes.TrimSpace(data[i+1:]); len(line) > 0 {
+				lines = append(lines, line)
+			}
+			data = data[:i]
+		}
+		partial = data
+	}
+	if line := bytes.TrimSpace(partial); reachedStart && len(line) > 0 {
+		lines = append(lines, line)
+	}
+
+	// The first line of the file is the header, not a message
+	if reachedStart && len(lines) > 0 && isHeaderLine(lines[len(lines)-1]) {
+		lines = lines[:len(lines)-1]
+	}
+
+	total := len(lines)
+	if !reachedStart {
+		if total, err = countMessageLines(file, info); err != nil {
+			return nil, err
+		}
+	}
+
+	lines = lines[:min(n, len(lines))]
+	page := &models.ChatPage{
+		Messages: make([]models.ChatMessage, 0, len(lines)),
+		Start:    total - len(lines),
+		Total:    total,
+	}
+
+	// Lines were collected newest first
+	for i := len(lines) - 1; i >= 0; i-- {
+		var message models.ChatMessage
+		if err := json.Unmarshal(lines[i], &message); err != nil {
+			return nil, fmt.Errorf("error parsing JSON at message %d: %w", page.Start+len(page.Messages), err)
+		}
+		message.Index = page.Start + len(page.Messages)
+		page.Messages = append(page.Messages, message)
+	}
+
+	return page, nil
+}
+
+// boundarySize is how much of the end of a counted file must be unchanged for its count to be extended by the lines
+// appended since
+const boundarySize = 256
+
+// lineCount is the message count of a chat file as it was at size and modTime
+type lineCount struct {
+	size    int64
+	modTime time.Time
+	// lines counts the non-empty lines ended by a line break, inLine is set when the file ended inside another line
+	lines  int
+	inLine bool
+	header bool
+	// boundary holds the last bytes of the counted file
+	boundary []byte
+}
+
+func (c lineCount) messages() int {
+	total := c.lines
+	if c.inLine {
+		total++
+	}
+	if c.header {
+		total--
+	}
+	return max(total, 0)
+}
+
+var (
+	lineCountsMu sync.Mutex
+	lineCounts   = make(map[string]lineCount)
+)
+
+// countMessageLines counts the non-empty lines of a chat file, excluding the header. Counts are cached by path, size
+// and modification time, and a file that only grew since is counted from where the cached count ended, so tail
+// requests on a long chat do not read it all again.
+func countMessageLines(file *os.File, info os.FileInfo) (int, error) {
+	lineCountsMu.Lock()
+	cached, ok := lineCounts[file.Name()]
+	lineCountsMu.Unlock()
+
+	if ok && cached.size == info.Size() && cached.modTime.Equal(info.ModTime()) {
+		return cached.messages(), nil
+	}
+
+	count := lineCount{}
+	if ok && cached.size < info.Size() && cached.size >= int64(len(cached.boundary)) {
+		boundary := make([]byte, len(cached.boundary))
+		if _, err := file.ReadAt(boundary, cached.size-int64(len(boundary))); err == nil && bytes.Equal(boundary, cached.boundary) {
+			count = cached
+		}
+	}
+
+	if count.size == 0 {
+		header, err := hasHeaderLine(file)
+		if err != nil {
+			return 0, err
+		}
+		count.header = header
+	}
+
+	buf := make([]byte, tailBlockSize)
+	reader := io.NewSectionReader(file, count.size, info.Size()-count.size)
+	for {
+		n, err := reader.Read(buf)
+		chunk := buf[:n]
+		for len(chunk) > 0 {
+			i := bytes.IndexByte(chunk, '\n')
+			if i < 0 {
+				count.inLine = count.inLine || len(bytes.TrimSpace(chunk)) > 0
+				break
+			}
+			if count.inLine || len(bytes.TrimSpace(chunk[:i])) > 0 {
+				count.lines++
+			}
+			count.inLine = false
+			chunk = chunk[i+1:]
+		}
+
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return 0, fmt.Errorf("error reading file: %w", err)
+		}
+	}
+
+	count.size = info.Size()
+	count.modTime = info.ModTime()
+	count.boundary = make([]byte, min(int64(boundarySize), count.size))
+	if _, err := file.ReadAt(count.boundary, count.size-int64(len(count.boundary))); err != nil && err != io.EOF {
+		return 0, fmt.Errorf("error reading file: %w", err)
+	}
+
+	lineCountsMu.Lock()
+	lineCounts[file.Name()] = count
+	lineCountsMu.Unlock()
+
+	return count.messages(), nil
+}
+
+// hasHeaderLine reports whether the first non-empty line of the file is a chat header, only that line can be one
+func hasHeaderLine(file *os.File) (bool, error) {
+	first := bufio.NewReader(io.NewSectionReader(file, 0, 1<<62))
+	for {
+		line, err := first.ReadBytes('\n')
+		if len(bytes.TrimSpace(line)) > 0 {
+			return isHeaderLine(line), nil
+		}
+		if err == io.EOF {
+			return false, nil
+		}
+		if err != nil {
+			return false, fmt.Errorf("error reading file: %w", err)
+		}
+	}
+}
+
+// isHeaderLine reports whether a line is the chat metadata header SillyTavern writes as the first line of a chat
+func isHeaderLine(line []byte) bool {
+	var header struct {
+		UserName     *string         `json:"user_name"`
+		ChatMetadata json.RawMessage `json:"chat_metadata"`
+		Message      *string         `json:"mes"`
+	}
+	if err := json.Unmarshal(line, &header); err != nil {
+		return false
+	}
+
+	return header.Message == nil && (header.UserName != nil || header.ChatMetadata != nil)
+}
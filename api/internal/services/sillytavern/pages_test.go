@@ -0,0 +1,117 @@
+package sillytavern
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// testChat returns a chat file with a header and n messages, each padded to about size bytes
+func testChat(n, size int) string {
+	var b strings.Builder
+	b.WriteString(testHeader + "\n")
+	for i := range n {
+		fmt.Fprintf(&b, `{"name":"Alice","mes":"message %d %s"}`+"\n", i, strings.Repeat("x", size))
+	}
+	return b.String()
+}
+
+func TestReadChatTail(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		tail    int
+		want    []string
+		total   int
+	}{
+		{
+			name:    "last messages",
+			content: testChat(5, 0),
+			tail:    2,
+			want:    []string{"message 3 ", "message 4 "},
+			total:   5,
+		},
+		{
+			name:    "no final line break",
+			content: strings.TrimSuffix(testChat(3, 0), "\n"),
+			tail:    2,
+			want:    []string{"message 1 ", "message 2 "},
+			total:   3,
+		},
+		{
+			name:    "tail larger than the chat",
+			content: testChat(3, 0),
+			tail:    10,
+			want:    []string{"message 0 ", "message 1 ", "message 2 "},
+			total:   3,
+		},
+		{
+			name:    "header only",
+			content: testHeader + "\n",
+			tail:    5,
+			total:   0,
+		},
+		{
+			name:    "header only without line break",
+			content: testHeader,
+			tail:    5,
+			total:   0,
+		},
+		{
+			name:    "empty file",
+			content: "",
+			tail:    5,
+			total:   0,
+		},
+		{
+			name:    "without header",
+			content: strings.SplitN(testChat(3, 0), "\n", 2)[1],
+			tail:    5,
+			want:    []string{"message 0 ", "message 1 ", "message 2 "},
+			total:   3,
+		},
+		{
+			name:    "longer than a block",
+			content: testChat(200, 1000),
+			tail:    2,
+			want:    []string{"message 198 " + strings.Repeat("x", 1000), "message 199 " + strings.Repeat("x", 1000)},
+			total:   200,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "chat.jsonl")
+			if err := os.WriteFile(path, []byte(tt.content), 0644); err != nil {
+				t.Fatal(err)
+			}
+			file, err := os.Open(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer file.Close()
+
+			page, err := readChatTail(file, tt.tail)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var mes []string
+			for i, message := range page.Messages {
+				mes = append(mes, message.Message)
+				if message.Index != page.Start+i {
+					t.Errorf("message %d has index %d, want %d", i, message.Index, page.Start+i)
+				}
+			}
+			if !slices.Equal(mes, tt.want) {
+				t.Errorf("messages %.60q, want %.60q", mes, tt.want)
+			}
+			if page.Total != tt.total || page.Start != tt.total-len(tt.want) {
+				t.Errorf("start %d of %d, want %d of %d", page.Start, page.Total, tt.total-len(tt.want), tt.total)
+			}
+		})
+	}
+}
@@ -0,0 +1,75 @@
+package sillytavern
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"slices"
+
+	"craigstjean.com/stsummarizer/internal/models"
+)
+
+// regexSettings holds the Regex extension's part of settings.json
+type regexSettings struct {
+	ExtensionSettings struct {
+		Regex                 []json.RawMessage `json:"regex"`
+		CharacterAllowedRegex []string          `json:"character_allowed_regex"`
+	} `json:"extension_settings"`
+}
+
+// GetRegexScripts returns the user's global regex scripts followed by the scripts embedded in each character's card.
+// As in SillyTavern, a character's scripts are only used once the user has allowed them for that character.
+// Disabled scripts and scripts that cannot be parsed are left out.
+func (s *SillyTavernService) GetRegexScripts(user string, characters []string) ([]models.RegexScript, error) {
+	if user == "" {
+		user = s.defaultUser
+	}
+
+	var settings regexSettings
+	if err := s.readSettingsInto(user, &settings); err != nil {
+		return nil, err
+	}
+
+	scripts := parseRegexScripts(settings.ExtensionSettings.Regex)
+
+	for _, character := range characters {
+		cardPath, err := s.findCharacterCard(user, character)
+		if err != nil {
+			continue
+		}
+		if !slices.Contains(settings.ExtensionSettings.CharacterAllowedRegex, filepath.Base(cardPath)) {
+			continue
+		}
+
+		card, err := readCharacterCard(cardPath)
+		if err != nil {
+			continue
+		}
+
+		// The extensions are kept as generic JSON, round-trip the scripts to parse them
+		encoded, err := json.Marshal(card.Extensions["regex_scripts"])
+		if err != nil {
+			continue
+		}
+		var raw []json.RawMessage
+		if err := json.Unmarshal(encoded, &raw); err != nil {
+			continue
+		}
+		scripts = append(scripts, parseRegexScripts(raw)...)
+	}
+
+	return scripts, nil
+}
+
+// parseRegexScripts parses each script on its own, so one malformed script does not hide the others
+func parseRegexScripts(raw []json.RawMessage) []models.RegexScript {
+	var scripts []models.RegexScript
+	for _, entry := range raw {
+		var script models.RegexScript
+		if err := json.Unmarshal(entry, &script); err != nil || script.Disabled || script.FindRegex == "" {
+			continue
+		}
+		scripts = append(scripts, script)
+	}
+
+	return scripts
+}
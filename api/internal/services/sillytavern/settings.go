@@ -0,0 +1,93 @@
+package sillytavern
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"craigstjean.com/stsummarizer/internal/models"
+)
+
+// userSettings holds the parts of a user's settings.json used by this service
+type userSettings struct {
+	Username   string `json:"username"`
+	UserAvatar string `json:"user_avatar"`
+	PowerUser  struct {
+		Personas            map[string]string `json:"personas"`
+		PersonaDescriptions map[string]struct {
+			Description string `json:"description"`
+		} `json:"persona_descriptions"`
+	} `json:"power_user"`
+}
+
+func (s *SillyTavernService) readUserSettings(user string) (*userSettings, error) {
+	var settings userSettings
+	if err := s.readSettingsInto(user, &settings); err != nil {
+		return nil, err
+	}
+
+	return &settings, nil
+}
+
+// readSettingsInto parses the user's settings.json into target, which only needs the fields it uses
+func (s *SillyTavernService) readSettingsInto(user string, target interface{}) error {
+	if strings.Contains(user, "..") {
+		return fmt.Errorf("invalid user name")
+	}
+
+	settingsPath := filepath.Join(s.dataPath, user, s.settingsFile)
+	content, err := os.ReadFile(settingsPath)
+	if os.IsNotExist(err) {
+		return fmt.Errorf("SillyTavern settings file does not exist")
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read SillyTavern settings: %w", err)
+	}
+
+	if err := json.Unmarshal(content, target); err != nil {
+		return fmt.Errorf("failed to parse SillyTavern settings: %w", err)
+	}
+
+	return nil
+}
+
+// GetUserPersona returns the persona with the given display name, or the currently selected persona when name is
+// empty. A name no persona has is an error rather than the selected persona, whose description is of someone else.
+func (s *SillyTavernService) GetUserPersona(user, name string) (*models.Persona, error) {
+	if user == "" {
+		user = s.defaultUser
+	}
+
+	settings, err := s.readUserSettings(user)
+	if err != nil {
+		return nil, err
+	}
+
+	avatar := settings.UserAvatar
+	if name != "" {
+		avatar = ""
+		for personaAvatar, personaName := range settings.PowerUser.Personas {
+			if personaName == name {
+				avatar = personaAvatar
+				break
+			}
+		}
+		if avatar == "" {
+			return nil, fmt.Errorf("persona does not exist: %s", name)
+		}
+	}
+
+	persona := &models.Persona{
+		Name:        settings.PowerUser.Personas[avatar],
+		Avatar:      avatar,
+		Description: settings.PowerUser.PersonaDescriptions[avatar].Description,
+	}
+
+	if persona.Name == "" {
+		persona.Name = settings.Username
+	}
+
+	return persona, nil
+}
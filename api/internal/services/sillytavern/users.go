@@ -1,9 +1,17 @@
 package sillytavern
 
 import (
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
 	"fmt"
 	"os"
+	"path/filepath"
 	"strings"
+
+	"craigstjean.com/stsummarizer/internal/models"
+	"golang.org/x/crypto/scrypt"
+	"golang.org/x/text/unicode/norm"
 )
 
 func (s *SillyTavernService) GetUsers() ([]string, error) {
@@ -23,3 +31,79 @@ func (s *SillyTavernService) GetUsers() ([]string, error) {
 
 	return users, nil
 }
+
+// stStoragePath holds SillyTavern's node-persist storage, where its user accounts are kept
+const stStoragePath = "_storage"
+
+// stAccount is a SillyTavern user account as stored under the key "user:<handle>"
+type stAccount struct {
+	Handle   string `json:"handle"`
+	Name     string `json:"name"`
+	Password string `json:"password"`
+	Salt     string `json:"salt"`
+	Admin    bool   `json:"admin"`
+	Enabled  bool   `json:"enabled"`
+}
+
+// VerifyUserPassword checks the handle and password of a SillyTavern account the way SillyTavern's login does.
+// Disabled accounts and accounts without a password are refused.
+func (s *SillyTavernService) VerifyUserPassword(handle, password string) (*models.UserAccount, error) {
+	account, err := s.findAccount(handle)
+	if err != nil {
+		return nil, err
+	}
+	if account == nil || !account.Enabled || account.Password == "" {
+		return nil, fmt.Errorf("invalid SillyTavern credentials")
+	}
+
+	// SillyTavern hashes with Node's scrypt defaults (N=16384, r=8, p=1), the base64 salt text is used as is
+	hash, err := scrypt.Key([]byte(norm.NFC.String(password)), []byte(account.Salt), 16384, 8, 1, 64)
+	if err != nil {
+		return nil, fmt.Errorf("failed to hash password: %w", err)
+	}
+	if subtle.ConstantTimeCompare([]byte(base64.StdEncoding.EncodeToString(hash)), []byte(account.Password)) != 1 {
+		return nil, fmt.Errorf("invalid SillyTavern credentials")
+	}
+
+	return &models.UserAccount{
+		Handle: account.Handle,
+		Name:   account.Name,
+		Admin:  account.Admin,
+	}, nil
+}
+
+// findAccount looks the account up in SillyTavern's storage, nil when there is none
+func (s *SillyTavernService) findAccount(handle string) (*stAccount, error) {
+	storagePath := filepath.Join(s.dataPath, stStoragePath)
+	entries, err := os.ReadDir(storagePath)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read SillyTavern accounts: %w", err)
+	}
+
+	key := "user:" + handle
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+
+		content, err := os.ReadFile(filepath.Join(storagePath, entry.Name()))
+		if err != nil {
+			continue
+		}
+
+		var item struct {
+			Key   string    `json:"key"`
+			Value stAccount `json:"value"`
+		}
+		if err := json.Unmarshal(content, &item); err != nil || item.Key != key {
+			continue
+		}
+
+		return &item.Value, nil
+	}
+
+	return nil, nil
+}
@@ -0,0 +1,383 @@
+package sillytavern
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"craigstjean.com/stsummarizer/internal/models"
+)
+
+// headerFields and messageFields are the JSON types SillyTavern writes for the fields it reads back
+var headerFields = map[string][]string{
+	"user_name":      {"string"},
+	"character_name": {"string"},
+	"create_date":    {"string", "number"},
+	"chat_metadata":  {"object"},
+}
+
+var messageFields = map[string][]string{
+	"name":       {"string"},
+	"is_user":    {"bool"},
+	"is_system":  {"bool"},
+	"mes":        {"string"},
+	"send_date":  {"string", "number"},
+	"swipe_id":   {"number"},
+	"swipes":     {"array"},
+	"swipe_info": {"array"},
+	"extra":      {"object"},
+}
+
+// requiredMessageFields cannot be dropped from a message, a message with a bad one is dropped instead
+var requiredMessageFields = []string{"name", "mes"}
+
+// chatAnalysis holds the problems found in a chat file and the lines of a repaired copy
+type chatAnalysis struct {
+	validation models.ChatValidation
+	repaired   [][]byte
+}
+
+func (s *SillyTavernService) ValidateCharacterChat(user, character, chat string) (*models.ChatValidation, error) {
+	chatPath, err := s.characterChatPath(user, character, chat)
+	if err != nil {
+		return nil, err
+	}
+
+	analysis, err := analyzeChatFile(chatPath, character)
+	if err != nil {
+		return nil, err
+	}
+
+	return &analysis.validation, nil
+}
+
+func (s *SillyTavernService) ValidateGroupChat(user, chat string) (*models.ChatValidation, error) {
+	chatPath, err := s.groupChatPath(user, chat)
+	if err != nil {
+		return nil, err
+	}
+
+	analysis, err := analyzeChatFile(chatPath, "")
+	if err != nil {
+		return nil, err
+	}
+
+	return &analysis.validation, nil
+}
+
+func (s *SillyTavernService) ValidateCharacterBackup(user, character, backup string) (*models.ChatValidation, error) {
+	backupPath, err := s.existingBackupPath(user, character, backup)
+	if err != nil {
+		return nil, err
+	}
+
+	analysis, err := analyzeChatFile(backupPath, character)
+	if err != nil {
+		return nil, err
+	}
+
+	return &analysis.validation, nil
+}
+
+// RepairCharacterChat writes a fixed copy of the chat as a new branch, the original file is left untouched
+func (s *SillyTavernService) RepairCharacterChat(user, character, chat string) (*models.ChatRepair, error) {
+	chatPath, err := s.characterChatPath(user, character, chat)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.repairChatFile(chatPath, s.characterChatsDir(user, character), character)
+}
+
+// RepairGroupChat writes a fixed copy of the group chat as a new branch and adds it to the chats of the group, the
+// original file is left untouched
+func (s *SillyTavernService) RepairGroupChat(user, chat string) (*models.ChatRepair, error) {
+	chatPath, err := s.groupChatPath(user, chat)
+	if err != nil {
+		return nil, err
+	}
+	if user == "" {
+		user = s.defaultUser
+	}
+
+	repair, err := s.repairChatFile(chatPath, filepath.Join(s.dataPath, user, s.groupChatsPath), "")
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.addGroupChat(user, chat, repair.Chat); err != nil {
+		return nil, err
+	}
+
+	return repair, nil
+}
+
+// RepairCharacterBackup writes a fixed copy of the backup as a new branch of the character's chats
+func (s *SillyTavernService) RepairCharacterBackup(user, character, backup string) (*models.ChatRepair, error) {
+	backupPath, err := s.existingBackupPath(user, character, backup)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.repairChatFile(backupPath, s.characterChatsDir(user, character), character)
+}
+
+func (s *SillyTavernService) characterChatsDir(user, character string) string {
+	if user == "" {
+		user = s.defaultUser
+	}
+
+	return filepath.Join(s.dataPath, user, s.chatsPath, character)
+}
+
+func (s *SillyTavernService) existingBackupPath(user, character, backup string) (string, error) {
+	backupPath, err := s.characterBackupPath(user, character, backup)
+	if err != nil {
+		return "", err
+	}
+
+	if _, err := os.Stat(backupPath); os.IsNotExist(err) {
+		return "", fmt.Errorf("backup file does not exist: %s", backup)
+	}
+
+	return backupPath, nil
+}
+
+// repairChatFile writes the repaired copy of the source file as a new branch in chatsDir
+func (s *SillyTavernService) repairChatFile(sourcePath, chatsDir, character string) (*models.ChatRepair, error) {
+	analysis, err := analyzeChatFile(sourcePath, character)
+	if err != nil {
+		return nil, err
+	}
+
+	newFileName, err := nextBranchName(chatsDir)
+	if err != nil {
+		return nil, err
+	}
+
+	// O_EXCL guarantees an existing chat is never overwritten
+	file, err := os.OpenFile(filepath.Join(chatsDir, newFileName), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create repaired chat: %w", err)
+	}
+	defer file.Close()
+
+	if _, err := file.Write(append(bytes.Join(analysis.repaired, []byte("\n")), '\n')); err != nil {
+		return nil, fmt.Errorf("failed to write repaired chat: %w", err)
+	}
+
+	return &models.ChatRepair{
+		Chat:     strings.TrimSuffix(newFileName, ".jsonl"),
+		Messages: len(analysis.repaired) - 1,
+		Fixed:    analysis.validation.Problems,
+	}, nil
+}
+
+// analyzeChatFile checks every line of a chat file and builds the repaired copy: unparseable lines and consecutive
+// duplicate messages are dropped, fields of the wrong type are removed and a missing header is recreated.
+func analyzeChatFile(path string, character string) (*chatAnalysis, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open chat file: %w", err)
+	}
+	defer file.Close()
+
+	analysis := &chatAnalysis{
+		validation: models.ChatValidation{
+			Problems: []models.ChatProblem{},
+		},
+	}
+	problem := func(line int, kind, field, message string) {
+		analysis.validation.Problems = append(analysis.validation.Problems, models.ChatProblem{
+			Line:    line,
+			Kind:    kind,
+			Field:   field,
+			Message: message,
+		})
+	}
+
+	var header map[string]json.RawMessage
+	var messages [][]byte
+	var userName, characterName string
+	var previous []byte
+	previousLine := 0
+
+	reader := newJSONLReader(file)
+	first := true
+	for {
+		line, lineNum, err := reader.next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		analysis.validation.Lines = lineNum
+
+		var fields map[string]json.RawMessage
+		if err := json.Unmarshal(line, &fields); err != nil {
+			problem(lineNum, models.ProblemInvalidJSON, "", err.Error())
+			if first {
+				problem(lineNum, models.ProblemMissingHeader, "", "the first line is not a readable chat header")
+				first = false
+			}
+			continue
+		}
+
+		if first {
+			first = false
+			if isHeaderLine(line) {
+				header = fields
+				for _, field := range checkFields(fields, headerFields) {
+					problem(lineNum, models.ProblemInvalidField, field, fmt.Sprintf("%s has the wrong type, expected %s", field, strings.Join(headerFields[field], " or ")))
+					delete(header, field)
+				}
+				continue
+			}
+			problem(lineNum, models.ProblemMissingHeader, "", "the first line is a message, not a chat header")
+		}
+
+		dropped := false
+		changed := false
+		for _, field := range requiredMessageFields {
+			if _, ok := fields[field]; !ok {
+				problem(lineNum, models.ProblemInvalidField, field, fmt.Sprintf("message has no %s", field))
+				dropped = true
+			}
+		}
+		for _, field := range checkFields(fields, messageFields) {
+			problem(lineNum, models.ProblemInvalidField, field, fmt.Sprintf("%s has the wrong type, expected %s", field, strings.Join(messageFields[field], " or ")))
+			delete(fields, field)
+			changed = true
+			for _, required := range requiredMessageFields {
+				dropped = dropped || field == required
+			}
+		}
+		if dropped {
+			continue
+		}
+
+		// A crash during a save can write the last message twice
+		key := messageKey(fields)
+		if previous != nil && bytes.Equal(key, previous) {
+			problem(lineNum, models.ProblemDuplicateMessage, "", fmt.Sprintf("duplicate of the message at line %d", previousLine))
+			continue
+		}
+		previous = key
+		previousLine = lineNum
+
+		var message models.ChatMessage
+		_ = json.Unmarshal(line, &message)
+		if message.IsUser && userName == "" {
+			userName = message.Name
+		} else if !message.IsUser && characterName == "" {
+			characterName = message.Name
+		}
+
+		// Lines without problems are copied as is, so key order and formatting are kept
+		if !changed {
+			messages = append(messages, line)
+		} else {
+			encoded, err := json.Marshal(fields)
+			if err != nil {
+				return nil, fmt.Errorf("failed to encode line %d: %w", lineNum, err)
+			}
+			messages = append(messages, encoded)
+		}
+		analysis.validation.Messages++
+	}
+
+	analysis.validation.Valid = len(analysis.validation.Problems) == 0
+
+	// Recreate whatever the header is missing from the messages
+	if header == nil {
+		header = make(map[string]json.RawMessage)
+	}
+	if characterName == "" {
+		characterName = character
+	}
+	info, err := file.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read chat file: %w", err)
+	}
+	defaults := map[string]interface{}{
+		"user_name":      userName,
+		"character_name": characterName,
+		"create_date":    info.ModTime().Format("2006-01-02@15h04m05s"),
+		"chat_metadata":  map[string]interface{}{},
+	}
+	for field, value := range defaults {
+		if _, ok := header[field]; !ok {
+			header[field], _ = json.Marshal(value)
+		}
+	}
+
+	headerLine, err := json.Marshal(header)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode chat header: %w", err)
+	}
+	analysis.repaired = append([][]byte{headerLine}, messages...)
+
+	return analysis, nil
+}
+
+// checkFields returns the fields whose JSON type is not one of the expected types, sorted by name
+func checkFields(fields map[string]json.RawMessage, expected map[string][]string) []string {
+	var invalid []string
+	for field, types := range expected {
+		raw, ok := fields[field]
+		if !ok {
+			continue
+		}
+
+		actual := jsonType(raw)
+		valid := actual == "null" && field != "mes" && field != "name"
+		for _, t := range types {
+			valid = valid || actual == t
+		}
+		if !valid {
+			invalid = append(invalid, field)
+		}
+	}
+	sort.Strings(invalid)
+
+	return invalid
+}
+
+func jsonType(raw json.RawMessage) string {
+	raw = bytes.TrimSpace(raw)
+	if len(raw) == 0 {
+		return ""
+	}
+
+	switch raw[0] {
+	case '"':
+		return "string"
+	case '{':
+		return "object"
+	case '[':
+		return "array"
+	case 't', 'f':
+		return "bool"
+	case 'n':
+		return "null"
+	default:
+		return "number"
+	}
+}
+
+// messageKey identifies a message by its author, text and time, for finding duplicates
+func messageKey(fields map[string]json.RawMessage) []byte {
+	var key bytes.Buffer
+	for _, field := range []string{"name", "is_user", "mes", "send_date"} {
+		key.Write(fields[field])
+		key.WriteByte(0)
+	}
+
+	return key.Bytes()
+}
@@ -0,0 +1,203 @@
+package sillytavern
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"craigstjean.com/stsummarizer/internal/config"
+	"craigstjean.com/stsummarizer/internal/models"
+)
+
+func newTestService(t *testing.T) *SillyTavernService {
+	t.Helper()
+
+	return &SillyTavernService{
+		dataPath:       t.TempDir(),
+		chatsPath:      config.STChatsPath,
+		charactersPath: config.STCharactersPath,
+		groupsPath:     config.STGroupsPath,
+		groupChatsPath: config.STGroupChatsPath,
+		backupsPath:    config.STBackupsPath,
+		worldsPath:     config.STWorldsPath,
+		settingsFile:   config.STSettingsFile,
+		defaultUser:    config.STDefaultUser,
+	}
+}
+
+// writeTestFile writes the file under the data path of s, creating its directory
+func writeTestFile(t *testing.T, s *SillyTavernService, path, content string) {
+	t.Helper()
+
+	path = filepath.Join(s.dataPath, path)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// readRepairedChat reads the messages of a repaired chat, checking that its first line is a header
+func readRepairedChat(t *testing.T, path string) []models.ChatMessage {
+	t.Helper()
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first, _, _ := strings.Cut(string(content), "\n"); !isHeaderLine([]byte(first)) {
+		t.Errorf("repaired chat starts with %q, not a header", first)
+	}
+
+	messages, _, err := readChatFile(path, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return messages
+}
+
+const testHeader = `{"user_name":"User","character_name":"Alice","create_date":"2025-02-11@20h00m00s","chat_metadata":{}}`
+
+func TestRepairCharacterChat(t *testing.T) {
+	hello := `{"name":"Alice","is_user":false,"send_date":"February 11, 2025 8:00pm","mes":"Hello."}`
+	hi := `{"name":"User","is_user":true,"send_date":"February 11, 2025 8:01pm","mes":"Hi."}`
+
+	tests := []struct {
+		name      string
+		content   string
+		wantKinds []string
+		wantMes   []string
+	}{
+		{
+			name:      "truncated last line",
+			content:   testHeader + "\n" + hello + "\n" + hi + "\n" + `{"name":"Alice","mes":"Tru`,
+			wantKinds: []string{"invalid_json"},
+			wantMes:   []string{"Hello.", "Hi."},
+		},
+		{
+			name:      "garbled header",
+			content:   `{"user_name":"User","charac` + "\n" + hello + "\n" + hi + "\n",
+			wantKinds: []string{"invalid_json", "missing_header"},
+			wantMes:   []string{"Hello.", "Hi."},
+		},
+		{
+			name:      "duplicate message",
+			content:   testHeader + "\n" + hello + "\n" + hello + "\n" + hi + "\n",
+			wantKinds: []string{"duplicate_message"},
+			wantMes:   []string{"Hello.", "Hi."},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestService(t)
+			writeTestFile(t, s, filepath.Join(s.defaultUser, s.chatsPath, "Alice", "chat.jsonl"), tt.content)
+
+			repair, err := s.RepairCharacterChat("", "Alice", "chat")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var kinds []string
+			for _, problem := range repair.Fixed {
+				if !slices.Contains(kinds, problem.Kind) {
+					kinds = append(kinds, problem.Kind)
+				}
+			}
+			slices.Sort(kinds)
+			if !slices.Equal(kinds, tt.wantKinds) {
+				t.Errorf("fixed %v, want %v", kinds, tt.wantKinds)
+			}
+
+			messages := readRepairedChat(t, filepath.Join(s.characterChatsDir("", "Alice"), repair.Chat+".jsonl"))
+			var mes []string
+			for _, message := range messages {
+				mes = append(mes, message.Message)
+			}
+			if !slices.Equal(mes, tt.wantMes) || repair.Messages != len(tt.wantMes) {
+				t.Errorf("repaired messages %q (%d reported), want %q", mes, repair.Messages, tt.wantMes)
+			}
+
+			original, err := os.ReadFile(filepath.Join(s.characterChatsDir("", "Alice"), "chat.jsonl"))
+			if err != nil || string(original) != tt.content {
+				t.Errorf("original chat was modified")
+			}
+		})
+	}
+}
+
+func TestRepairGroupChat(t *testing.T) {
+	s := newTestService(t)
+	user := s.defaultUser
+
+	hello := `{"name":"Alice","is_user":false,"send_date":"February 11, 2025 8:00pm","mes":"Hello."}`
+	writeTestFile(t, s, filepath.Join(user, s.groupChatsPath, "g1chat.jsonl"), testHeader+"\n"+hello+"\n"+`{"name":`)
+	writeTestFile(t, s, filepath.Join(user, s.groupsPath, "g1.json"), `{"id":"g1","name":"Group","members":["Alice.png"],"chats":["g1chat"],"chat_id":"g1chat"}`)
+	writeTestFile(t, s, filepath.Join(user, s.groupsPath, "g2.json"), `{"id":"g2","name":"Other","members":["Bob.png"],"chats":["g2chat"],"chat_id":"g2chat"}`)
+
+	repair, err := s.RepairGroupChat("", "g1chat")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	messages := readRepairedChat(t, filepath.Join(s.dataPath, user, s.groupChatsPath, repair.Chat+".jsonl"))
+	if len(messages) != 1 || messages[0].Message != "Hello." {
+		t.Errorf("repaired group chat holds %+v, want the one readable message", messages)
+	}
+
+	groupChats := func(file string) ([]string, map[string]json.RawMessage) {
+		t.Helper()
+
+		content, err := os.ReadFile(filepath.Join(s.dataPath, user, s.groupsPath, file))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var group map[string]json.RawMessage
+		var chats []string
+		if err := json.Unmarshal(content, &group); err != nil || json.Unmarshal(group["chats"], &chats) != nil {
+			t.Fatalf("group file %s is no longer valid: %s", file, content)
+		}
+		return chats, group
+	}
+
+	chats, group := groupChats("g1.json")
+	if !slices.Equal(chats, []string{"g1chat", repair.Chat}) {
+		t.Errorf("group chats %q, want the repaired chat added", chats)
+	}
+	if string(group["chat_id"]) != `"g1chat"` || string(group["members"]) != `["Alice.png"]` {
+		t.Errorf("other group fields changed: %s %s", group["chat_id"], group["members"])
+	}
+	if chats, _ := groupChats("g2.json"); !slices.Equal(chats, []string{"g2chat"}) {
+		t.Errorf("unrelated group chats changed to %q", chats)
+	}
+}
+
+func TestCharacterBackupKeepsIndexes(t *testing.T) {
+	s := newTestService(t)
+	user := s.defaultUser
+
+	if err := os.MkdirAll(s.characterChatsDir("", "Alice"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	writeTestFile(t, s, filepath.Join(user, s.backupsPath, "chat_alice_20250211-200000.jsonl"), testHeader+"\n"+
+		`{"name":"Alice","mes":"Hello."}`+"\n"+
+		`{"name":"Alice","mes":""}`+"\n"+
+		`{"name":"User","is_user":true,"mes":"Hi."}`+"\n")
+
+	messages, err := s.GetCharacterBackup("", "Alice", "chat_alice_20250211-200000.jsonl")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var indexes []int
+	for _, message := range messages {
+		indexes = append(indexes, message.Index)
+	}
+	if !slices.Equal(indexes, []int{0, 2}) {
+		t.Errorf("backup message indexes %v, want [0 2] with the empty message left out", indexes)
+	}
+}
@@ -0,0 +1,312 @@
+package sillytavern
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"craigstjean.com/stsummarizer/internal/models"
+)
+
+// worldInfoEntry mirrors SillyTavern's newWorldInfoEntryTemplate, used when adding entries to a lorebook
+type worldInfoEntry struct {
+	UID                       int      `json:"uid"`
+	Key                       []string `json:"key"`
+	KeySecondary              []string `json:"keysecondary"`
+	Comment                   string   `json:"comment"`
+	Content                   string   `json:"content"`
+	Constant                  bool     `json:"constant"`
+	Vectorized                bool     `json:"vectorized"`
+	Selective                 bool     `json:"selective"`
+	SelectiveLogic            int      `json:"selectiveLogic"`
+	AddMemo                   bool     `json:"addMemo"`
+	Order                     int      `json:"order"`
+	Position                  int      `json:"position"`
+	Disable                   bool     `json:"disable"`
+	IgnoreBudget              bool     `json:"ignoreBudget"`
+	ExcludeRecursion          bool     `json:"excludeRecursion"`
+	PreventRecursion          bool     `json:"preventRecursion"`
+	MatchPersonaDescription   bool     `json:"matchPersonaDescription"`
+	MatchCharacterDescription bool     `json:"matchCharacterDescription"`
+	MatchCharacterPersonality bool     `json:"matchCharacterPersonality"`
+	MatchCharacterDepthPrompt bool     `json:"matchCharacterDepthPrompt"`
+	MatchScenario             bool     `json:"matchScenario"`
+	MatchCreatorNotes         bool     `json:"matchCreatorNotes"`
+	DelayUntilRecursion       int      `json:"delayUntilRecursion"`
+	Probability               int      `json:"probability"`
+	UseProbability            bool     `json:"useProbability"`
+	Depth                     int      `json:"depth"`
+	Group                     string   `json:"group"`
+	GroupOverride             bool     `json:"groupOverride"`
+	GroupWeight               int      `json:"groupWeight"`
+	ScanDepth                 *int     `json:"scanDepth"`
+	CaseSensitive             *bool    `json:"caseSensitive"`
+	MatchWholeWords           *bool    `json:"matchWholeWords"`
+	UseGroupScoring           *bool    `json:"useGroupScoring"`
+	AutomationID              string   `json:"automationId"`
+	Role                      int      `json:"role"`
+	Sticky                    *int     `json:"sticky"`
+	Cooldown                  *int     `json:"cooldown"`
+	Delay                     *int     `json:"delay"`
+	Triggers                  []string `json:"triggers"`
+	DisplayIndex              int      `json:"displayIndex"`
+}
+
+func newWorldInfoEntry(uid int, entry models.WorldInfoEntry) worldInfoEntry {
+	secondaryKeys := entry.SecondaryKeys
+	if secondaryKeys == nil {
+		secondaryKeys = []string{}
+	}
+
+	return worldInfoEntry{
+		UID:            uid,
+		Key:            entry.Keys,
+		KeySecondary:   secondaryKeys,
+		Comment:        entry.Comment,
+		Content:        entry.Content,
+		Selective:      true,
+		AddMemo:        entry.Comment != "",
+		Order:          100,
+		Disable:        entry.Disabled,
+		Probability:    100,
+		UseProbability: true,
+		Depth:          4,
+		GroupWeight:    100,
+		Triggers:       []string{},
+		DisplayIndex:   uid,
+	}
+}
+
+// worldFile keeps every field of a lorebook so that saving never drops data this service does not know about
+type worldFile struct {
+	fields  map[string]json.RawMessage
+	entries map[string]map[string]json.RawMessage
+}
+
+func (s *SillyTavernService) GetWorlds(user string) ([]string, error) {
+	if user == "" {
+		user = s.defaultUser
+	}
+
+	if strings.Contains(user, "..") {
+		return nil, fmt.Errorf("invalid user name")
+	}
+
+	worldsDir := filepath.Join(s.dataPath, user, s.worldsPath)
+	entries, err := os.ReadDir(worldsDir)
+	if os.IsNotExist(err) {
+		return []string{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read SillyTavern worlds directory: %w", err)
+	}
+
+	worlds := []string{}
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".json") {
+			continue
+		}
+		worlds = append(worlds, strings.TrimSuffix(entry.Name(), ".json"))
+	}
+
+	return worlds, nil
+}
+
+func (s *SillyTavernService) GetWorld(user, world string) (*models.Lorebook, error) {
+	if user == "" {
+		user = s.defaultUser
+	}
+
+	worldPath, err := s.worldPath(user, world)
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := readWorldFile(worldPath)
+	if err != nil {
+		return nil, err
+	}
+
+	return file.lorebook(world), nil
+}
+
+// SaveWorldEntries adds entries without a UID to the lorebook and updates the keys, comment and content of entries
+// with a UID. The lorebook is created if it does not exist.
+func (s *SillyTavernService) SaveWorldEntries(user, world string, entries []models.WorldInfoEntry) (*models.Lorebook, error) {
+	if user == "" {
+		user = s.defaultUser
+	}
+
+	worldPath, err := s.worldPath(user, world)
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := readWorldFile(worldPath)
+	if err != nil && !strings.Contains(err.Error(), "does not exist") {
+		return nil, err
+	}
+	if file == nil {
+		file = &worldFile{
+			fields:  map[string]json.RawMessage{},
+			entries: map[string]map[string]json.RawMessage{},
+		}
+	}
+
+	nextUID := 0
+	for _, entry := range file.entries {
+		var uid int
+		if err := json.Unmarshal(entry["uid"], &uid); err == nil && uid >= nextUID {
+			nextUID = uid + 1
+		}
+	}
+
+	for _, entry := range entries {
+		if len(entry.Keys) == 0 || strings.TrimSpace(entry.Content) == "" {
+			return nil, fmt.Errorf("invalid world info entry: keys and content are required")
+		}
+
+		if entry.UID == nil {
+			raw, err := json.Marshal(newWorldInfoEntry(nextUID, entry))
+			if err != nil {
+				return nil, fmt.Errorf("failed to encode world info entry: %w", err)
+			}
+
+			var fields map[string]json.RawMessage
+			if err := json.Unmarshal(raw, &fields); err != nil {
+				return nil, fmt.Errorf("failed to encode world info entry: %w", err)
+			}
+
+			file.entries[strconv.Itoa(nextUID)] = fields
+			nextUID++
+			continue
+		}
+
+		existing, ok := file.entries[strconv.Itoa(*entry.UID)]
+		if !ok {
+			return nil, fmt.Errorf("world info entry does not exist: %d", *entry.UID)
+		}
+
+		for field, value := range map[string]interface{}{
+			"key":     entry.Keys,
+			"comment": entry.Comment,
+			"content": entry.Content,
+		} {
+			if existing[field], err = json.Marshal(value); err != nil {
+				return nil, fmt.Errorf("failed to encode world info entry: %w", err)
+			}
+		}
+		if entry.SecondaryKeys != nil {
+			if existing["keysecondary"], err = json.Marshal(entry.SecondaryKeys); err != nil {
+				return nil, fmt.Errorf("failed to encode world info entry: %w", err)
+			}
+		}
+	}
+
+	if err := file.write(worldPath); err != nil {
+		return nil, err
+	}
+
+	return file.lorebook(world), nil
+}
+
+func (s *SillyTavernService) worldPath(user, world string) (string, error) {
+	// Prevent directory traversal attempts
+	if strings.Contains(user, "..") || strings.Contains(world, "..") || strings.ContainsAny(world, `/\`) || world == "" {
+		return "", fmt.Errorf("invalid world name")
+	}
+
+	return filepath.Join(s.dataPath, user, s.worldsPath, world+".json"), nil
+}
+
+func readWorldFile(worldPath string) (*worldFile, error) {
+	content, err := os.ReadFile(worldPath)
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("world file does not exist: %s", filepath.Base(worldPath))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read world file: %w", err)
+	}
+
+	file := &worldFile{
+		entries: map[string]map[string]json.RawMessage{},
+	}
+	if err := json.Unmarshal(content, &file.fields); err != nil {
+		return nil, fmt.Errorf("failed to parse world file: %w", err)
+	}
+
+	if rawEntries, ok := file.fields["entries"]; ok {
+		if err := json.Unmarshal(rawEntries, &file.entries); err != nil {
+			return nil, fmt.Errorf("failed to parse world file entries: %w", err)
+		}
+	}
+
+	return file, nil
+}
+
+func (f *worldFile) lorebook(name string) *models.Lorebook {
+	lorebook := &models.Lorebook{
+		Name:    name,
+		Entries: []models.WorldInfoEntry{},
+	}
+
+	for _, fields := range f.entries {
+		var entry struct {
+			UID          int      `json:"uid"`
+			Key          []string `json:"key"`
+			KeySecondary []string `json:"keysecondary"`
+			Comment      string   `json:"comment"`
+			Content      string   `json:"content"`
+			Disable      bool     `json:"disable"`
+		}
+
+		raw, err := json.Marshal(fields)
+		if err != nil || json.Unmarshal(raw, &entry) != nil {
+			continue
+		}
+
+		uid := entry.UID
+		lorebook.Entries = append(lorebook.Entries, models.WorldInfoEntry{
+			UID:           &uid,
+			Keys:          entry.Key,
+			SecondaryKeys: entry.KeySecondary,
+			Comment:       entry.Comment,
+			Content:       entry.Content,
+			Disabled:      entry.Disable,
+		})
+	}
+
+	sort.Slice(lorebook.Entries, func(i, j int) bool {
+		return *lorebook.Entries[i].UID < *lorebook.Entries[j].UID
+	})
+
+	return lorebook
+}
+
+func (f *worldFile) write(worldPath string) error {
+	rawEntries, err := json.Marshal(f.entries)
+	if err != nil {
+		return fmt.Errorf("failed to encode world file: %w", err)
+	}
+	f.fields["entries"] = rawEntries
+
+	// SillyTavern writes lorebooks with 4 space indentation
+	content, err := json.MarshalIndent(f.fields, "", "    ")
+	if err != nil {
+		return fmt.Errorf("failed to encode world file: %w", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(worldPath), os.ModePerm); err != nil {
+		return fmt.Errorf("failed to create worlds directory: %w", err)
+	}
+
+	if err := writeFileAtomic(worldPath, content); err != nil {
+		return fmt.Errorf("failed to write world file: %w", err)
+	}
+
+	return nil
+}
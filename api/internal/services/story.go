@@ -0,0 +1,150 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"craigstjean.com/stsummarizer/internal/config"
+	"craigstjean.com/stsummarizer/internal/models"
+)
+
+// storyChat is a chat of the story along with the hashes of its messages, used to find its branches
+type storyChat struct {
+	models.StoryChat
+	hashes []uint64
+}
+
+// Story summarizes every chat of the request's character, reusing cached summaries when the request allows it, and
+// merges the summaries oldest first into one story so far. Chats that share their first messages with an earlier chat
+// are flagged as branches of it, comparing the hashes of their messages so only one chat is held in memory at a time.
+// Up to summary.concurrency chats are summarized at once. A chat that cannot be summarized is reported in its entry and
+// left out of the story.
+func (s *Summarizer) Story(ctx context.Context, req SummaryRequest) (*models.Story, error) {
+	req.Group = false
+	req = req.withDefaults()
+
+	names, err := s.stService.GetCharacterChats(req.User, req.Character)
+	if err != nil {
+		return nil, err
+	}
+
+	chats := make([]*storyChat, 0, len(names))
+	for _, name := range names {
+		chat := &storyChat{StoryChat: models.StoryChat{Chat: name}}
+		chats = append(chats, chat)
+
+		messages, info, _, err := s.loadChat(ctx, models.ChatRef{User: req.User, Character: req.Character, Chat: name}, req.Lenient)
+		if err != nil {
+			chat.Error = err.Error()
+			continue
+		}
+		// Without a readable header the file name or modification time dates the chat
+		header, _ := s.stService.GetCharacterChatHeader(req.User, req.Character, name)
+
+		chat.hashes = hashMessages(messages)
+		chat.MessageCount = len(messages)
+		chat.Created, chat.CreatedFrom = ChatCreated(header, name, info)
+	}
+
+	sort.SliceStable(chats, func(i, j int) bool {
+		return chats[i].Created.Before(chats[j].Created)
+	})
+
+	findStoryBranches(chats)
+
+	// Summarize reads each chat again, in parallel up to the configured concurrency
+	var wg sync.WaitGroup
+	slots := make(chan struct{}, max(config.Get().Summary.Concurrency, 1))
+	for _, chat := range chats {
+		if chat.Error != "" {
+			continue
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			slots <- struct{}{}
+			defer func() { <-slots }()
+
+			chatReq := req
+			chatReq.Chat = chat.Chat
+			chatReq.PriorSummary = ""
+			summary, _, err := s.Summarize(ctx, chatReq)
+			if err != nil {
+				chat.Error = err.Error()
+				return
+			}
+			chat.Summary = summary.Summaries[len(summary.Summaries)-1]
+		}()
+	}
+	wg.Wait()
+
+	sections := make([]string, 0, len(chats))
+	latest := ""
+	for _, chat := range chats {
+		if chat.Error != "" {
+			continue
+		}
+		latest = chat.Chat
+
+		section := fmt.Sprintf("Chat %q, started %s", chat.Chat, chat.Created.Format("2006-01-02 15:04"))
+		if chat.BranchOf != "" {
+			section += fmt.Sprintf(", a branch of %q after its first %d messages", chat.BranchOf, chat.ForkIndex)
+		}
+		sections = append(sections, section+":\n"+strings.TrimSpace(chat.Summary))
+	}
+
+	story := &models.Story{
+		User:      req.User,
+		Character: req.Character,
+		Chats:     make([]models.StoryChat, 0, len(chats)),
+	}
+	for _, chat := range chats {
+		story.Chats = append(story.Chats, chat.StoryChat)
+	}
+
+	if len(sections) > 0 {
+		opts := SummaryOptions{
+			Model:        req.Model,
+			MaxTokens:    req.MaxTokens,
+			WordLimit:    req.WordLimit,
+			PriorSummary: req.PriorSummary,
+			Cleaner:      s.cleaner,
+		}
+		if req.IncludeCards {
+			// The persona is found from the latest chat, read once more now that the others are no longer held
+			messages, _, _, err := s.loadChat(ctx, models.ChatRef{User: req.User, Character: req.Character, Chat: latest}, req.Lenient)
+			if err != nil {
+				return nil, err
+			}
+			opts.Context = BuildSummaryContext(s.stService, req.User, []string{req.Character}, messages)
+			opts.ContextTokens = req.ContextTokens
+		}
+
+		if story.Story, err = s.ollamaService.MergeSummaries(ctx, sections, opts); err != nil {
+			return nil, err
+		}
+	}
+
+	return story, nil
+}
+
+// findStoryBranches marks each chat as a branch of the earlier chat it shares the most first messages with
+func findStoryBranches(chats []*storyChat) {
+	hashes := make([][]uint64, len(chats))
+	for i, chat := range chats {
+		hashes[i] = chat.hashes
+		chat.hashes = nil
+	}
+
+	parents, forks := findBranchParents(hashes)
+	for i, chat := range chats {
+		if parents[i] >= 0 {
+			chat.BranchOf = chats[parents[i]].Chat
+			chat.ForkIndex = forks[i]
+		}
+	}
+}
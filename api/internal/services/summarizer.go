@@ -0,0 +1,361 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"craigstjean.com/stsummarizer/internal/config"
+	"craigstjean.com/stsummarizer/internal/metrics"
+	"craigstjean.com/stsummarizer/internal/models"
+	"craigstjean.com/stsummarizer/internal/tracing"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// SummaryRequest describes which chat to summarize and how
+type SummaryRequest struct {
+	models.ChatRef
+	Model         string `json:"model"`
+	MaxTokens     int    `json:"max_tokens"`
+	WordLimit     int    `json:"summary_words"`
+	IncludeCards  bool   `json:"include_cards"`
+	ContextTokens int    `json:"context_tokens"`
+
+	// From and To select messages by index (To is inclusive), Since and Until by send_date
+	From  *int       `json:"from,omitempty"`
+	To    *int       `json:"to,omitempty"`
+	Since *time.Time `json:"since,omitempty"`
+	Until *time.Time `json:"until,omitempty"`
+	// PriorSummary summarizes what came before the range, it is given to the model as context
+	PriorSummary string `json:"prior_summary,omitempty"`
+	// Regex applies the user's SillyTavern regex scripts, RegexDisplay or RegexPrompt, none when empty
+	Regex string `json:"regex,omitempty"`
+	// Visibility is the policy for hidden and system messages, VisibilityPrompt when empty
+	Visibility string `json:"visibility,omitempty"`
+	// Scenes ends chunks at scene breaks when possible, SceneGap is the pause in send_date that counts as one (none when 0)
+	Scenes   bool          `json:"scenes,omitempty"`
+	SceneGap time.Duration `json:"scene_gap,omitempty"`
+	// Lenient skips chat lines that cannot be parsed instead of failing
+	Lenient bool `json:"lenient,omitempty"`
+
+	// UseCache returns the cached summary instead of generating one when it is still current
+	UseCache bool `json:"-"`
+}
+
+// Summarizer loads chats, summarizes them and keeps the summary cache up to date.
+// It is shared by the HTTP handlers and the automatic summarization rules.
+type Summarizer struct {
+	stService     SillyTavernService
+	ollamaService *OllamaService
+	cache         *SummaryCache
+	cleaner       *Cleaner
+}
+
+// NewSummarizer creates a summarizer, cleaner may be nil to send messages as they are
+func NewSummarizer(stService SillyTavernService, ollamaService *OllamaService, cache *SummaryCache, cleaner *Cleaner) *Summarizer {
+	return &Summarizer{
+		stService:     stService,
+		ollamaService: ollamaService,
+		cache:         cache,
+		cleaner:       cleaner,
+	}
+}
+
+// LoadChat returns the messages of a character or group chat along with the state of its file
+func (s *Summarizer) LoadChat(ref models.ChatRef) ([]models.ChatMessage, *models.ChatFileInfo, error) {
+	messages, info, _, err := s.loadChat(context.Background(), ref, false)
+	return messages, info, err
+}
+
+// loadChat reads the chat, in lenient mode the lines that could not be parsed are returned instead of an error
+func (s *Summarizer) loadChat(ctx context.Context, ref models.ChatRef, lenient bool) (messages []models.ChatMessage, info *models.ChatFileInfo, skipped []models.ChatLineError, err error) {
+	name := "GetCharacterChat"
+	if ref.Group {
+		name = "GetGroupChat"
+	}
+	_, span := tracing.Start(ctx, name,
+		attribute.String("chat.user", ref.User),
+		attribute.String("chat.character", ref.Character),
+		attribute.String("chat.name", ref.Chat),
+		attribute.Bool("chat.lenient", lenient))
+	defer func() {
+		span.SetAttributes(attribute.Int("chat.messages", len(messages)), attribute.Int("chat.skipped_lines", len(skipped)))
+		tracing.End(span, err)
+	}()
+
+	messages, info, skipped, err = s.readChat(ref, lenient)
+	return messages, info, skipped, err
+}
+
+func (s *Summarizer) readChat(ref models.ChatRef, lenient bool) ([]models.ChatMessage, *models.ChatFileInfo, []models.ChatLineError, error) {
+	var messages []models.ChatMessage
+	var skipped []models.ChatLineError
+	var info *models.ChatFileInfo
+	var err error
+
+	if ref.Group {
+		if info, err = s.stService.GetGroupChatInfo(ref.User, ref.Chat); err != nil {
+			return nil, nil, nil, err
+		}
+		if lenient {
+			messages, skipped, err = s.stService.GetGroupChatLenient(ref.User, ref.Chat)
+		} else {
+			messages, err = s.stService.GetGroupChat(ref.User, ref.Chat)
+		}
+	} else {
+		if info, err = s.stService.GetCharacterChatInfo(ref.User, ref.Character, ref.Chat); err != nil {
+			return nil, nil, nil, err
+		}
+		if lenient {
+			messages, skipped, err = s.stService.GetCharacterChatLenient(ref.User, ref.Character, ref.Chat)
+		} else {
+			messages, err = s.stService.GetCharacterChat(ref.User, ref.Character, ref.Chat)
+		}
+	}
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	return messages, info, skipped, nil
+}
+
+// Measure returns the number of messages and tokens that would be sent to the summarizer for the chat
+func (s *Summarizer) Measure(ref models.ChatRef, visibility string) (int, int, error) {
+	messages, _, err := s.LoadChat(ref)
+	if err != nil {
+		return 0, 0, err
+	}
+	if visibility == "" {
+		visibility = VisibilityPrompt
+	}
+
+	messageCount, tokenCount := s.measure(RenderMessagesForSummary(s.clean(ApplyVisibility(messages, visibility))))
+	return messageCount, tokenCount, nil
+}
+
+// Cached returns the cached summary of the chat, whether or not it is current, or nil when there is none
+func (s *Summarizer) Cached(ref models.ChatRef) (*models.CachedSummary, error) {
+	if ref.User == "" {
+		ref.User = config.STDefaultUser
+	}
+	if ref.Group {
+		ref.Character = ""
+	}
+
+	return s.cache.Get(ref)
+}
+
+// Summarize returns the summaries for the chat, partial summaries first and the final summary last.
+// The result is stored in the summary cache, the second return value reports whether it came from the cache.
+func (s *Summarizer) Summarize(ctx context.Context, req SummaryRequest) (*models.CachedSummary, bool, error) {
+	req = req.withDefaults()
+
+	// Batches and rules summarize outside of a request, this span is the root of their traces
+	ctx, span := tracing.Start(ctx, "Summarize",
+		attribute.String("chat.user", req.User),
+		attribute.String("chat.character", req.Character),
+		attribute.String("chat.name", req.Chat),
+		attribute.Bool("chat.group", req.Group))
+	defer span.End()
+
+	options := req.optionsKey()
+	if s.cleaner != nil {
+		options += " clean:" + s.cleaner.Fingerprint()
+	}
+
+	messages, info, skipped, err := s.loadChat(ctx, req.ChatRef, req.Lenient)
+	if err != nil {
+		return nil, false, err
+	}
+
+	// The cache holds one summary per chat, summaries of a part of the chat are not stored
+	partial := req.hasRange() || req.PriorSummary != ""
+
+	if req.UseCache && !partial {
+		cached, err := s.cache.Get(req.ChatRef)
+		if err != nil {
+			slog.WarnContext(ctx, "summary cache read failed", "error", err)
+		} else if IsCurrent(cached, info, options) {
+			metrics.CacheLookups.WithLabelValues("hit").Inc()
+			span.SetAttributes(attribute.Bool("summary.cached", true))
+			return cached, true, nil
+		}
+		metrics.CacheLookups.WithLabelValues("miss").Inc()
+	}
+
+	messages, opts, err := s.prepare(req, messages)
+	if err != nil {
+		return nil, false, err
+	}
+
+	messageContent := RenderMessagesForSummary(messages)
+
+	start := time.Now()
+	summaries, err := s.ollamaService.SummarizeChat(ctx, messageContent, opts)
+	if err != nil {
+		return nil, false, err
+	}
+
+	// A chat that fits one chunk has a single summary, longer chats add the consolidated one to the partial summaries
+	chunks := max(len(summaries)-1, 1)
+	metrics.SummaryDuration.Observe(time.Since(start).Seconds())
+	metrics.SummaryChunks.Observe(float64(chunks))
+	span.SetAttributes(attribute.Int("summary.chunks", chunks))
+
+	messageCount, tokenCount := s.measure(messageContent)
+	summary := &models.CachedSummary{
+		ChatRef:      req.ChatRef,
+		Options:      options,
+		Summaries:    summaries,
+		MessageCount: messageCount,
+		TokenCount:   tokenCount,
+		Source:       *info,
+		Skipped:      skipped,
+		CreatedAt:    time.Now(),
+	}
+
+	slog.InfoContext(ctx, "summary generated", "user", req.User, "character", req.Character, "chat", req.Chat,
+		"group", req.Group, "messages", messageCount, "tokens", tokenCount, "chunks", chunks, "duration", time.Since(start))
+
+	if !partial {
+		if err := s.cache.Put(summary); err != nil {
+			slog.WarnContext(ctx, "summary cache write failed", "error", err)
+		}
+	}
+
+	return summary, false, nil
+}
+
+// Plan returns how the chat would be chunked for the request and how many tokens the summary would cost
+func (s *Summarizer) Plan(ctx context.Context, req SummaryRequest) (*models.SummaryPlan, []models.ChatLineError, error) {
+	req = req.withDefaults()
+
+	messages, _, skipped, err := s.loadChat(ctx, req.ChatRef, req.Lenient)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	messages, opts, err := s.prepare(req, messages)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	names := make(map[int]string, len(messages))
+	for _, message := range messages {
+		names[message.Index] = message.Name
+	}
+
+	messageContent, indexes := renderMessagesWithIndexes(messages)
+	plan, err := s.ollamaService.PlanSummary(messageContent, indexes, opts)
+	if err != nil {
+		return nil, skipped, err
+	}
+	for i := range plan.Messages {
+		plan.Messages[i].Name = names[plan.Messages[i].Index]
+	}
+
+	return &plan, skipped, nil
+}
+
+// prepare turns the loaded chat into the messages sent to the summarizer and the options to summarize them with
+func (s *Summarizer) prepare(req SummaryRequest, messages []models.ChatMessage) ([]models.ChatMessage, SummaryOptions, error) {
+	if req.Regex != "" {
+		messages = s.applyRegex(req, messages)
+	}
+
+	// Ranges count every message, as the chat view does, so visibility is applied after selecting them
+	messages, err := selectMessages(messages, req)
+	if err != nil {
+		return nil, SummaryOptions{}, err
+	}
+	messages = s.clean(ApplyVisibility(messages, req.Visibility))
+
+	opts := SummaryOptions{
+		Model:        req.Model,
+		MaxTokens:    req.MaxTokens,
+		WordLimit:    req.WordLimit,
+		PriorSummary: req.PriorSummary,
+		Cleaner:      s.cleaner,
+	}
+	if req.IncludeCards {
+		opts.Context = BuildSummaryContext(s.stService, req.User, s.characters(req.ChatRef), messages)
+		opts.ContextTokens = req.ContextTokens
+	}
+	if req.Scenes {
+		opts.SceneStarts = findSceneStarts(messages, req.SceneGap)
+	}
+
+	return messages, opts, nil
+}
+
+// characters returns the character of a chat, or the members of a group chat
+func (s *Summarizer) characters(ref models.ChatRef) []string {
+	if ref.Group {
+		return GroupMembers(s.stService, ref.User, ref.Chat)
+	}
+
+	return []string{ref.Character}
+}
+
+// applyRegex runs the regex scripts over the whole chat, before a range is selected, so depth limits count from the
+// last message of the chat
+func (s *Summarizer) applyRegex(req SummaryRequest, messages []models.ChatMessage) []models.ChatMessage {
+	scripts, err := s.stService.GetRegexScripts(req.User, s.characters(req.ChatRef))
+	if err != nil {
+		slog.Warn("regex scripts not applied", "user", req.User, "error", err)
+		return messages
+	}
+
+	return ApplyRegexScripts(messages, scripts, req.Regex, 0, len(messages))
+}
+
+// clean runs the messages through the cleaning pipeline, when there is one
+func (s *Summarizer) clean(messages []models.ChatMessage) []models.ChatMessage {
+	if s.cleaner == nil {
+		return messages
+	}
+
+	return s.cleaner.CleanMessages(messages)
+}
+
+func (s *Summarizer) measure(messageContent []string) (int, int) {
+	messageCount := 0
+	tokenCount := 0
+	for _, message := range messageContent {
+		if message == "" {
+			continue
+		}
+		messageCount++
+		tokenCount += s.ollamaService.countTokens(message)
+	}
+
+	return messageCount, tokenCount
+}
+
+func (r SummaryRequest) withDefaults() SummaryRequest {
+	if r.User == "" {
+		r.User = config.STDefaultUser
+	}
+	if r.Group {
+		r.Character = ""
+	}
+	if r.Visibility == "" {
+		r.Visibility = VisibilityPrompt
+	}
+
+	return r
+}
+
+// optionsKey identifies the options a summary was generated with, so cached summaries are only reused for equal requests
+func (r SummaryRequest) optionsKey() string {
+	r.ChatRef = models.ChatRef{}
+
+	key, err := json.Marshal(r)
+	if err != nil {
+		return fmt.Sprintf("%+v", r)
+	}
+
+	return string(key)
+}
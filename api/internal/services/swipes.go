@@ -0,0 +1,104 @@
+package services
+
+import (
+	"encoding/json"
+	"strings"
+
+	"craigstjean.com/stsummarizer/internal/models"
+)
+
+// ActiveSwipe returns the text of the swipe SillyTavern shows for the message, falling back to mes
+func ActiveSwipe(message models.ChatMessage) string {
+	if message.SwipeID != nil && *message.SwipeID >= 0 && *message.SwipeID < len(message.Swipes) {
+		if text := message.Swipes[*message.SwipeID]; text != "" {
+			return text
+		}
+	}
+
+	return message.Message
+}
+
+// MessageSwipes lists every swipe of the message with the active one marked. A message without swipes has a single
+// active swipe holding mes.
+func MessageSwipes(message models.ChatMessage) []models.Swipe {
+	texts := message.Swipes
+	active := 0
+	if len(texts) == 0 {
+		texts = []string{message.Message}
+	} else if message.SwipeID != nil && *message.SwipeID >= 0 && *message.SwipeID < len(texts) {
+		active = *message.SwipeID
+	}
+
+	activeWords := wordSet(ActiveSwipe(message))
+	swipes := make([]models.Swipe, len(texts))
+	for i, text := range texts {
+		if i == active {
+			text = ActiveSwipe(message)
+		}
+
+		swipe := models.Swipe{
+			Index:      i,
+			Text:       text,
+			Active:     i == active,
+			Words:      len(strings.Fields(text)),
+			Similarity: similarity(activeWords, wordSet(text)),
+		}
+		if i < len(message.SwipeInfo) {
+			swipe.Model = message.SwipeInfo[i].Extra.Model
+			swipe.SendDate = rawDateString(message.SwipeInfo[i].SendDate)
+		}
+		swipes[i] = swipe
+	}
+
+	return swipes
+}
+
+// CompareSwipes lists the swipes of the message at the given index side by side
+func CompareSwipes(index int, message models.ChatMessage) models.SwipeComparison {
+	comparison := models.SwipeComparison{
+		Index:  index,
+		Name:   message.Name,
+		Swipes: MessageSwipes(message),
+	}
+	for _, swipe := range comparison.Swipes {
+		if swipe.Active {
+			comparison.Active = swipe.Index
+		}
+	}
+
+	return comparison
+}
+
+func wordSet(text string) map[string]bool {
+	words := make(map[string]bool)
+	for _, word := range strings.Fields(strings.ToLower(text)) {
+		words[strings.Trim(word, `.,!?;:"'()*_`)] = true
+	}
+
+	return words
+}
+
+// similarity is the Jaccard index of two word sets, 1 for identical vocabularies
+func similarity(a, b map[string]bool) float64 {
+	if len(a) == 0 && len(b) == 0 {
+		return 1
+	}
+
+	shared := 0
+	for word := range a {
+		if b[word] {
+			shared++
+		}
+	}
+
+	return float64(shared) / float64(len(a)+len(b)-shared)
+}
+
+func rawDateString(raw json.RawMessage) string {
+	var value string
+	if err := json.Unmarshal(raw, &value); err == nil {
+		return value
+	}
+
+	return string(raw)
+}
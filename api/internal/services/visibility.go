@@ -0,0 +1,66 @@
+package services
+
+import (
+	"fmt"
+
+	"craigstjean.com/stsummarizer/internal/models"
+)
+
+// Visibility policies decide which hidden and system messages are rendered and summarized
+const (
+	// VisibilityPrompt keeps what SillyTavern sends to the model: hidden messages and system notes are left out,
+	// narrator (/sys) messages are kept
+	VisibilityPrompt = "prompt"
+	// VisibilityNarration also leaves out hidden messages, but keeps system notes and narrator messages as narration
+	VisibilityNarration = "narration"
+	// VisibilityAll keeps every message
+	VisibilityAll = "all"
+)
+
+// narratorType is the extra.type of messages sent with /sys, which SillyTavern includes in the prompt
+const narratorType = "narrator"
+
+// narratorName is the name system and narrator messages are rendered with under VisibilityNarration
+const narratorName = "Narration"
+
+// ParseVisibility validates a visibility policy, an empty value selects the fallback
+func ParseVisibility(value, fallback string) (string, error) {
+	switch value {
+	case "":
+		return fallback, nil
+	case VisibilityPrompt, VisibilityNarration, VisibilityAll:
+		return value, nil
+	default:
+		return "", fmt.Errorf("invalid visibility: %s (expected %s, %s or %s)", value, VisibilityPrompt, VisibilityNarration, VisibilityAll)
+	}
+}
+
+// ApplyVisibility returns the messages kept by the policy. SillyTavern marks both hidden ("ghosted") messages and
+// its own notes with is_system, the notes also carry an extra.type. Narrator messages are not system messages until
+// they are hidden.
+func ApplyVisibility(messages []models.ChatMessage, policy string) []models.ChatMessage {
+	if policy == VisibilityAll || policy == "" {
+		return messages
+	}
+
+	visible := make([]models.ChatMessage, 0, len(messages))
+	for _, message := range messages {
+		narrator := message.Extra.Type == narratorType
+		hidden := message.IsSystem && (message.Extra.Type == "" || narrator)
+		note := message.IsSystem && !hidden && !narrator
+
+		switch {
+		case hidden:
+			continue
+		case note && policy == VisibilityPrompt:
+			continue
+		case (note || narrator) && policy == VisibilityNarration:
+			message.Name = narratorName
+			message.IsUser = false
+		}
+
+		visible = append(visible, message)
+	}
+
+	return visible
+}
@@ -0,0 +1,380 @@
+package watcher
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"craigstjean.com/stsummarizer/internal/config"
+	"craigstjean.com/stsummarizer/internal/models"
+	"github.com/fsnotify/fsnotify"
+)
+
+// debounceDelay coalesces the burst of events SillyTavern produces while saving a chat
+const debounceDelay = 500 * time.Millisecond
+
+// Watcher watches the chats, group chats and backups of every SillyTavern user and publishes ChatEvents
+type Watcher struct {
+	dataPath       string
+	chatsPath      string
+	groupChatsPath string
+	backupsPath    string
+
+	fsWatcher *fsnotify.Watcher
+
+	mu           sync.Mutex
+	messageCount map[string]int // file path -> last known message count
+	pending      map[string]*time.Timer
+	subscribers  map[chan models.ChatEvent]struct{}
+
+	done chan struct{}
+}
+
+func NewWatcher() (*Watcher, error) {
+	fsWatcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create file watcher: %w", err)
+	}
+
+	w := &Watcher{
+		dataPath:       config.GetSTDataPath(),
+		chatsPath:      config.STChatsPath,
+		groupChatsPath: config.STGroupChatsPath,
+		backupsPath:    config.STBackupsPath,
+		fsWatcher:      fsWatcher,
+		messageCount:   make(map[string]int),
+		pending:        make(map[string]*time.Timer),
+		subscribers:    make(map[chan models.ChatEvent]struct{}),
+		done:           make(chan struct{}),
+	}
+
+	if err := w.addUsers(); err != nil {
+		fsWatcher.Close()
+		return nil, err
+	}
+
+	go w.run()
+
+	return w, nil
+}
+
+// Subscribe returns a channel receiving every event, and a function to stop the subscription.
+// Events are dropped for subscribers that do not keep up.
+func (w *Watcher) Subscribe() (<-chan models.ChatEvent, func()) {
+	events := make(chan models.ChatEvent, 64)
+
+	w.mu.Lock()
+	w.subscribers[events] = struct{}{}
+	w.mu.Unlock()
+
+	var once sync.Once
+	return events, func() {
+		once.Do(func() {
+			w.mu.Lock()
+			delete(w.subscribers, events)
+			w.mu.Unlock()
+			close(events)
+		})
+	}
+}
+
+func (w *Watcher) Close() error {
+	close(w.done)
+	return w.fsWatcher.Close()
+}
+
+// addUsers watches the chat directories of every user, recording the current message counts. The data directory
+// itself is watched for users created later.
+func (w *Watcher) addUsers() error {
+	if err := w.fsWatcher.Add(w.dataPath); err != nil {
+		return fmt.Errorf("failed to watch SillyTavern data directory: %w", err)
+	}
+
+	entries, err := os.ReadDir(w.dataPath)
+	if err != nil {
+		return fmt.Errorf("failed to read SillyTavern data directory: %w", err)
+	}
+
+	for _, entry := range entries {
+		if entry.IsDir() && !strings.HasPrefix(entry.Name(), "_") {
+			w.addUser(filepath.Join(w.dataPath, entry.Name()))
+		}
+	}
+
+	return nil
+}
+
+// addUser watches the user's directory, for chat directories created later, and the chat directories it has
+func (w *Watcher) addUser(userPath string) {
+	if err := w.fsWatcher.Add(userPath); err != nil {
+		slog.Warn("watcher failed to watch directory", "path", userPath, "error", err)
+		return
+	}
+
+	w.addDir(filepath.Join(userPath, w.groupChatsPath))
+	w.addDir(filepath.Join(userPath, w.backupsPath))
+	w.addChats(filepath.Join(userPath, w.chatsPath))
+}
+
+// addChats watches the user's chats directory and the chat directory of each character in it
+func (w *Watcher) addChats(chatsPath string) {
+	w.addDir(chatsPath)
+
+	characters, err := os.ReadDir(chatsPath)
+	if err != nil {
+		return
+	}
+	for _, character := range characters {
+		if character.IsDir() {
+			w.addDir(filepath.Join(chatsPath, character.Name()))
+		}
+	}
+}
+
+// addDir watches a directory and records the message counts of its chat files; missing directories are ignored
+func (w *Watcher) addDir(path string) {
+	if err := w.fsWatcher.Add(path); err != nil {
+		if !os.IsNotExist(err) {
+			slog.Warn("watcher failed to watch directory", "path", path, "error", err)
+		}
+		return
+	}
+
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		return
+	}
+
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".jsonl") {
+			continue
+		}
+
+		filePath := filepath.Join(path, entry.Name())
+		if count, err := countMessages(filePath); err == nil {
+			w.mu.Lock()
+			w.messageCount[filePath] = count
+			w.mu.Unlock()
+		}
+	}
+}
+
+func (w *Watcher) run() {
+	for {
+		select {
+		case <-w.done:
+			return
+		case event, ok := <-w.fsWatcher.Events:
+			if !ok {
+				return
+			}
+			w.handle(event)
+		case err, ok := <-w.fsWatcher.Errors:
+			if !ok {
+				return
+			}
+			slog.Warn("watcher error", "error", err)
+		}
+	}
+}
+
+func (w *Watcher) handle(event fsnotify.Event) {
+	// New users, their chat directories and new character directories need their own watch
+	if event.Has(fsnotify.Create) {
+		if info, err := os.Stat(event.Name); err == nil && info.IsDir() {
+			w.addCreatedDir(event.Name)
+			return
+		}
+	}
+
+	if !strings.HasSuffix(event.Name, ".jsonl") || event.Has(fsnotify.Chmod) && !event.Has(fsnotify.Write) {
+		return
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if timer, ok := w.pending[event.Name]; ok {
+		timer.Reset(debounceDelay)
+		return
+	}
+
+	path := event.Name
+	w.pending[path] = time.AfterFunc(debounceDelay, func() {
+		w.mu.Lock()
+		delete(w.pending, path)
+		w.mu.Unlock()
+
+		w.publishChange(path)
+	})
+}
+
+// addCreatedDir watches a directory created under the data directory when it holds chats
+func (w *Watcher) addCreatedDir(path string) {
+	parent := filepath.Dir(path)
+	name := filepath.Base(path)
+
+	switch {
+	case parent == filepath.Clean(w.dataPath):
+		if !strings.HasPrefix(name, "_") {
+			w.addUser(path)
+		}
+	case filepath.Dir(parent) == filepath.Clean(w.dataPath):
+		switch name {
+		case w.chatsPath:
+			w.addChats(path)
+		case w.groupChatsPath, w.backupsPath:
+			w.addDir(path)
+		}
+	case filepath.Base(parent) == w.chatsPath:
+		w.addDir(path)
+	}
+}
+
+// publishChange compares the file's message count with the last known count and publishes the matching event
+func (w *Watcher) publishChange(path string) {
+	event, ok := w.classify(path)
+	if !ok {
+		return
+	}
+
+	w.mu.Lock()
+	previous, known := w.messageCount[path]
+	w.mu.Unlock()
+
+	count, err := countMessages(path)
+	if os.IsNotExist(err) {
+		w.mu.Lock()
+		delete(w.messageCount, path)
+		w.mu.Unlock()
+
+		switch event.Type {
+		case models.ChatUpdated:
+			event.Type = models.ChatDeleted
+		case models.GroupChatUpdated:
+			event.Type = models.GroupChatDeleted
+		default:
+			return
+		}
+		event.NewMessages = -previous
+		w.publish(event)
+		return
+	}
+	if err != nil {
+		slog.Warn("watcher failed to read chat", "path", path, "error", err)
+		return
+	}
+
+	w.mu.Lock()
+	w.messageCount[path] = count
+	w.mu.Unlock()
+
+	event.MessageCount = count
+	event.NewMessages = count - previous
+
+	switch {
+	case event.Type == models.BackupCreated:
+		if known {
+			return // Backups are written once, later writes are not new backups
+		}
+	case !known && event.Type == models.ChatUpdated:
+		event.Type = models.ChatCreated
+	case !known && event.Type == models.GroupChatUpdated:
+		event.Type = models.GroupChatCreated
+	case event.NewMessages == 0:
+		return // Saved without message changes, e.g. metadata only
+	}
+
+	w.publish(event)
+}
+
+// classify maps a file path to the user, character and chat it belongs to
+func (w *Watcher) classify(path string) (models.ChatEvent, bool) {
+	rel, err := filepath.Rel(w.dataPath, path)
+	if err != nil {
+		return models.ChatEvent{}, false
+	}
+
+	parts := strings.Split(filepath.ToSlash(rel), "/")
+	name := strings.TrimSuffix(parts[len(parts)-1], ".jsonl")
+	event := models.ChatEvent{
+		User: parts[0],
+		Time: time.Now(),
+	}
+
+	switch {
+	case len(parts) == 4 && parts[1] == w.chatsPath:
+		event.Type = models.ChatUpdated
+		event.Character = parts[2]
+		event.Chat = name
+	case len(parts) == 3 && parts[1] == w.groupChatsPath:
+		event.Type = models.GroupChatUpdated
+		event.Chat = name
+	case len(parts) == 3 && parts[1] == w.backupsPath:
+		event.Type = models.BackupCreated
+		event.Backup = parts[2]
+	default:
+		return models.ChatEvent{}, false
+	}
+
+	return event, true
+}
+
+func (w *Watcher) publish(event models.ChatEvent) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	for subscriber := range w.subscribers {
+		select {
+		case subscriber <- event:
+		default:
+		}
+	}
+}
+
+// countMessages counts the non-empty lines of a chat file, excluding the metadata header
+func countMessages(path string) (int, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	lines := 0
+	inLine := false
+	buf := make([]byte, 64*1024)
+	for {
+		n, err := file.Read(buf)
+		chunk := buf[:n]
+		for len(chunk) > 0 {
+			i := bytes.IndexByte(chunk, '\n')
+			if i < 0 {
+				inLine = inLine || len(bytes.TrimSpace(chunk)) > 0
+				break
+			}
+			if inLine || len(bytes.TrimSpace(chunk[:i])) > 0 {
+				lines++
+			}
+			inLine = false
+			chunk = chunk[i+1:]
+		}
+
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return 0, err
+		}
+	}
+	if inLine {
+		lines++
+	}
+
+	return max(lines-1, 0), nil
+}
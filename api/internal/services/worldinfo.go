@@ -0,0 +1,221 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"craigstjean.com/stsummarizer/internal/config"
+	"craigstjean.com/stsummarizer/internal/models"
+)
+
+type worldInfoResponse struct {
+	Entries []models.WorldInfoEntry `json:"entries"`
+}
+
+// ExtractWorldInfo asks the model for lore worth remembering (places, characters, items, events) in each chunk of the
+// chat and returns the candidates, merged where they share keys
+func (s *OllamaService) ExtractWorldInfo(ctx context.Context, chatMessages []string, opts SummaryOptions) ([]models.WorldInfoEntry, error) {
+	maxTokens := opts.MaxTokens
+	model := opts.Model
+
+	if maxTokens <= 0 {
+		maxTokens = 4096 - 300 // Reserve room for the extraction instructions
+	}
+	if model == "" {
+		model = config.GetDefaultModel()
+	}
+
+	background := s.fitContext(opts.Context, opts.ContextTokens)
+	if background != "" {
+		maxTokens = max(maxTokens-s.countTokens(background), maxTokens/2)
+	}
+	if maxTokens < MinChunkTokens {
+		return nil, fmt.Errorf("invalid max_tokens: too small for the background")
+	}
+
+	groupedMessages, err := s.splitMessagesByTokenLimit(ctx, chatMessages, nil, maxTokens)
+	if err != nil {
+		return nil, err
+	}
+
+	var candidates []models.WorldInfoEntry
+	for i, group := range groupedMessages {
+		entries, err := s.callWorldInfoExtractor(ctx, model, background, group)
+		if err != nil {
+			return nil, fmt.Errorf("failed to extract world info from group %d: %w", i, err)
+		}
+
+		for _, entry := range entries {
+			candidates = mergeWorldInfoCandidate(candidates, entry)
+		}
+	}
+
+	return candidates, nil
+}
+
+func (s *OllamaService) callWorldInfoExtractor(ctx context.Context, model string, background string, input string) ([]models.WorldInfoEntry, error) {
+	backgroundStr := ""
+	if background != "" {
+		backgroundStr = fmt.Sprintf(`
+
+Already known background (do not create entries for it):
+%s`, background)
+	}
+
+	prompt := fmt.Sprintf(`Extract lore from the following role-play chat for a lorebook: named places, non-player characters, factions, items and important events that would help continue the story later.
+Respond with JSON only, in the form {"entries": [{"keys": ["<name>", "<alias>"], "comment": "<short title>", "content": "<one or two sentences of facts>"}]}.
+Keys are the words that should trigger the entry. Only include facts stated in the chat. If there is nothing worth recording, respond with {"entries": []}.%s
+
+Chat conversation:
+%s`, backgroundStr, input)
+
+	content, err := s.chat(ctx, model, prompt, "json")
+	if err != nil {
+		return nil, err
+	}
+
+	var response worldInfoResponse
+	if err := json.Unmarshal([]byte(content), &response); err != nil {
+		return nil, fmt.Errorf("failed to parse world info response: %w", err)
+	}
+
+	var entries []models.WorldInfoEntry
+	for _, entry := range response.Entries {
+		entry.UID = nil
+		entry.Keys = cleanWorldInfoKeys(entry.Keys)
+		entry.Content = strings.TrimSpace(entry.Content)
+		entry.Comment = strings.TrimSpace(entry.Comment)
+		if len(entry.Keys) == 0 || entry.Content == "" {
+			continue
+		}
+		if entry.Comment == "" {
+			entry.Comment = entry.Keys[0]
+		}
+		entries = append(entries, entry)
+	}
+
+	return entries, nil
+}
+
+// DiffWorldInfo compares candidate entries against an existing lorebook. Candidates sharing a key with an existing
+// entry become updates, or duplicates when they add no new content; the rest are added. Candidates updating the same
+// entry are merged into one update, so saving the diff keeps all of them.
+func DiffWorldInfo(lorebook *models.Lorebook, candidates []models.WorldInfoEntry) models.WorldInfoDiff {
+	diff := models.WorldInfoDiff{
+		Added:      []models.WorldInfoEntry{},
+		Updated:    []models.WorldInfoChange{},
+		Duplicates: []models.WorldInfoChange{},
+	}
+
+	var existing []models.WorldInfoEntry
+	if lorebook != nil {
+		diff.World = lorebook.Name
+		existing = lorebook.Entries
+	}
+
+	// updated maps the position of an existing entry to its change in diff.Updated
+	updated := make(map[int]int)
+
+	for _, candidate := range candidates {
+		match := -1
+		for i, entry := range existing {
+			if worldInfoKeysOverlap(entry.Keys, candidate.Keys) {
+				match = i
+				break
+			}
+		}
+
+		if match < 0 {
+			diff.Added = append(diff.Added, candidate)
+			continue
+		}
+
+		current := existing[match]
+		// A later candidate for the same entry builds on the update proposed so far
+		base := current
+		index, seen := updated[match]
+		if seen {
+			base = diff.Updated[index].Proposed
+		}
+
+		if containsNormalized(base.Content, candidate.Content) {
+			diff.Duplicates = append(diff.Duplicates, models.WorldInfoChange{Existing: current, Proposed: candidate})
+			continue
+		}
+
+		proposed := models.WorldInfoEntry{
+			UID:           current.UID,
+			Keys:          cleanWorldInfoKeys(append(append([]string{}, base.Keys...), candidate.Keys...)),
+			SecondaryKeys: current.SecondaryKeys,
+			Comment:       current.Comment,
+			Content:       base.Content + "\n\n" + candidate.Content,
+		}
+		if containsNormalized(candidate.Content, base.Content) {
+			proposed.Content = candidate.Content
+		}
+
+		if seen {
+			diff.Updated[index].Proposed = proposed
+			continue
+		}
+		updated[match] = len(diff.Updated)
+		diff.Updated = append(diff.Updated, models.WorldInfoChange{Existing: current, Proposed: proposed})
+	}
+
+	return diff
+}
+
+// mergeWorldInfoCandidate adds entry to candidates, folding it into an earlier candidate that shares a key
+func mergeWorldInfoCandidate(candidates []models.WorldInfoEntry, entry models.WorldInfoEntry) []models.WorldInfoEntry {
+	for i, candidate := range candidates {
+		if !worldInfoKeysOverlap(candidate.Keys, entry.Keys) {
+			continue
+		}
+
+		candidates[i].Keys = cleanWorldInfoKeys(append(candidate.Keys, entry.Keys...))
+		if !containsNormalized(candidate.Content, entry.Content) {
+			candidates[i].Content = candidate.Content + " " + entry.Content
+		}
+		return candidates
+	}
+
+	return append(candidates, entry)
+}
+
+func worldInfoKeysOverlap(a, b []string) bool {
+	for _, keyA := range a {
+		for _, keyB := range b {
+			if strings.EqualFold(strings.TrimSpace(keyA), strings.TrimSpace(keyB)) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// cleanWorldInfoKeys trims keys and removes empty and case-insensitive duplicate keys
+func cleanWorldInfoKeys(keys []string) []string {
+	seen := make(map[string]bool)
+	cleaned := []string{}
+	for _, key := range keys {
+		key = strings.TrimSpace(key)
+		if key == "" || seen[strings.ToLower(key)] {
+			continue
+		}
+		seen[strings.ToLower(key)] = true
+		cleaned = append(cleaned, key)
+	}
+
+	return cleaned
+}
+
+func containsNormalized(haystack, needle string) bool {
+	normalize := func(text string) string {
+		return strings.Join(strings.Fields(strings.ToLower(text)), " ")
+	}
+
+	return strings.Contains(normalize(haystack), normalize(needle))
+}
@@ -119,15 +119,17 @@ export function ChatContent({
                         <div
                             key={index}
                             className="group p-6 bg-white rounded-lg shadow-sm hover:shadow-md transition-all duration-200 cursor-pointer border border-gray-100 hover:border-blue-200"
-                            onClick={() => onCharacterSelect(character)}
+                            onClick={() => onCharacterSelect(character.name)}
                         >
                             <div className="flex items-center space-x-3">
                                 <div className="p-2 bg-blue-50 rounded-lg group-hover:bg-blue-100">
                                     <UserCircle className="w-6 h-6 text-blue-500" />
                                 </div>
                                 <div>
-                                    <p className="font-semibold text-gray-800 group-hover:text-gray-900">{character}</p>
-                                    <p className="text-sm text-gray-500">View chats</p>
+                                    <p className="font-semibold text-gray-800 group-hover:text-gray-900">{character.display_name}</p>
+                                    <p className="text-sm text-gray-500">
+                                        {character.chat_count} {character.chat_count === 1 ? 'chat' : 'chats'}
+                                    </p>
                                 </div>
                             </div>
                         </div>
@@ -97,7 +97,7 @@ export function useChatState(initialState, fetchAPI, { setLoading, setError, use
                 setUsers(usersData);
 
                 // Handle initial character route
-                if (stableInitialState.current.initialCharacter && charactersData.includes(stableInitialState.current.initialCharacter)) {
+                if (stableInitialState.current.initialCharacter && charactersData.some(c => c.name === stableInitialState.current.initialCharacter)) {
                     const chats = await fetchAPI(`/chats/${encodeURIComponent(stableInitialState.current.initialCharacter)}?${queryParams}`);
                     setCharacterChats(chats);
 